@@ -0,0 +1,129 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+package blocker
+
+import (
+	"net"
+	"time"
+)
+
+// Allow inserts a single IP address into the
+// allowlist.
+//
+// IP addresses in the allowlist are reported as Allowed
+// by (*Client).Check() even if they are also in the
+// blocklist.
+//
+// If presently batching, Allow() will not commit the
+// changes to shared memory.
+//
+// Will fail if Closed() has already been called.
+func (s *Server) Allow(ip net.IP) error {
+	return s.doInsertRemove(&s.allow4s, &s.allow6s, ip, true, 0, 0)
+}
+
+// Disallow removes a single IP address from the
+// allowlist.
+//
+// If presently batching, Disallow() will not commit
+// the changes to shared memory.
+//
+// Will fail if Closed() has already been called.
+func (s *Server) Disallow(ip net.IP) error {
+	return s.doInsertRemove(&s.allow4s, &s.allow6s, ip, false, 0, 0)
+}
+
+// AllowRange inserts all IP addresses in a CIDR block
+// into the allowlist.
+//
+// If presently batching, AllowRange() will not commit
+// the changes to shared memory.
+//
+// Will fail if Closed() has already been called.
+func (s *Server) AllowRange(ip net.IP, ipnet *net.IPNet) error {
+	return s.doInsertRemoveRange(&s.allow4s, &s.allow6s, ip, ipnet, true, 0, 0)
+}
+
+// DisallowRange removes all IP addresses in a CIDR
+// block from the allowlist.
+//
+// If presently batching, DisallowRange() will not
+// commit the changes to shared memory.
+//
+// Will fail if Closed() has already been called.
+func (s *Server) DisallowRange(ip net.IP, ipnet *net.IPNet) error {
+	return s.doInsertRemoveRange(&s.allow4s, &s.allow6s, ip, ipnet, false, 0, 0)
+}
+
+// Result is the result of (*Client).Check().
+type Result int
+
+const (
+	// NotListed is returned by (*Client).Check() for IP
+	// addresses in neither the allowlist nor the
+	// blocklist.
+	NotListed Result = iota
+
+	// Blocked is returned by (*Client).Check() for IP
+	// addresses in the blocklist but not the allowlist.
+	Blocked
+
+	// Allowed is returned by (*Client).Check() for IP
+	// addresses in the allowlist, whether or not they
+	// are also in the blocklist.
+	Allowed
+)
+
+func (r Result) String() string {
+	switch r {
+	case NotListed:
+		return "not listed"
+	case Blocked:
+		return "blocked"
+	case Allowed:
+		return "allowed"
+	default:
+		return "unknown"
+	}
+}
+
+// Check returns whether the IP address is allowed,
+// blocked or in neither list. The allowlist takes
+// precedence over the blocklist.
+func (c *Client) Check(ip net.IP) (res Result, err error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.closed {
+		return NotListed, ErrClosed
+	}
+
+	ip4 := ip.To4()
+	ip6 := ip.To16()
+
+	if ip4 == nil && ip6 == nil {
+		return NotListed, &net.AddrError{Err: "invalid IP address", Addr: ip.String()}
+	}
+
+	now := time.Now().UnixNano()
+
+	err = c.view(func(t *tables) {
+		addr, allows, ips := ip6, &t.allow6s, &t.ip6s
+		if ip4 != nil {
+			addr, allows, ips = ip4, &t.allow4s, &t.ip4s
+		}
+
+		switch {
+		case allows.Contains(addr):
+			res = Allowed
+		case ips.containsUnexpired(addr, now):
+			res = Blocked
+		default:
+			res = NotListed
+		}
+	})
+	return
+}
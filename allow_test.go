@@ -0,0 +1,203 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+package blocker
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func testCheck(t *testing.T, client *Client, expect map[string]Result) {
+	for addr, res := range expect {
+		got, err := client.Check(net.ParseIP(addr))
+		if err != nil {
+			t.Error(err)
+		}
+
+		if got != res {
+			t.Errorf("Check(%s) returned %v, expected %v", addr, got, res)
+		}
+	}
+}
+
+func TestAllow(t *testing.T) {
+	server, client, err := setup(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	for _, cidr := range [...]string{"203.0.113.0/24", "2001:db8::/32"} {
+		ip, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+
+		if err = server.InsertRange(ip, ipnet); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if err = server.Allow(net.ParseIP("203.0.113.7")); err != nil {
+		t.Error(err)
+	}
+
+	ip, ipnet, err := net.ParseCIDR("2001:db8:1::/48")
+	if err != nil {
+		panic(err)
+	}
+
+	if err = server.AllowRange(ip, ipnet); err != nil {
+		t.Error(err)
+	}
+
+	if err = server.Allow(net.ParseIP("198.51.100.0")); err != nil {
+		t.Error(err)
+	}
+
+	testCheck(t, client, map[string]Result{
+		"203.0.113.6":    Blocked,
+		"203.0.113.7":    Allowed,
+		"203.0.113.8":    Blocked,
+		"2001:db8::1":    Blocked,
+		"2001:db8:1::1":  Allowed,
+		"2001:db8:2::1":  Blocked,
+		"198.51.100.0":   Allowed,
+		"192.0.2.0":      NotListed,
+		"2001:db9::":     NotListed,
+		"::ffff:c000:02": NotListed,
+	})
+
+	has, err := client.Contains(net.ParseIP("203.0.113.7"))
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !has {
+		t.Error("allowlist changed the result of Contains")
+	}
+
+	if err = server.Disallow(net.ParseIP("203.0.113.7")); err != nil {
+		t.Error(err)
+	}
+
+	if err = server.DisallowRange(ip, ipnet); err != nil {
+		t.Error(err)
+	}
+
+	testCheck(t, client, map[string]Result{
+		"203.0.113.7":   Blocked,
+		"2001:db8:1::1": Blocked,
+		"198.51.100.0":  Allowed,
+	})
+
+	if err = server.Clear(); err != nil {
+		t.Error(err)
+	}
+
+	testCheck(t, client, map[string]Result{
+		"203.0.113.7":  NotListed,
+		"198.51.100.0": NotListed,
+	})
+}
+
+func TestCheckInvalidAddr(t *testing.T) {
+	server, client, err := setup(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	if _, err = client.Check(net.IP{0, 1, 2}); err == nil {
+		t.Error("Check did not fail for invalid address")
+	}
+
+	client.Close()
+
+	if _, err = client.Check(net.IPv4zero); err != ErrClosed {
+		t.Errorf("Check did not return ErrClosed on closed, got %v", err)
+	}
+}
+
+func TestLoadSaveAllow(t *testing.T) {
+	server1, _, err := setup(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server1.Unlink()
+	defer server1.Close()
+
+	server2, client, err := setup(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server2.Unlink()
+	defer server2.Close()
+	defer client.Close()
+
+	ip, ipnet, err := net.ParseCIDR("203.0.113.0/24")
+	if err != nil {
+		panic(err)
+	}
+
+	if err = server1.InsertRange(ip, ipnet); err != nil {
+		t.Error(err)
+	}
+
+	if err = server1.Allow(net.ParseIP("203.0.113.7")); err != nil {
+		t.Error(err)
+	}
+
+	if err = server1.Allow(net.ParseIP("2001:db8::")); err != nil {
+		t.Error(err)
+	}
+
+	var b bytes.Buffer
+
+	if err = server1.Save(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = server2.Load(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(server1.allow4s.Data, server2.allow4s.Data) {
+		t.Errorf("ip4 allowlist differs after Load, Save")
+	}
+
+	if !bytes.Equal(server1.allow6s.Data, server2.allow6s.Data) {
+		t.Errorf("ip6 allowlist differs after Load, Save")
+	}
+
+	testCheck(t, client, map[string]Result{
+		"203.0.113.6": Blocked,
+		"203.0.113.7": Allowed,
+		"2001:db8::":  Allowed,
+	})
+}
+
+func TestResultString(t *testing.T) {
+	for res, expect := range map[Result]string{
+		NotListed:  "not listed",
+		Blocked:    "blocked",
+		Allowed:    "allowed",
+		Result(-1): "unknown",
+	} {
+		if res.String() != expect {
+			t.Errorf("invalid string for %d, expected %q, got %q", res, expect, res.String())
+		}
+	}
+}
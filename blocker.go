@@ -8,46 +8,53 @@
 package blocker
 
 /*
-#include <stdint.h>         // For int32_t
-#include <semaphore.h>      // For sem_t
+#include <stdint.h> // For uint32_t, uint64_t and int32_t
+#include <stddef.h> // For size_t
 
-#define IP_BLOCKER_MAX_READERS (1 << 30)
+typedef struct {
+	volatile size_t Base;
+	volatile size_t Len;
+} ip_blocker_ip_block_st;
 
 typedef struct {
-	sem_t Sem;
-} ip_blocker_mutex_st;
+	uint8_t Name[48]; // padded with NUL bytes
+	ip_blocker_ip_block_st IP4, IP6;
+} ip_blocker_list_st;
 
 typedef struct {
-	ip_blocker_mutex_st W;        // held if there are pending writers
-	sem_t WriterSem;              // semaphore for writers to wait for completing readers
-	sem_t ReaderSem;              // semaphore for readers to wait for completing writers
-	volatile int32_t ReaderCount; // number of pending readers
-	volatile int32_t ReaderWait;  // number of departing readers
-} ip_blocker_rwlock_st;
+	uint32_t ID;
+	uint8_t Name[60]; // padded with NUL bytes
+} ip_blocker_tag_st;
 
 typedef struct {
-	volatile size_t Base;
-	volatile size_t Len;
-} ip_blocker_ip_block_st;
+	ip_blocker_ip_block_st IP4, IP6;
+	ip_blocker_ip_block_st AllowIP4, AllowIP6;
+
+	ip_blocker_ip_block_st Lists; // an array of ip_blocker_list_st sorted by Name
+	ip_blocker_ip_block_st Tags; // an array of ip_blocker_tag_st sorted by ID
+} ip_blocker_slot_st;
 
 typedef struct {
 	uint32_t Version;
-	volatile uint32_t Revision;
+	volatile uint32_t Revision; // the low bit selects the active slot
 
-	ip_blocker_rwlock_st Lock;
+	volatile uint64_t OwnerToken; // zero if no server owns the shared memory
+	volatile int32_t OwnerPID;
 
-	ip_blocker_ip_block_st IP4, IP6, IP6Route;
+	ip_blocker_slot_st Slots[2];
 } ip_blocker_shm_st;
 */
 import "C"
 
 import "unsafe"
 
-type mutex C.ip_blocker_mutex_st
+type ipBlock C.ip_blocker_ip_block_st
 
-type rwLock C.ip_blocker_rwlock_st
+type listEntry C.ip_blocker_list_st
 
-type ipBlock C.ip_blocker_ip_block_st
+type tagEntry C.ip_blocker_tag_st
+
+type slot C.ip_blocker_slot_st
 
 type shmHeader C.ip_blocker_shm_st
 
@@ -55,21 +62,38 @@ func castToHeader(data *byte) *shmHeader {
 	return (*shmHeader)(unsafe.Pointer(data))
 }
 
-func (h *shmHeader) setBlocks(ip4, ip4len, ip6, ip6len, ip6r, ip6rlen int) {
-	h.IP4.Base = C.size_t(ip4)
-	h.IP4.Len = C.size_t(ip4len)
+func (h *shmHeader) slot(revision uint32) *slot {
+	return &h.Slots[revision&1]
+}
+
+func (s *slot) blocks() [4]*ipBlock {
+	return [...]*ipBlock{&s.IP4, &s.IP6, &s.AllowIP4, &s.AllowIP6}
+}
+
+func (s *slot) setBlocks(ip4, ip4len, ip6, ip6len int) {
+	s.IP4.set(ip4, ip4len)
+	s.IP6.set(ip6, ip6len)
+}
 
-	h.IP6.Base = C.size_t(ip6)
-	h.IP6.Len = C.size_t(ip6len)
+func castToListEntry(data *byte) *listEntry {
+	return (*listEntry)(unsafe.Pointer(data))
+}
 
-	h.IP6Route.Base = C.size_t(ip6r)
-	h.IP6Route.Len = C.size_t(ip6rlen)
+func castToTagEntry(data *byte) *tagEntry {
+	return (*tagEntry)(unsafe.Pointer(data))
+}
+
+func (b *ipBlock) set(base, length int) {
+	b.Base = C.size_t(base)
+	b.Len = C.size_t(length)
 }
 
 const (
 	headerSize = C.sizeof_ip_blocker_shm_st
 
-	rwLockMaxReaders = C.IP_BLOCKER_MAX_READERS
+	listEntrySize = C.sizeof_ip_blocker_list_st
+
+	tagEntrySize = C.sizeof_ip_blocker_tag_st
 
-	version = uint32((^uint(0)>>32)&0x80000000) | 0x00000001
+	version = uint32((^uint(0)>>32)&0x80000000) | 0x00000009
 )
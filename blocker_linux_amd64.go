@@ -5,51 +5,75 @@ package blocker
 
 import "unsafe"
 
-type mutex struct {
-	Sem [32]byte
+type ipBlock struct {
+	Base uint64
+	Len  uint64
 }
 
-type rwLock struct {
-	W           mutex
-	WriterSem   [32]byte
-	ReaderSem   [32]byte
-	ReaderCount int32
-	ReaderWait  int32
+type listEntry struct {
+	Name [48]uint8
+	IP4  ipBlock
+	IP6  ipBlock
 }
 
-type ipBlock struct {
-	Base uint64
-	Len  uint64
+type tagEntry struct {
+	ID   uint32
+	Name [60]uint8
 }
 
-type shmHeader struct {
-	Version  uint32
-	Revision uint32
-	Lock     rwLock
+type slot struct {
 	IP4      ipBlock
 	IP6      ipBlock
-	IP6Route ipBlock
+	AllowIP4 ipBlock
+	AllowIP6 ipBlock
+	Lists    ipBlock
+	Tags     ipBlock
+}
+
+type shmHeader struct {
+	Version    uint32
+	Revision   uint32
+	OwnerToken uint64
+	OwnerPID   int32
+	Slots      [2]slot
 }
 
 func castToHeader(data *byte) *shmHeader {
 	return (*shmHeader)(unsafe.Pointer(data))
 }
 
-func (h *shmHeader) setBlocks(ip4, ip4len, ip6, ip6len, ip6r, ip6rlen int) {
-	h.IP4.Base = uint64(ip4)
-	h.IP4.Len = uint64(ip4len)
+func (h *shmHeader) slot(revision uint32) *slot {
+	return &h.Slots[revision&1]
+}
 
-	h.IP6.Base = uint64(ip6)
-	h.IP6.Len = uint64(ip6len)
+func (s *slot) blocks() [4]*ipBlock {
+	return [...]*ipBlock{&s.IP4, &s.IP6, &s.AllowIP4, &s.AllowIP6}
+}
 
-	h.IP6Route.Base = uint64(ip6r)
-	h.IP6Route.Len = uint64(ip6rlen)
+func (s *slot) setBlocks(ip4, ip4len, ip6, ip6len int) {
+	s.IP4.set(ip4, ip4len)
+	s.IP6.set(ip6, ip6len)
+}
+
+func castToListEntry(data *byte) *listEntry {
+	return (*listEntry)(unsafe.Pointer(data))
+}
+
+func castToTagEntry(data *byte) *tagEntry {
+	return (*tagEntry)(unsafe.Pointer(data))
+}
+
+func (b *ipBlock) set(base, length int) {
+	b.Base = uint64(base)
+	b.Len = uint64(length)
 }
 
 const (
-	headerSize = 0xa0
+	headerSize = 0xd8
+
+	listEntrySize = 0x50
 
-	rwLockMaxReaders = 0x40000000
+	tagEntrySize = 0x40
 
-	version = uint32((^uint(0)>>32)&0x80000000) | 0x00000001
+	version = uint32((^uint(0)>>32)&0x80000000) | 0x00000009
 )
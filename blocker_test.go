@@ -233,7 +233,7 @@ func TestIP6Range(t *testing.T) {
 	testRange(t, []string{"2001:db8::/112"}, "2001:db8::", "2001:db8::1", "2001:db8::f")
 }
 
-func TestIP6RouteRange(t *testing.T) {
+func TestIP6LargeRange(t *testing.T) {
 	testRange(t, []string{"2001:db8::/58"}, "2001:db8::", "2001:db8::1", "2001:db8::f", "2001:db8::dead:beef")
 }
 
@@ -332,13 +332,13 @@ func TestClear2(t *testing.T) {
 		t.Error(err)
 	}
 
-	ip4, ip6, ip6r, err := server.Count()
+	ip4, ip6, err := server.Count()
 	if err != nil {
 		t.Error(err)
 	}
 
-	if ip4 != 0 || ip6 != 0 || ip6r != 0 {
-		t.Errorf("blocklist returned invalid count, expected (0, 0, 0), got (%d, %d, %d)", ip4, ip6, ip6r)
+	if ip4 != 0 || ip6 != 0 {
+		t.Errorf("blocklist returned invalid count, expected (0, 0), got (%d, %d)", ip4, ip6)
 	}
 }
 
@@ -371,13 +371,13 @@ func TestBatch(t *testing.T) {
 		t.Error(err)
 	}
 
-	ip4, ip6, ip6r, err := server.Count()
+	ip4, ip6, err := server.Count()
 	if err != nil {
 		t.Error(err)
 	}
 
-	if ip4 != 0 || ip6 != 0 || ip6r != 0 {
-		t.Errorf("blocklist returned invalid count, expected (0, 0, 0), got (%d, %d, %d)", ip4, ip6, ip6r)
+	if ip4 != 0 || ip6 != 0 {
+		t.Errorf("blocklist returned invalid count, expected (0, 0), got (%d, %d)", ip4, ip6)
 	}
 
 	if err = server.Commit(); err != nil {
@@ -392,13 +392,13 @@ func TestBatch(t *testing.T) {
 		t.Error("still batching")
 	}
 
-	ip4, ip6, ip6r, err = server.Count()
+	ip4, ip6, err = server.Count()
 	if err != nil {
 		t.Error(err)
 	}
 
-	if ip4 != 1 || ip6 != 0 || ip6r != 0 {
-		t.Errorf("blocklist returned invalid count, expected (1, 0, 0), got (%d, %d, %d)", ip4, ip6, ip6r)
+	if ip4 != 1 || ip6 != 0 {
+		t.Errorf("blocklist returned invalid count, expected (1, 0), got (%d, %d)", ip4, ip6)
 	}
 }
 
@@ -481,6 +481,106 @@ func TestUnlink(t *testing.T) {
 	}
 }
 
+func TestAttach(t *testing.T) {
+	server1, client, err := setup(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer client.Close()
+
+	if err = server1.Insert(net.ParseIP("192.0.2.0")); err != nil {
+		t.Error(err)
+	}
+
+	if err = server1.InsertWithTTL(net.ParseIP("2001:db8::"), time.Hour); err != nil {
+		t.Error(err)
+	}
+
+	if err = server1.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	header := castToHeader(&client.data[0])
+	revision := atomic.LoadUint32((*uint32)(&header.Revision))
+
+	server2, err := Attach(client.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server2.Unlink()
+	defer server2.Close()
+
+	if server2.ip4s.Len() != 1 || server2.ip6s.Len() != 1 {
+		t.Errorf("Attach did not restore blocklist, got %q and %q", formatRanges(&server2.ip4s), formatRanges(&server2.ip6s))
+	}
+
+	if expires := getExpiry(server2.ip6s.value(0)); expires == 0 {
+		t.Error("Attach did not restore expiry")
+	}
+
+	if err = server2.Insert(net.ParseIP("198.51.100.0")); err != nil {
+		t.Error(err)
+	}
+
+	if atomic.LoadUint32((*uint32)(&header.Revision)) != revision+1 {
+		t.Error("Attach did not carry on from current revision")
+	}
+
+	for _, addr := range [...]string{"192.0.2.0", "198.51.100.0", "2001:db8::"} {
+		has, err := client.Contains(net.ParseIP(addr))
+		if err != nil {
+			t.Error(err)
+		}
+
+		if !has {
+			t.Errorf("blocklist does not contain entry after Attach: %s", addr)
+		}
+	}
+
+	if _, err = Attach(client.Name()); err != ErrWriterAlive {
+		t.Errorf("Attach did not return ErrWriterAlive for running server, got %v", err)
+	}
+}
+
+func TestAttachInvalid(t *testing.T) {
+	if _, err := Attach("/go-test-does-not-exist"); !os.IsNotExist(err) {
+		t.Errorf("Attach did not fail for non-existent shared memory, got %v", err)
+	}
+
+	server, _, err := setup(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+
+	header := castToHeader(&server.data[0])
+	token := atomic.LoadUint64((*uint64)(&header.OwnerToken))
+	header.release(token)
+
+	atomic.StoreUint32((*uint32)(&header.Version), 0)
+
+	if _, err = Attach(server.Name()); err != ErrInvalidSharedMemory {
+		t.Errorf("Attach did not return ErrInvalidSharedMemory for invalid version, got %v", err)
+	}
+
+	atomic.StoreUint32((*uint32)(&header.Version), version)
+
+	active := header.slot(header.Revision)
+	active.IP4.Base, active.IP4.Len = 0, 32
+
+	if _, err = Attach(server.Name()); err != ErrInvalidSharedMemory {
+		t.Errorf("Attach did not return ErrInvalidSharedMemory for invalid header, got %v", err)
+	}
+
+	if atomic.LoadUint64((*uint64)(&header.OwnerToken)) != 0 {
+		t.Error("Attach did not release ownership after failing")
+	}
+}
+
 func TestServerName(t *testing.T) {
 	server, _, err := setup(false)
 	if err != nil {
@@ -528,163 +628,163 @@ func TestServerCount(t *testing.T) {
 		panic(err)
 	}
 
-	ip4, ip6, ip6r, err := server.Count()
+	ip4, ip6, err := server.Count()
 	if err != nil {
 		t.Error(err)
 	}
 
-	if ip4 != 0 || ip6 != 0 || ip6r != 0 {
-		t.Errorf("blocklist returned invalid count, expected (0, 0, 0), got (%d, %d, %d)", ip4, ip6, ip6r)
+	if ip4 != 0 || ip6 != 0 {
+		t.Errorf("blocklist returned invalid count, expected (0, 0), got (%d, %d)", ip4, ip6)
 	}
 
 	if err = server.Insert(net.ParseIP("192.0.2.0")); err != nil {
 		t.Error(err)
 	}
 
-	ip4, ip6, ip6r, err = server.Count()
+	ip4, ip6, err = server.Count()
 	if err != nil {
 		t.Error(err)
 	}
 
-	if ip4 != 1 || ip6 != 0 || ip6r != 0 {
-		t.Errorf("blocklist returned invalid count, expected (1, 0, 0), got (%d, %d, %d)", ip4, ip6, ip6r)
+	if ip4 != 1 || ip6 != 0 {
+		t.Errorf("blocklist returned invalid count, expected (1, 0), got (%d, %d)", ip4, ip6)
 	}
 
 	if err = server.Insert(net.ParseIP("2001:db8::")); err != nil {
 		t.Error(err)
 	}
 
-	ip4, ip6, ip6r, err = server.Count()
+	ip4, ip6, err = server.Count()
 	if err != nil {
 		t.Error(err)
 	}
 
-	if ip4 != 1 || ip6 != 1 || ip6r != 0 {
-		t.Errorf("blocklist returned invalid count, expected (1, 1, 0), got (%d, %d, %d)", ip4, ip6, ip6r)
+	if ip4 != 1 || ip6 != 1 {
+		t.Errorf("blocklist returned invalid count, expected (1, 1), got (%d, %d)", ip4, ip6)
 	}
 
 	if err = server.InsertRange(cidr, cidrnet); err != nil {
 		t.Error(err)
 	}
 
-	ip4, ip6, ip6r, err = server.Count()
+	ip4, ip6, err = server.Count()
 	if err != nil {
 		t.Error(err)
 	}
 
-	if ip4 != 1 || ip6 != 1 || ip6r != 1 {
-		t.Errorf("blocklist returned invalid count, expected (1, 1, 1), got (%d, %d, %d)", ip4, ip6, ip6r)
+	if ip4 != 1 || ip6 != 1 {
+		t.Errorf("blocklist returned invalid count, expected (1, 1), got (%d, %d)", ip4, ip6)
 	}
 
 	if err = server.Remove(net.ParseIP("2001:db8::")); err != nil {
 		t.Error(err)
 	}
 
-	ip4, ip6, ip6r, err = server.Count()
+	ip4, ip6, err = server.Count()
 	if err != nil {
 		t.Error(err)
 	}
 
-	if ip4 != 1 || ip6 != 0 || ip6r != 1 {
-		t.Errorf("blocklist returned invalid count, expected (1, 0, 1), got (%d, %d, %d)", ip4, ip6, ip6r)
+	if ip4 != 1 || ip6 != 1 {
+		t.Errorf("blocklist returned invalid count, expected (1, 1), got (%d, %d)", ip4, ip6)
 	}
 
 	if err = server.RemoveRange(cidr, cidrnet); err != nil {
 		t.Error(err)
 	}
 
-	ip4, ip6, ip6r, err = server.Count()
+	ip4, ip6, err = server.Count()
 	if err != nil {
 		t.Error(err)
 	}
 
-	if ip4 != 1 || ip6 != 0 || ip6r != 0 {
-		t.Errorf("blocklist returned invalid count, expected (1, 0, 0), got (%d, %d, %d)", ip4, ip6, ip6r)
+	if ip4 != 1 || ip6 != 0 {
+		t.Errorf("blocklist returned invalid count, expected (1, 0), got (%d, %d)", ip4, ip6)
 	}
 
 	if err = server.Remove(net.ParseIP("192.0.2.0")); err != nil {
 		t.Error(err)
 	}
 
-	ip4, ip6, ip6r, err = server.Count()
+	ip4, ip6, err = server.Count()
 	if err != nil {
 		t.Error(err)
 	}
 
-	if ip4 != 0 || ip6 != 0 || ip6r != 0 {
-		t.Errorf("blocklist returned invalid count, expected (0, 0, 0), got (%d, %d, %d)", ip4, ip6, ip6r)
+	if ip4 != 0 || ip6 != 0 {
+		t.Errorf("blocklist returned invalid count, expected (0, 0), got (%d, %d)", ip4, ip6)
 	}
 
 	if err = server.Clear(); err != nil {
 		t.Error(err)
 	}
 
-	ip4, ip6, ip6r, err = server.Count()
+	ip4, ip6, err = server.Count()
 	if err != nil {
 		t.Error(err)
 	}
 
-	if ip4 != 0 || ip6 != 0 || ip6r != 0 {
-		t.Errorf("blocklist returned invalid count, expected (0, 0, 0), got (%d, %d, %d)", ip4, ip6, ip6r)
+	if ip4 != 0 || ip6 != 0 {
+		t.Errorf("blocklist returned invalid count, expected (0, 0), got (%d, %d)", ip4, ip6)
 	}
 
 	if err = server.Insert(net.ParseIP("2001:db8::")); err != nil {
 		t.Error(err)
 	}
 
-	ip4, ip6, ip6r, err = server.Count()
+	ip4, ip6, err = server.Count()
 	if err != nil {
 		t.Error(err)
 	}
 
-	if ip4 != 0 || ip6 != 1 || ip6r != 0 {
-		t.Errorf("blocklist returned invalid count, expected (0, 1, 0), got (%d, %d, %d)", ip4, ip6, ip6r)
+	if ip4 != 0 || ip6 != 1 {
+		t.Errorf("blocklist returned invalid count, expected (0, 1), got (%d, %d)", ip4, ip6)
 	}
 
 	if err = server.Insert(net.ParseIP("192.0.2.0")); err != nil {
 		t.Error(err)
 	}
 
-	ip4, ip6, ip6r, err = server.Count()
+	ip4, ip6, err = server.Count()
 	if err != nil {
 		t.Error(err)
 	}
 
-	if ip4 != 1 || ip6 != 1 || ip6r != 0 {
-		t.Errorf("blocklist returned invalid count, expected (1, 1, 0), got (%d, %d, %d)", ip4, ip6, ip6r)
+	if ip4 != 1 || ip6 != 1 {
+		t.Errorf("blocklist returned invalid count, expected (1, 1), got (%d, %d)", ip4, ip6)
 	}
 
 	if err = server.InsertRange(cidr, cidrnet); err != nil {
 		t.Error(err)
 	}
 
-	ip4, ip6, ip6r, err = server.Count()
+	ip4, ip6, err = server.Count()
 	if err != nil {
 		t.Error(err)
 	}
 
-	if ip4 != 1 || ip6 != 1 || ip6r != 1 {
-		t.Errorf("blocklist returned invalid count, expected (1, 1, 1), got (%d, %d, %d)", ip4, ip6, ip6r)
+	if ip4 != 1 || ip6 != 1 {
+		t.Errorf("blocklist returned invalid count, expected (1, 1), got (%d, %d)", ip4, ip6)
 	}
 
 	if err = server.Clear(); err != nil {
 		t.Error(err)
 	}
 
-	ip4, ip6, ip6r, err = server.Count()
+	ip4, ip6, err = server.Count()
 	if err != nil {
 		t.Error(err)
 	}
 
-	if ip4 != 0 || ip6 != 0 || ip6r != 0 {
-		t.Errorf("blocklist returned invalid count, expected (0, 0, 0), got (%d, %d, %d)", ip4, ip6, ip6r)
+	if ip4 != 0 || ip6 != 0 {
+		t.Errorf("blocklist returned invalid count, expected (0, 0), got (%d, %d)", ip4, ip6)
 	}
 
 	if server.Close(); err != nil {
 		t.Error(err)
 	}
 
-	if _, _, _, err = server.Count(); err != ErrClosed {
+	if _, _, err = server.Count(); err != ErrClosed {
 		t.Error(err)
 	}
 }
@@ -699,13 +799,13 @@ func TestClientCount(t *testing.T) {
 	defer server.Close()
 	defer client.Close()
 
-	ip4, ip6, ip6r, err := client.Count()
+	ip4, ip6, err := client.Count()
 	if err != nil {
 		t.Error(err)
 	}
 
-	if ip4 != 0 || ip6 != 0 || ip6r != 0 {
-		t.Errorf("blocklist returned invalid count, expected (0, 0, 0), got (%d, %d, %d)", ip4, ip6, ip6r)
+	if ip4 != 0 || ip6 != 0 {
+		t.Errorf("blocklist returned invalid count, expected (0, 0), got (%d, %d)", ip4, ip6)
 	}
 
 	if err = server.Insert(net.ParseIP("192.0.2.0")); err != nil {
@@ -725,33 +825,33 @@ func TestClientCount(t *testing.T) {
 		t.Error(err)
 	}
 
-	ip4, ip6, ip6r, err = client.Count()
+	ip4, ip6, err = client.Count()
 	if err != nil {
 		t.Error(err)
 	}
 
-	if ip4 != 1 || ip6 != 1 || ip6r != 1 {
-		t.Errorf("blocklist returned invalid count, expected (1, 1, 1), got (%d, %d, %d)", ip4, ip6, ip6r)
+	if ip4 != 1 || ip6 != 1 {
+		t.Errorf("blocklist returned invalid count, expected (1, 1), got (%d, %d)", ip4, ip6)
 	}
 
 	if err = server.Clear(); err != nil {
 		t.Error(err)
 	}
 
-	ip4, ip6, ip6r, err = client.Count()
+	ip4, ip6, err = client.Count()
 	if err != nil {
 		t.Error(err)
 	}
 
-	if ip4 != 0 || ip6 != 0 || ip6r != 0 {
-		t.Errorf("blocklist returned invalid count, expected (0, 0, 0), got (%d, %d, %d)", ip4, ip6, ip6r)
+	if ip4 != 0 || ip6 != 0 {
+		t.Errorf("blocklist returned invalid count, expected (0, 0), got (%d, %d)", ip4, ip6)
 	}
 
 	if client.Close(); err != nil {
 		t.Error(err)
 	}
 
-	if _, _, _, err = client.Count(); err != ErrClosed {
+	if _, _, err = client.Count(); err != ErrClosed {
 		t.Error(err)
 	}
 }
@@ -772,7 +872,7 @@ func TestClientCorrupted(t *testing.T) {
 		t.Error(err)
 	}
 
-	if _, _, _, err = client.Count(); err != ErrInvalidSharedMemory {
+	if _, _, err = client.Count(); err != ErrInvalidSharedMemory {
 		t.Error(err)
 	}
 }
@@ -815,13 +915,13 @@ func TestInsertRangeWithLastAlready(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	ip4, ip6, ip6r, err := server.Count()
+	ip4, ip6, err := server.Count()
 	if err != nil {
 		t.Error(err)
 	}
 
-	if ip4 != 257 || ip6 != 0 || ip6r != 0 {
-		t.Errorf("blocklist returned invalid count, expected (257, 0, 0), got (%d, %d, %d)", ip4, ip6, ip6r)
+	if ip4 != 1 || ip6 != 0 {
+		t.Errorf("blocklist returned invalid count, expected (1, 0), got (%d, %d)", ip4, ip6)
 	}
 }
 
@@ -855,13 +955,13 @@ func TestRemoveRangeNonExistAtEnd(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	ip4, ip6, ip6r, err := server.Count()
+	ip4, ip6, err := server.Count()
 	if err != nil {
 		t.Error(err)
 	}
 
-	if ip4 != 1 || ip6 != 0 || ip6r != 0 {
-		t.Errorf("blocklist returned invalid count, expected (1, 0, 0), got (%d, %d, %d)", ip4, ip6, ip6r)
+	if ip4 != 1 || ip6 != 0 {
+		t.Errorf("blocklist returned invalid count, expected (1, 0), got (%d, %d)", ip4, ip6)
 	}
 }
 
@@ -874,22 +974,100 @@ func TestAddLargeRange(t *testing.T) {
 	defer server.Unlink()
 	defer server.Close()
 
-	ip, ipnet, err := net.ParseCIDR("192.0.2.0/12")
+	for _, iprange := range [...]string{"192.0.2.0/12", "192.0.2.0/0", "2001:db8::/65", "2001:db8::/0"} {
+		ip, ipnet, err := net.ParseCIDR(iprange)
+		if err != nil {
+			panic(err)
+		}
+
+		if err = server.InsertRange(ip, ipnet); err != nil {
+			t.Error(err)
+		}
+	}
+
+	ip4, ip6, err := server.Count()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if ip4 != 1 || ip6 != 1 {
+		t.Errorf("blocklist returned invalid count, expected (1, 1), got (%d, %d)", ip4, ip6)
+	}
+}
+
+func TestIP6Prefixes(t *testing.T) {
+	server, client, err := setup(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	for ones := 0; ones <= 8*net.IPv6len; ones++ {
+		ipnet := &net.IPNet{
+			IP:   net.ParseIP("2001:db8::").Mask(net.CIDRMask(ones, 8*net.IPv6len)),
+			Mask: net.CIDRMask(ones, 8*net.IPv6len),
+		}
+
+		if err = server.InsertRange(ipnet.IP, ipnet); err != nil {
+			t.Fatal(err)
+		}
+
+		_, ip6, err := server.Count()
+		if err != nil {
+			t.Error(err)
+		}
+
+		if ip6 != 1 {
+			t.Errorf("InsertRange(%s) did not store a single range, got %d", ipnet, ip6)
+		}
+
+		has, err := client.Contains(net.ParseIP("2001:db8::"))
+		if err != nil {
+			t.Error(err)
+		}
+
+		if !has {
+			t.Errorf("blocklist does not contain 2001:db8:: after InsertRange(%s)", ipnet)
+		}
+
+		if err = server.RemoveRange(ipnet.IP, ipnet); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ip, ipnet, err := net.ParseCIDR("2001:db8::/48")
 	if err != nil {
 		panic(err)
 	}
 
 	if err = server.InsertRange(ip, ipnet); err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
 
-	ip4, ip6, ip6r, err := server.Count()
-	if err != nil {
-		t.Error(err)
+	if err = server.Remove(net.ParseIP("2001:db8::dead:beef")); err != nil {
+		t.Fatal(err)
 	}
 
-	if ip4 != 1<<20 || ip6 != 0 || ip6r != 0 {
-		t.Errorf("blocklist returned invalid count, expected (1048576, 0, 0), got (%d, %d, %d)", ip4, ip6, ip6r)
+	for addr, expect := range map[string]bool{
+		"2001:db8::":                true,
+		"2001:db8::dead:beee":       true,
+		"2001:db8::dead:beef":       false,
+		"2001:db8::dead:bef0":       true,
+		"2001:db8:0:ffff::":         true,
+		"2001:db8:1::":              false,
+		"2001:db7:ffff:ffff:ffff::": false,
+	} {
+		has, err := client.Contains(net.ParseIP(addr))
+		if err != nil {
+			t.Error(err)
+		}
+
+		if has != expect {
+			t.Errorf("Contains(%s) returned %t, expected %t", addr, has, expect)
+		}
 	}
 }
 
@@ -931,38 +1109,6 @@ func TestUnlinkEmptyName(t *testing.T) {
 	}
 }
 
-func testPanic(fn func()) (didPanic bool) {
-	defer func() {
-		didPanic = recover() != nil
-	}()
-
-	fn()
-	return
-}
-
-func TestClosedPanics(t *testing.T) {
-	server, client, err := setup(true)
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	client.Close()
-	server.Close()
-	server.Unlink()
-
-	if !testPanic(func() {
-		client.remap(true)
-	}) {
-		t.Error("(*Client).remap did not panic on closed")
-	}
-
-	if !testPanic(func() {
-		client.checkSharedMemory()
-	}) {
-		t.Error("(*Client).checkSharedMemory did not panic on closed")
-	}
-}
-
 func TestClosedErrors(t *testing.T) {
 	server, client, err := setup(true)
 	if err != nil {
@@ -981,11 +1127,10 @@ func TestClosedErrors(t *testing.T) {
 		}()
 
 		client.mu.RLock()
-		if err := client.remap(false); err != ErrClosed {
+		if _, err := client.remap(false); err != ErrClosed {
 			t.Errorf("(*Client).remap did not return ErrClosed on closed with mutex lock, got %v", err)
-		} else {
-			client.mu.RUnlock()
 		}
+		client.mu.RUnlock()
 	}()
 
 	time.Sleep(50 * time.Millisecond)
@@ -1030,7 +1175,7 @@ func TestClosedErrors(t *testing.T) {
 		t.Errorf("(*Server).Close did not return ErrClosed on closed, got %v", err)
 	}
 
-	if _, _, _, err = server.Count(); err != ErrClosed {
+	if _, _, err = server.Count(); err != ErrClosed {
 		t.Errorf("(*Server).Count did not return ErrClosed on closed, got %v", err)
 	}
 
@@ -1042,7 +1187,7 @@ func TestClosedErrors(t *testing.T) {
 		t.Errorf("(*Client).Close did not return ErrClosed on closed, got %v", err)
 	}
 
-	if _, _, _, err = client.Count(); err != ErrClosed {
+	if _, _, err = client.Count(); err != ErrClosed {
 		t.Errorf("(*Client).Count did not return ErrClosed on closed, got %v", err)
 	}
 
@@ -1226,42 +1371,40 @@ func TestInvalidHeader(t *testing.T) {
 	}
 
 	header := castToHeader(&server.data[0])
-	orig := *header
+	active := header.slot(header.Revision)
+	orig := *active
 
 	const maxInt = int(^uint(0) >> 1)
-	for i, fn := range [...]func(*shmHeader){
-		func(h *shmHeader) { h.IP4.Base, h.IP4.Len = 0, 32 },
-		func(h *shmHeader) { h.IP6.Base, h.IP6.Len = 0, 32 },
-		func(h *shmHeader) { h.IP6Route.Base, h.IP6Route.Len = 0, 32 },
-		func(h *shmHeader) { h.IP4.Base, h.IP4.Len = 0xfffff, 4<<10 },
-		func(h *shmHeader) { h.IP6.Base, h.IP6.Len = 0xfffff, 16<<10 },
-		func(h *shmHeader) { h.IP6Route.Base, h.IP6Route.Len = 0xfffff, 8<<10 },
-		func(h *shmHeader) { h.IP4.Len = 7 },
-		func(h *shmHeader) { h.IP6.Len = 31 },
-		func(h *shmHeader) { h.IP6Route.Len = 15 },
-		func(h *shmHeader) {
-			h.setBlocks(int(h.IP4.Base), maxInt, int(h.IP6.Base), maxInt, int(h.IP6Route.Base), maxInt)
+	for i, fn := range [...]func(*slot){
+		func(s *slot) { s.IP4.Base, s.IP4.Len = 0, 32 },
+		func(s *slot) { s.IP6.Base, s.IP6.Len = 0, 32 },
+		func(s *slot) { s.IP4.Base, s.IP4.Len = 0xfffff, 8<<10 },
+		func(s *slot) { s.IP6.Base, s.IP6.Len = 0xfffff, 32<<10 },
+		func(s *slot) { s.IP4.Len = 7 },
+		func(s *slot) { s.IP6.Len = 31 },
+		func(s *slot) {
+			s.setBlocks(int(s.IP4.Base), maxInt, int(s.IP6.Base), maxInt)
 		},
-		func(h *shmHeader) {
-			h.setBlocks(int(h.IP4.Base), maxInt, int(h.IP6.Base), int(h.IP6.Len), int(h.IP6Route.Base), int(h.IP6Route.Len))
+		func(s *slot) {
+			s.setBlocks(int(s.IP4.Base), maxInt, int(s.IP6.Base), int(s.IP6.Len))
 		},
-		func(h *shmHeader) {
-			h.setBlocks(int(h.IP4.Base), int(h.IP4.Len), int(h.IP6.Base), maxInt, int(h.IP6Route.Base), int(h.IP6Route.Len))
+		func(s *slot) {
+			s.setBlocks(int(s.IP4.Base), int(s.IP4.Len), int(s.IP6.Base), maxInt)
 		},
-		func(h *shmHeader) {
-			h.setBlocks(int(h.IP4.Base), int(h.IP4.Len), int(h.IP6.Base), int(h.IP6.Len), int(h.IP6Route.Base), maxInt)
+		func(s *slot) {
+			s.setBlocks(int(s.IP4.Base), 0xfffff, int(s.IP6.Base), int(s.IP6.Len))
 		},
-		func(h *shmHeader) {
-			h.setBlocks(int(h.IP4.Base), 0xfffff, int(h.IP6.Base), int(h.IP6.Len), int(h.IP6Route.Base), int(h.IP6Route.Len))
+		func(s *slot) {
+			s.setBlocks(int(s.IP4.Base), int(s.IP4.Len), int(s.IP6.Base), 0xfffff)
 		},
-		func(h *shmHeader) {
-			h.setBlocks(int(h.IP4.Base), int(h.IP4.Len), int(h.IP6.Base), 0xfffff, int(h.IP6Route.Base), int(h.IP6Route.Len))
-		},
-		func(h *shmHeader) {
-			h.setBlocks(int(h.IP4.Base), int(h.IP4.Len), int(h.IP6.Base), int(h.IP6.Len), int(h.IP6Route.Base), 0xfffff)
+		func(s *slot) { s.Lists.Base, s.Lists.Len = 0, listEntrySize },
+		func(s *slot) { s.Lists.Len = listEntrySize - 1 },
+		func(s *slot) {
+			/* an entry with an empty name */
+			s.Lists.Len = listEntrySize
 		},
 	} {
-		fn(header)
+		fn(active)
 
 		client, err := Open(server.Name())
 		if err != ErrInvalidSharedMemory {
@@ -1272,73 +1415,123 @@ func TestInvalidHeader(t *testing.T) {
 			t.Errorf("Open did not return ErrInvalidSharedMemory for invalid header (%d)", i)
 		}
 
-		lock := header.Lock
-		*header = orig
-		header.Lock = lock
+		*active = orig
 	}
 }
 
-func testChangeBeforeLock(t *testing.T, corrupter func(*shmHeader)) {
-	server, _, err := setup(false)
+func TestGrowAfterOpen(t *testing.T) {
+	server, client, err := setup(true)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	defer server.Unlink()
 	defer server.Close()
+	defer client.Close()
 
-	header := castToHeader(&server.data[0])
-	lock := (*rwLock)(&header.Lock)
-	lock.Lock()
+	size := len(client.data)
+
+	if err = server.Batch(); err != nil {
+		t.Error(err)
+	}
+
+	extraIP := make(net.IP, net.IPv6len)
+
+	for i := 0; i < 1000; i++ {
+		rand.Read(extraIP)
+
+		if err = server.Insert(extraIP); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if err = server.Insert(net.ParseIP("2001:db8::")); err != nil {
+		t.Error(err)
+	}
+
+	if err = server.Commit(); err != nil {
+		t.Error(err)
+	}
+
+	has, err := client.Contains(net.ParseIP("2001:db8::"))
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !has {
+		t.Error("blocklist does not contain entry after shared memory grew")
+	}
+
+	if len(client.data) <= size {
+		t.Error("client did not remap shared memory after it grew")
+	}
+}
+
+func TestConcurrentCommit(t *testing.T) {
+	server, client, err := setup(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	ip := net.ParseIP("192.0.2.0")
+
+	if err = server.Insert(ip); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
 
-	done := make(chan struct{}, 1)
 	go func() {
-		defer func() {
-			done <- struct{}{}
-		}()
+		defer close(done)
 
-		client, err := Open(server.Name())
-		if corrupter != nil {
-			if err != ErrInvalidSharedMemory {
-				if err == nil {
-					client.Close()
-				}
+		extraIP := make(net.IP, net.IPv4len)
 
-				t.Error("Open did not return ErrInvalidSharedMemory for invalid header")
+		for {
+			select {
+			case <-stop:
+				return
+			default:
 			}
-		} else {
-			if err != nil {
+
+			rand.Read(extraIP)
+			if extraIP.Equal(ip) {
+				continue
+			}
+
+			if err := server.Insert(extraIP); err != nil {
 				t.Error(err)
-			} else {
-				client.Close()
+				return
+			}
+
+			if err := server.Remove(extraIP); err != nil {
+				t.Error(err)
+				return
 			}
 		}
 	}()
 
-	time.Sleep(50 * time.Millisecond)
-
-	if err = server.file.Truncate(int64(len(server.data)) + 1); err != nil {
-		t.Fatal(err)
-	}
+	for i := 0; i < 10000; i++ {
+		has, err := client.Contains(ip)
+		if err != nil {
+			t.Error(err)
+			break
+		}
 
-	if corrupter != nil {
-		corrupter(header)
+		if !has {
+			t.Error("blocklist does not contain entry during concurrent commits")
+			break
+		}
 	}
 
-	lock.Unlock()
+	close(stop)
 	<-done
 }
 
-func TestChangeBeforeLock(t *testing.T) {
-	testChangeBeforeLock(t, nil)
-}
-
-func TestCorruptChangeBeforeLock(t *testing.T) {
-	testChangeBeforeLock(t, func(h *shmHeader) {
-		h.IP4.Base, h.IP4.Len = 0, 32
-	})
-}
-
 func TestCorruptContains(t *testing.T) {
 	server, client, err := setup(true)
 	if err != nil {
@@ -1351,13 +1544,11 @@ func TestCorruptContains(t *testing.T) {
 
 	header := castToHeader(&server.data[0])
 
-	lock := (*rwLock)(&header.Lock)
-	lock.Lock()
-
-	header.IP6.Base, header.IP6.Len = 0, 32
-	header.Revision++
+	next := header.slot(header.Revision + 1)
+	*next = *header.slot(header.Revision)
+	next.IP6.Base, next.IP6.Len = 0, 32
 
-	lock.Unlock()
+	atomic.AddUint32((*uint32)(&header.Revision), 1)
 
 	if _, err := client.Contains(net.IPv4zero); err != ErrInvalidSharedMemory {
 		t.Error("Contains did not return ErrInvalidSharedMemory for corrupt header")
@@ -1377,22 +1568,27 @@ func TestClientRemapAlreadyDone(t *testing.T) {
 	client.mu.RLock()
 	client.mu.RLock()
 
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
 		defer client.mu.RUnlock()
 
-		if err := client.remap(true); err != nil {
+		if _, err := client.remap(true); err != nil {
 			t.Error(err)
 		}
 	}()
 
-	defer client.mu.RUnlock()
-
-	if err := client.remap(false); err != nil {
+	if remapped, err := client.remap(false); err != nil {
 		t.Error(err)
+	} else if remapped {
+		t.Error("remap remapped unchanged shared memory")
 	}
+
+	client.mu.RUnlock()
+	<-done
 }
 
-func TestClientRemapLockFailure(t *testing.T) {
+func TestClientRemapTooShort(t *testing.T) {
 	server, client, err := setup(true)
 	if err != nil {
 		t.Fatal(err)
@@ -1406,66 +1602,52 @@ func TestClientRemapLockFailure(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if _, ok := client.remap(true).(LockReleaseFailedError); !ok {
-		t.Error("remap managed to release invalid lock")
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+
+	if _, err := client.remap(true); err != ErrInvalidSharedMemory {
+		t.Errorf("remap did not return ErrInvalidSharedMemory for too short memory, got %v", err)
 	}
 }
 
 func TestInsertRangeMassive(t *testing.T) {
-	if testing.Short() {
-		t.Skip("skipping test in short mode.")
-	}
-
-	var mask string
-	var expect int
-
-	switch os.Getenv("INSERTMASSIVERANGETEST") {
-	case "1":
-		mask = "/2"
-		expect = 1073741824
-	case "2":
-		mask = "/1"
-		expect = 2147483648
-
-		if ^uint(0) == uint(^uint32(0)) {
-			t.Skip("INSERTMASSIVERANGETEST=2 cannot be run on 32-bit systems")
-		}
-	case "3":
-		mask = "/0"
-
-		expect = 4294967296 - 1
-		expect++
-
-		if ^uint(0) == uint(^uint32(0)) {
-			t.Skip("INSERTMASSIVERANGETEST=3 cannot be run on 32-bit systems")
-		}
-	default:
-		t.Skip("INSERTMASSIVERANGETEST is not set to 1, 2 or 3")
-	}
-
-	server, _, err := setup(false)
+	server, client, err := setup(true)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	defer server.Unlink()
 	defer server.Close()
+	defer client.Close()
 
-	if err = server.Batch(); err != nil {
-		t.Error(err)
-	}
+	for _, mask := range [...]string{"/2", "/1", "/0"} {
+		ip, ipnet, err := net.ParseCIDR("192.0.2.0" + mask)
+		if err != nil {
+			panic(err)
+		}
 
-	ip, ipnet, err := net.ParseCIDR("192.0.2.0" + mask)
-	if err != nil {
-		panic(err)
-	}
+		if err = server.InsertRange(ip, ipnet); err != nil {
+			t.Error(err)
+		}
 
-	if err = server.InsertRange(ip, ipnet); err != nil {
-		t.Error(err)
-	}
+		if c := server.ip4s.Len(); c != 1 {
+			t.Errorf("InsertRange(192.0.2.0%s) failed, expected count of 1 ip4 range, got %d", mask, c)
+		}
 
-	if c := len(server.ip4s.Data) / net.IPv4len; c != expect {
-		t.Errorf("InsertRange(192.0.2.0%s) failed, expected count of %d ip4 address, got %d", mask, expect, c)
+		for _, addr := range [...]string{"192.0.2.0", "192.255.255.255", "255.255.255.255"} {
+			has, err := client.Contains(net.ParseIP(addr))
+			if err != nil {
+				t.Error(err)
+			}
+
+			if !has {
+				t.Errorf("blocklist does not contain entry after InsertRange(192.0.2.0%s): %s", mask, addr)
+			}
+		}
+
+		if err = server.RemoveRange(ip, ipnet); err != nil {
+			t.Error(err)
+		}
 	}
 }
 
@@ -1533,9 +1715,6 @@ func TestLoadSave(t *testing.T) {
 		t.Errorf("ip6 data differs after Load, Save")
 	}
 
-	if !bytes.Equal(server1.ip6rs.Data, server2.ip6rs.Data) {
-		t.Errorf("ip6 route data differs after Load, Save")
-	}
 }
 
 func TestLoadNotBatching(t *testing.T) {
@@ -1879,23 +2058,19 @@ func BenchmarkClientRemap(b *testing.B) {
 	defer server.Close()
 	defer client.Close()
 
-	header := castToHeader(&client.data[0])
-	lock := (*rwLock)(&header.Lock)
-	lock.RLock()
+	client.mu.RLock()
 
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
-		if err = client.remap(true); err != nil {
+		if _, err = client.remap(true); err != nil {
 			b.Fatal(err)
 		}
 	}
 
 	b.StopTimer()
 
-	header = castToHeader(&client.data[0])
-	lock = (*rwLock)(&header.Lock)
-	lock.RUnlock()
+	client.mu.RUnlock()
 }
 
 func benchmarkInsertRemoveRange(b *testing.B, insert bool, iprange string, extra int) {
@@ -1982,7 +2157,7 @@ func BenchmarkInsertRangeIP6NoSearch116(b *testing.B) {
 	benchmarkInsertRemoveRange(b, true, "2001:db8::/116", 0)
 }
 
-func BenchmarkInsertRangeIP6RouteNoSearch52(b *testing.B) {
+func BenchmarkInsertRangeIP6NoSearch52(b *testing.B) {
 	benchmarkInsertRemoveRange(b, true, "2001:db8::/52", 0)
 }
 
@@ -1998,7 +2173,7 @@ func BenchmarkInsertRangeIP6116(b *testing.B) {
 	benchmarkInsertRemoveRange(b, true, "2001:db8::/116", 100000)
 }
 
-func BenchmarkInsertRangeIP6Route52(b *testing.B) {
+func BenchmarkInsertRangeIP652(b *testing.B) {
 	benchmarkInsertRemoveRange(b, true, "2001:db8::/52", 100000)
 }
 
@@ -2014,7 +2189,7 @@ func BenchmarkRemoveRangeIP6NoSearch116(b *testing.B) {
 	benchmarkInsertRemoveRange(b, false, "2001:db8::/116", 0)
 }
 
-func BenchmarkRemoveRangeIP6RouteNoSearch52(b *testing.B) {
+func BenchmarkRemoveRangeIP6NoSearch52(b *testing.B) {
 	benchmarkInsertRemoveRange(b, false, "2001:db8::/52", 0)
 }
 
@@ -2030,7 +2205,7 @@ func BenchmarkRemoveRangeIP6116(b *testing.B) {
 	benchmarkInsertRemoveRange(b, false, "2001:db8::/116", 100000)
 }
 
-func BenchmarkRemoveRangeIP6Route52(b *testing.B) {
+func BenchmarkRemoveRangeIP652(b *testing.B) {
 	benchmarkInsertRemoveRange(b, false, "2001:db8::/52", 100000)
 }
 
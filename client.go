@@ -10,8 +10,8 @@ import (
 	"os"
 	"sync"
 	"sync/atomic"
+	"time"
 
-	"github.com/tmthrgd/binary-searcher"
 	"github.com/tmthrgd/go-shm"
 	"golang.org/x/sys/unix"
 )
@@ -24,8 +24,6 @@ type Client struct {
 
 	mu sync.RWMutex
 
-	revision uint32
-
 	closed bool
 }
 
@@ -58,6 +56,7 @@ func Open(name string) (*Client, error) {
 	header := castToHeader(&data[0])
 
 	if atomic.LoadUint32((*uint32)(&header.Version)) != version {
+		unix.Munmap(data)
 		file.Close()
 		return nil, ErrInvalidSharedMemory
 	}
@@ -68,140 +67,122 @@ func Open(name string) (*Client, error) {
 		data: data,
 	}
 
-	lock := (*rwLock)(&header.Lock)
-	lock.RLock()
-
-	client.revision = uint32(header.Revision)
+	client.mu.RLock()
+	err = client.view(func(t *tables) {})
+	client.mu.RUnlock()
 
-	stat, err = file.Stat()
 	if err != nil {
-		lock.RUnlock()
-
-		unix.Munmap(data)
+		unix.Munmap(client.data)
 		file.Close()
 		return nil, err
 	}
 
-	if stat.Size() != size {
-		/* shm has changed since we mmaped it (unlikely but possible) */
+	return client, nil
+}
 
-		/* RUnlock is called inside of remap iff an err is returned */
-		if err := client.remap(true); err != nil {
-			file.Close()
-			return nil, err
-		}
+/* c.mu must be read locked before calling remap */
+func (c *Client) remap(force bool) (remapped bool, err error) {
+	c.mu.RUnlock()
+	c.mu.Lock()
+	defer func() {
+		c.mu.Unlock()
+		c.mu.RLock()
+	}()
 
-		header = castToHeader(&client.data[0])
-		lock = (*rwLock)(&header.Lock)
-	} else if !client.checkSharedMemory() {
-		lock.RUnlock()
+	if c.closed {
+		return false, ErrClosed
+	}
 
-		unix.Munmap(data)
-		file.Close()
-		return nil, ErrInvalidSharedMemory
+	stat, err := c.file.Stat()
+	if err != nil {
+		return false, err
 	}
 
-	lock.RUnlock()
-	return client, nil
-}
+	if stat.Size() < int64(headerSize) {
+		return false, ErrInvalidSharedMemory
+	}
 
-/* RLock must be held before calling remap */
-func (c *Client) remap(force bool) (err error) {
-	if c.closed {
-		panic(ErrClosed)
+	if !force && int(stat.Size()) == len(c.data) {
+		return false, nil
 	}
 
-	if !force {
-		c.mu.RUnlock()
-		c.mu.Lock()
-		defer func() {
-			c.mu.Unlock()
-			c.mu.RLock()
+	data, err := unix.Mmap(int(c.file.Fd()), 0, int(stat.Size()), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		return false, err
+	}
 
-			if err != nil {
-				return
-			}
+	if err = unix.Munmap(c.data); err != nil {
+		unix.Munmap(data)
+		return false, err
+	}
 
-			header := castToHeader(&c.data[0])
-			if c.revision != uint32(header.Revision) {
-				err = c.remap(false)
-			}
-		}()
+	c.data = data
+	return true, nil
+}
 
+// view calls fn with the range tables of the current
+// revision. If the server commits a new revision before
+// fn returns, the tables fn was given may have been
+// overwritten and so fn is called again with the new
+// tables.
+//
+// c.mu must be read locked when calling view.
+func (c *Client) view(fn func(t *tables)) error {
+	return c.viewRevision(func(t *tables, revision uint32) {
+		fn(t)
+	})
+}
+
+// viewRevision is like view but also passes the revision
+// the tables belong to to fn.
+func (c *Client) viewRevision(fn func(t *tables, revision uint32)) error {
+	for {
 		if c.closed {
 			return ErrClosed
 		}
 
-		header := castToHeader(&c.data[0])
-		if c.revision == uint32(header.Revision) {
-			return nil
+		if len(c.data) < int(headerSize) {
+			return ErrInvalidSharedMemory
 		}
-	}
-
-	data := c.data
-	c.data = nil
-
-	stat, err := c.file.Stat()
-	if err != nil {
-		goto err
-	}
 
-	c.data, err = unix.Mmap(int(c.file.Fd()), 0, int(stat.Size()), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
-	if err != nil {
-		goto err
-	}
-
-	if !c.checkSharedMemory() {
-		err = ErrInvalidSharedMemory
-		goto err
-	}
+		header := castToHeader(&c.data[0])
+		revision := atomic.LoadUint32((*uint32)(&header.Revision))
 
-	c.revision = uint32(castToHeader(&c.data[0]).Revision)
+		t, ok := loadTables(c.data, header.slot(revision))
+		if ok {
+			fn(&t, revision)
+		}
 
-	err = unix.Munmap(data)
-	return
+		if atomic.LoadUint32((*uint32)(&header.Revision)) != revision {
+			continue
+		}
 
-err:
-	if len(c.data) == 0 || len(c.data) >= int(headerSize) {
-		header := castToHeader(&data[0])
-		lock := (*rwLock)(&header.Lock)
-		lock.RUnlock()
-	} else {
-		err = LockReleaseFailedError{err}
-	}
+		if ok {
+			return nil
+		}
 
-	unix.Munmap(data)
-	return
-}
+		/* the server may have grown the shared memory since it was mapped */
+		remapped, err := c.remap(false)
+		if err != nil {
+			return err
+		}
 
-func (c *Client) checkSharedMemory() bool {
-	if c.closed {
-		panic(ErrClosed)
+		if !remapped {
+			return ErrInvalidSharedMemory
+		}
 	}
-
-	header := castToHeader(&c.data[0])
-
-	const maxInt = int(^uint(0) >> 1)
-	return len(c.data) >= int(headerSize) &&
-		uintptr(headerSize)+uintptr(header.IP4.Len+header.IP6.Len+header.IP6Route.Len) <= uintptr(maxInt) &&
-		len(c.data) >= int(headerSize)+int(header.IP4.Len+header.IP6.Len+header.IP6Route.Len) &&
-		(header.IP4.Len == 0 || uintptr(header.IP4.Base) >= headerSize) &&
-		(header.IP6.Len == 0 || uintptr(header.IP6.Base) >= headerSize) &&
-		(header.IP6Route.Len == 0 || uintptr(header.IP6Route.Base) >= headerSize) &&
-		uintptr(header.IP4.Base)+uintptr(header.IP4.Len) <= uintptr(maxInt) &&
-		uintptr(header.IP6.Base)+uintptr(header.IP6.Len) <= uintptr(maxInt) &&
-		uintptr(header.IP6Route.Base)+uintptr(header.IP6Route.Len) <= uintptr(maxInt) &&
-		int(uintptr(header.IP4.Base)+uintptr(header.IP4.Len)) <= len(c.data) &&
-		int(uintptr(header.IP6.Base)+uintptr(header.IP6.Len)) <= len(c.data) &&
-		int(uintptr(header.IP6Route.Base)+uintptr(header.IP6Route.Len)) <= len(c.data) &&
-		header.IP4.Len%4 == 0 &&
-		header.IP6.Len%16 == 0 &&
-		header.IP6Route.Len%8 == 0
 }
 
 // Contains returns a boolean indicating whether the
 // IP address is in the blocklist.
-func (c *Client) Contains(ip net.IP) (bool, error) {
+//
+// IP addresses that were inserted with a TTL are not
+// reported once the TTL has elapsed, even if the server
+// has not yet removed them.
+//
+// Contains does not consult the allowlist, use Check()
+// for that.
+func (c *Client) Contains(ip net.IP) (has bool, err error) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
@@ -209,59 +190,23 @@ func (c *Client) Contains(ip net.IP) (bool, error) {
 		return false, ErrClosed
 	}
 
-	if len(c.data) < int(headerSize) {
-		return false, ErrInvalidSharedMemory
-	}
-
-	header := castToHeader(&c.data[0])
-	lock := (*rwLock)(&header.Lock)
+	ip4 := ip.To4()
+	ip6 := ip.To16()
 
-	lock.RLock()
-
-	if c.revision != uint32(header.Revision) {
-		/* RUnlock is called inside of remap iff an error is returned */
-		if err := c.remap(false); err != nil {
-			return false, err
-		}
-
-		header = castToHeader(&c.data[0])
-		lock = (*rwLock)(&header.Lock)
+	if ip4 == nil && ip6 == nil {
+		return false, &net.AddrError{Err: "invalid IP address", Addr: ip.String()}
 	}
 
-	defer lock.RUnlock()
-
-	if ip4 := ip.To4(); ip4 != nil {
-		ip = ip4
-
-		if header.IP4.Len == 0 {
-			return false, nil
-		}
-
-		end := int(header.IP4.Base) + int(header.IP4.Len)
-		searcher := searcher.New(c.data[header.IP4.Base:end:end], net.IPv4len)
-		return searcher.Contains(ip), nil
-	} else if ip6 := ip.To16(); ip6 != nil {
-		ip = ip6
-
-		if header.IP6Route.Len != 0 {
-			end := int(header.IP6Route.Base) + int(header.IP6Route.Len)
-			searcher := searcher.New(c.data[header.IP6Route.Base:end:end], net.IPv6len/2)
+	now := time.Now().UnixNano()
 
-			if searcher.Contains(ip[:net.IPv6len/2]) {
-				return true, nil
-			}
+	err = c.view(func(t *tables) {
+		if ip4 != nil {
+			has = t.ip4s.containsUnexpired(ip4, now)
+		} else {
+			has = t.ip6s.containsUnexpired(ip6, now)
 		}
-
-		if header.IP6.Len == 0 {
-			return false, nil
-		}
-
-		end := int(header.IP6.Base) + int(header.IP6.Len)
-		searcher := searcher.New(c.data[header.IP6.Base:end:end], net.IPv6len)
-		return searcher.Contains(ip), nil
-	} else {
-		return false, &net.AddrError{Err: "invalid IP address", Addr: ip.String()}
-	}
+	})
+	return
 }
 
 // Close closes the blockers shared memory and
@@ -292,11 +237,14 @@ func (c *Client) Name() string {
 	return c.file.Name()
 }
 
-// Count returns the number of IPv4 addresses, IPv6
-// address and IPv6 routes stored in the blocklist.
+// Count returns the number of IPv4 ranges and IPv6
+// ranges stored in the blocklist.
+//
+// Overlapping and adjacent ranges are merged, so a
+// single IP address counts as one range.
 //
 // Will fail if Closed() has been called.
-func (c *Client) Count() (ip4, ip6, ip6routes int, err error) {
+func (c *Client) Count() (ip4, ip6 int, err error) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
@@ -305,20 +253,8 @@ func (c *Client) Count() (ip4, ip6, ip6routes int, err error) {
 		return
 	}
 
-	if len(c.data) < int(headerSize) {
-		err = ErrInvalidSharedMemory
-		return
-	}
-
-	header := castToHeader(&c.data[0])
-
-	lock := (*rwLock)(&header.Lock)
-	lock.RLock()
-
-	ip4 = int(header.IP4.Len / net.IPv4len)
-	ip6 = int(header.IP6.Len / net.IPv6len)
-	ip6routes = int(header.IP6Route.Len / (net.IPv6len / 2))
-
-	lock.RUnlock()
+	err = c.view(func(t *tables) {
+		ip4, ip6 = t.ip4s.Len(), t.ip6s.Len()
+	})
 	return
 }
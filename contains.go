@@ -0,0 +1,195 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+package blocker
+
+import (
+	"bytes"
+	"net"
+	"time"
+)
+
+// netRange returns the first and last IP addresses in
+// ipnet, four bytes long for an IPv4 network and sixteen
+// bytes long otherwise.
+func netRange(ipnet *net.IPNet) (first, last []byte, err error) {
+	if ipnet == nil {
+		return nil, nil, &net.AddrError{Err: "invalid CIDR block", Addr: "<nil>"}
+	}
+
+	masked := ipnet.IP.Mask(ipnet.Mask)
+	if masked == nil {
+		return nil, nil, &net.AddrError{Err: "invalid CIDR block", Addr: ipnet.String()}
+	}
+
+	if ip4 := masked.To4(); ip4 != nil {
+		masked = ip4
+	}
+
+	return masked, lastAddr(masked, ipnet.Mask), nil
+}
+
+// overlapsUnexpired returns a boolean indicating whether
+// any range in t that has not expired by now overlaps
+// [first, last].
+func (t *rangeTable) overlapsUnexpired(first, last []byte, now int64) bool {
+	for i := t.searchEnd(first); i < t.Len() && bytes.Compare(t.start(i), last) <= 0; i++ {
+		if !isExpired(t.value(i), now) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// coversUnexpired returns a boolean indicating whether
+// every address in [first, last] is covered by a range in
+// t that has not expired by now.
+func (t *rangeTable) coversUnexpired(first, last []byte, now int64) bool {
+	next := append([]byte(nil), first...)
+
+	for i := t.searchEnd(first); i < t.Len(); i++ {
+		if bytes.Compare(t.start(i), next) > 0 || isExpired(t.value(i), now) {
+			return false
+		}
+
+		if bytes.Compare(t.end(i), last) >= 0 {
+			return true
+		}
+
+		/* t.end(i) < last so this cannot overflow */
+		copy(next, t.end(i))
+		incrBytes(next)
+	}
+
+	return false
+}
+
+// overlapping returns the smallest set of CIDR blocks
+// that covers every address in [first, last] that is also
+// covered by a range in t that has not expired by now.
+//
+// t may be in shared memory, so each range is copied out
+// and any range whose start is after its end, as can
+// happen with a torn read, is skipped.
+func (t *rangeTable) overlapping(first, last []byte, now int64) []*net.IPNet {
+	var nets []*net.IPNet
+	var lo, hi []byte
+
+	for i := t.searchEnd(first); i < t.Len() && bytes.Compare(t.start(i), last) <= 0; i++ {
+		if isExpired(t.value(i), now) {
+			continue
+		}
+
+		start := append([]byte(nil), t.start(i)...)
+		end := append([]byte(nil), t.end(i)...)
+		if bytes.Compare(start, end) > 0 {
+			continue
+		}
+
+		if bytes.Compare(start, first) < 0 {
+			start = first
+		}
+
+		if bytes.Compare(end, last) > 0 {
+			end = last
+		}
+
+		/* adjacent ranges with different values are reported together */
+		if hi != nil && isNext(hi, start) {
+			hi = end
+			continue
+		}
+
+		if lo != nil {
+			nets = appendCIDRs(nets, lo, hi)
+		}
+
+		lo, hi = start, end
+	}
+
+	if lo != nil {
+		nets = appendCIDRs(nets, lo, hi)
+	}
+
+	return nets
+}
+
+// containsRange looks up the CIDR block in the blocklist
+// with fn.
+func (c *Client) containsRange(ipnet *net.IPNet, fn func(t *rangeTable, first, last []byte, now int64) bool) (has bool, err error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.closed {
+		return false, ErrClosed
+	}
+
+	first, last, err := netRange(ipnet)
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now().UnixNano()
+
+	err = c.view(func(t *tables) {
+		if len(first) == net.IPv4len {
+			has = fn(&t.ip4s, first, last, now)
+		} else {
+			has = fn(&t.ip6s, first, last, now)
+		}
+	})
+	return
+}
+
+// ContainsAny returns a boolean indicating whether any
+// IP address in the CIDR block is in the blocklist.
+//
+// Like Contains, ContainsAny does not consult the
+// allowlist and ignores ranges whose TTL has elapsed.
+func (c *Client) ContainsAny(ipnet *net.IPNet) (has bool, err error) {
+	return c.containsRange(ipnet, (*rangeTable).overlapsUnexpired)
+}
+
+// ContainsAll returns a boolean indicating whether every
+// IP address in the CIDR block is in the blocklist.
+//
+// Like Contains, ContainsAll does not consult the
+// allowlist and ignores ranges whose TTL has elapsed.
+func (c *Client) ContainsAll(ipnet *net.IPNet) (has bool, err error) {
+	return c.containsRange(ipnet, (*rangeTable).coversUnexpired)
+}
+
+// Overlapping returns the parts of the CIDR block that
+// are in the blocklist as the smallest set of CIDR blocks
+// that covers them, in ascending order. It returns nil if
+// no IP address in the CIDR block is in the blocklist.
+//
+// Like Contains, Overlapping does not consult the
+// allowlist and ignores ranges whose TTL has elapsed.
+func (c *Client) Overlapping(ipnet *net.IPNet) (nets []*net.IPNet, err error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.closed {
+		return nil, ErrClosed
+	}
+
+	first, last, err := netRange(ipnet)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UnixNano()
+
+	err = c.view(func(t *tables) {
+		if len(first) == net.IPv4len {
+			nets = t.ip4s.overlapping(first, last, now)
+		} else {
+			nets = t.ip6s.overlapping(first, last, now)
+		}
+	})
+	return
+}
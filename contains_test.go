@@ -0,0 +1,235 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+package blocker
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestContainsAnyAll(t *testing.T) {
+	server, client, err := setup(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	if err = server.Batch(); err != nil {
+		t.Fatal(err)
+	}
+
+	/* adjacent ranges with different tags are stored as separate entries */
+	for i, cidr := range [...]string{"192.0.2.0/25", "192.0.2.128/25", "2001:db8::/64"} {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+
+		if err = server.InsertRangeTagged(ipnet.IP, ipnet, Tag(i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, ipnet, err := net.ParseCIDR("198.51.100.0/24")
+	if err != nil {
+		panic(err)
+	}
+
+	if err = server.InsertRangeWithTTL(ipnet.IP, ipnet, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = server.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	/* keep the expired range in shared memory */
+	if err = server.Batch(); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	for _, test := range [...]struct {
+		cidr     string
+		any, all bool
+	}{
+		{"192.0.2.0/24", true, true},
+		{"192.0.2.64/26", true, true},
+		{"192.0.2.120/29", true, true},
+		{"192.0.2.0/23", true, false},
+		{"192.0.0.0/16", true, false},
+		{"0.0.0.0/0", true, false},
+		{"192.0.3.0/24", false, false},
+		{"198.51.100.0/24", false, false},
+		{"2001:db8::/64", true, true},
+		{"2001:db8::/48", true, false},
+		{"2001:db8:0:1::/64", false, false},
+		{"::/0", true, false},
+		{"::ffff:192.0.2.0/120", true, true},
+	} {
+		_, ipnet, err := net.ParseCIDR(test.cidr)
+		if err != nil {
+			panic(err)
+		}
+
+		any, err := client.ContainsAny(ipnet)
+		if err != nil {
+			t.Error(err)
+		}
+
+		all, err := client.ContainsAll(ipnet)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if any != test.any || all != test.all {
+			t.Errorf("%s returned (any: %t, all: %t), expected (any: %t, all: %t)", test.cidr, any, all, test.any, test.all)
+		}
+	}
+}
+
+func TestContainsAnyAllInvalid(t *testing.T) {
+	server, client, err := setup(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	for _, ipnet := range [...]*net.IPNet{
+		nil,
+		{IP: net.IP{192, 0, 2}, Mask: net.CIDRMask(24, 32)},
+	} {
+		if _, err := client.ContainsAny(ipnet); err == nil {
+			t.Errorf("ContainsAny did not fail for %v", ipnet)
+		}
+
+		if _, err := client.ContainsAll(ipnet); err == nil {
+			t.Errorf("ContainsAll did not fail for %v", ipnet)
+		}
+	}
+
+	client.Close()
+
+	if _, err := client.ContainsAny(&net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)}); err != ErrClosed {
+		t.Errorf("ContainsAny did not return ErrClosed after Close, got %v", err)
+	}
+}
+
+func TestOverlapping(t *testing.T) {
+	server, client, err := setup(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	if err = server.Batch(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, cidr := range [...]string{"192.0.2.0/25", "192.0.2.128/25", "192.0.4.0/31", "203.0.113.0/24", "2001:db8::/64"} {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+
+		if err = server.InsertRangeTagged(ipnet.IP, ipnet, Tag(i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = server.Remove(net.ParseIP("203.0.113.0")); err != nil {
+		t.Fatal(err)
+	}
+
+	_, ipnet, err := net.ParseCIDR("198.51.100.0/24")
+	if err != nil {
+		panic(err)
+	}
+
+	if err = server.InsertRangeWithTTL(ipnet.IP, ipnet, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = server.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	/* keep the expired range in shared memory */
+	if err = server.Batch(); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	for _, test := range [...]struct {
+		cidr   string
+		expect []string
+	}{
+		{"192.0.2.0/24", []string{"192.0.2.0/24"}},
+		{"192.0.2.64/26", []string{"192.0.2.64/26"}},
+		{"192.0.0.0/16", []string{"192.0.2.0/24", "192.0.4.0/31"}},
+		{"192.0.3.0/24", nil},
+		{"198.51.100.0/24", nil},
+		{"203.0.113.0/30", []string{"203.0.113.1/32", "203.0.113.2/31"}},
+		{"2001:db8::/32", []string{"2001:db8::/64"}},
+		{"2001:db8::/126", []string{"2001:db8::/126"}},
+	} {
+		_, ipnet, err := net.ParseCIDR(test.cidr)
+		if err != nil {
+			panic(err)
+		}
+
+		nets, err := client.Overlapping(ipnet)
+		if err != nil {
+			t.Error(err)
+		}
+
+		var got []string
+		for _, ipnet := range nets {
+			got = append(got, ipnet.String())
+		}
+
+		if !reflect.DeepEqual(got, test.expect) {
+			t.Errorf("%s returned %v, expected %v", test.cidr, got, test.expect)
+		}
+	}
+
+	if _, err = client.Overlapping(nil); err == nil {
+		t.Error("Overlapping did not fail for nil CIDR block")
+	}
+}
+
+func TestOverlappingInverted(t *testing.T) {
+	/* an entry that was torn by a concurrent write */
+	table := rangeTable{Size: net.IPv4len, ValueSize: valueSize}
+	table.Data = append(table.Data, 192, 0, 2, 1, 192, 0, 2, 2)
+	table.Data = append(table.Data, make([]byte, valueSize)...)
+	table.Data = append(table.Data, 192, 0, 2, 6, 192, 0, 2, 3)
+	table.Data = append(table.Data, make([]byte, valueSize)...)
+	table.Data = append(table.Data, 192, 0, 2, 8, 192, 0, 2, 8)
+	table.Data = append(table.Data, make([]byte, valueSize)...)
+
+	var nets []string
+	for _, ipnet := range table.overlapping([]byte{192, 0, 2, 0}, []byte{192, 0, 2, 255}, time.Now().UnixNano()) {
+		nets = append(nets, ipnet.String())
+	}
+
+	if expect := []string{"192.0.2.1/32", "192.0.2.2/32", "192.0.2.8/32"}; !reflect.DeepEqual(nets, expect) {
+		t.Errorf("overlapping returned %v, expected %v", nets, expect)
+	}
+}
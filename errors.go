@@ -27,9 +27,50 @@ var (
 	// the time of the call.
 	ErrInvalidSharedMemory = errors.New("invalid shared memory")
 
-	errRangeTooLarge = errors.New("range too large")
+	// ErrInvalidTTL will be returned on attempts to call
+	// (*Server).InsertWithTTL() or
+	// (*Server).InsertRangeWithTTL() with a TTL that is not
+	// positive.
+	ErrInvalidTTL = errors.New("invalid TTL")
+
+	// ErrWriterAlive will be returned by Recover() if the
+	// server that owns the shared memory is still running.
+	ErrWriterAlive = errors.New("shared memory owner is still running")
+
+	// ErrInvalidListName will be returned by
+	// (*Server).List() if the name is empty, too long or
+	// contains a NUL byte.
+	ErrInvalidListName = errors.New("invalid list name")
+
+	// ErrInvalidTag will be returned by
+	// (*Server).SetTagName() if the tag is zero or if the
+	// name is too long or contains a NUL byte.
+	ErrInvalidTag = errors.New("invalid tag")
+
+	// ErrInvalidMetadataKey will be returned by
+	// (*Server).SaveWithMetadata() if a metadata key is
+	// empty or contains a NUL byte.
+	ErrInvalidMetadataKey = errors.New("invalid metadata key")
+
+	// ErrSnapshotTooLarge will be the Err field of the
+	// InvalidDataError returned by (*Server).Load() if
+	// the serialized blocklist is larger than the limit
+	// set with (*Server).SetMaxLoadSize().
+	ErrSnapshotTooLarge = errors.New("serialized blocklist too large")
+
+	// ErrInvalidExportFormat will be returned by
+	// (*Server).Export() and (*Client).Export() if the
+	// format is not one of the ExportFormat constants,
+	// and by ParseExportFormat() if the name is unknown.
+	ErrInvalidExportFormat = errors.New("invalid export format")
 
 	errInvalidHeader = errors.New("invalid header")
+
+	errInvalidSection = errors.New("invalid section")
+
+	errUnsortedRanges = errors.New("ranges not sorted")
+
+	errChecksum = errors.New("checksum mismatch")
 )
 
 // InvalidDataError will be returned by (*Server).Load() if the reader
@@ -49,10 +90,8 @@ func (e InvalidDataError) Error() string {
 // LockReleaseFailedError records that a lock could not
 // be released and any error that was occuring.
 //
-// LockReleaseFailedError are serious errors and may mean
-// that shared memory is in an invalid state and no further
-// writes to shared memory by the server process will be
-// possible.
+// Deprecated: shared memory is no longer protected by a
+// lock and LockReleaseFailedError is never returned.
 type LockReleaseFailedError struct {
 	Err error
 }
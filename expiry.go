@@ -0,0 +1,413 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+package blocker
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"time"
+)
+
+// Each range in a blocklist is stored with the time it
+// expires at as nanoseconds since the Unix epoch, or zero
+// if the range never expires, at the start of its value.
+const expirySize = 8
+
+func getExpiry(value []byte) int64 {
+	if len(value) < expirySize {
+		return 0
+	}
+
+	return int64(binary.BigEndian.Uint64(value))
+}
+
+func isExpired(value []byte, now int64) bool {
+	expires := getExpiry(value)
+	return expires != 0 && expires <= now
+}
+
+// containsUnexpired returns a boolean indicating whether
+// ip is covered by any range in t that has not expired by
+// now.
+func (t *rangeTable) containsUnexpired(ip []byte, now int64) bool {
+	i := t.Index(ip)
+	return i >= 0 && !isExpired(t.value(i), now)
+}
+
+// laterExpiry returns whichever of the two times expires
+// last, treating ranges that never expire as expiring
+// after all others.
+func laterExpiry(a, b int64) int64 {
+	if a == 0 || b == 0 {
+		return 0
+	}
+
+	if a > b {
+		return a
+	}
+
+	return b
+}
+
+// expire removes all ranges in t that expire at or before
+// now and returns whether any ranges were removed.
+func (t *rangeTable) expire(now int64) (removed bool) {
+	size := t.entrySize()
+	data := t.Data[:0]
+
+	for pos := 0; pos < len(t.Data); pos += size {
+		entry := t.Data[pos : pos+size]
+
+		if isExpired(entry[2*t.Size:], now) {
+			removed = true
+			continue
+		}
+
+		data = append(data, entry...)
+	}
+
+	t.Data = data
+	return
+}
+
+// nextExpiry returns the earliest time any range in t
+// expires at, or zero if no ranges expire.
+func (t *rangeTable) nextExpiry() (next int64) {
+	for i := 0; i < t.Len(); i++ {
+		if expires := getExpiry(t.value(i)); expires != 0 && (next == 0 || expires < next) {
+			next = expires
+		}
+	}
+
+	return
+}
+
+// project returns the ranges in t, each followed by the
+// size bytes of its value that start at offset. Unless
+// all is true, ranges where those bytes are all zero are
+// left out.
+func (t *rangeTable) project(offset, size int, all bool) []byte {
+	var data []byte
+	if all {
+		data = make([]byte, 0, t.Len()*(2*t.Size+size))
+	}
+
+	for i := 0; i < t.Len(); i++ {
+		part := t.value(i)[offset : offset+size]
+
+		if all || !isZero(part) {
+			data = append(data, t.start(i)...)
+			data = append(data, t.end(i)...)
+			data = append(data, part...)
+		}
+	}
+
+	return data
+}
+
+// withValues returns the ranges in t with size zero
+// bytes appended to the value of each.
+func (t *rangeTable) withValues(size int) []byte {
+	entrySize := t.entrySize()
+	data := make([]byte, 0, t.Len()*(entrySize+size))
+
+	for pos := 0; pos < len(t.Data); pos += entrySize {
+		data = append(data, t.Data[pos:pos+entrySize]...)
+		data = append(data, make([]byte, size)...)
+	}
+
+	return data
+}
+
+// applyValues overwrites the part of the value that
+// starts at offset of each range in t that is covered by
+// a range in e with the value of the range in e. Those
+// parts of the ranges in e that t does not cover are
+// ignored.
+func (t *rangeTable) applyValues(e *rangeTable, offset int) {
+	for i := 0; i < e.Len(); i++ {
+		first, last := e.start(i), e.end(i)
+
+		var parts [][]byte
+
+		for k := t.searchEnd(first); k < t.Len() && bytes.Compare(t.start(k), last) <= 0; k++ {
+			start, end := t.start(k), t.end(k)
+
+			if bytes.Compare(start, first) < 0 {
+				start = first
+			}
+
+			if bytes.Compare(end, last) > 0 {
+				end = last
+			}
+
+			parts = append(parts, append(append([]byte(nil), start...), end...))
+		}
+
+		value := e.value(i)
+
+		for _, part := range parts {
+			t.Insert(part[:t.Size], part[t.Size:], nil, func(old, _ []byte) []byte {
+				v := append([]byte(nil), old...)
+				copy(v[offset:], value)
+				return v
+			})
+		}
+	}
+}
+
+// applyExpiry sets the expiry of each range in t that is
+// covered by a range in e to that of the range in e,
+// removing any range that has already expired by now.
+func (t *rangeTable) applyExpiry(e *rangeTable, now int64) {
+	t.applyValues(e, 0)
+	t.expire(now)
+}
+
+// scheduleReap arranges for expired ranges to be removed
+// at expires if that is earlier than the next scheduled
+// removal.
+//
+// s.mu must be held when calling scheduleReap.
+func (s *Server) scheduleReap(expires int64) {
+	if expires == 0 || (s.nextReap != 0 && s.nextReap <= expires) {
+		return
+	}
+
+	s.nextReap = expires
+
+	d := time.Duration(expires - time.Now().UnixNano())
+
+	if s.reaper == nil {
+		s.reaper = time.AfterFunc(d, s.reap)
+	} else {
+		s.reaper.Reset(d)
+	}
+}
+
+// scheduleNextReap schedules the removal of the earliest
+// expiring range in the blocklist or any named list.
+//
+// s.mu must be held when calling scheduleNextReap.
+func (s *Server) scheduleNextReap() {
+	s.nextReap = 0
+
+	for _, table := range s.blocklists() {
+		s.scheduleReap(table.nextExpiry())
+	}
+}
+
+// reap removes all expired ranges from the blocklist and
+// the named lists and commits the changes to shared
+// memory in one go.
+func (s *Server) reap() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	now := time.Now().UnixNano()
+
+	var removed bool
+
+	for _, table := range s.blocklists() {
+		if table.expire(now) {
+			removed = true
+		}
+	}
+
+	s.scheduleNextReap()
+
+	if !removed || s.batching {
+		return
+	}
+
+	// Clients already treat expired ranges as absent, so
+	// if this fails the removal will simply be published
+	// by the next successful commit.
+	s.commit()
+}
+
+// InsertWithTTL inserts a single IP address into the
+// blocklist and removes it again once ttl has elapsed.
+//
+// If the IP address is already in the blocklist, it
+// will be removed at whichever is the later of its
+// existing expiry and ttl. IP addresses inserted with
+// Insert() never expire.
+//
+// Clients treat the IP address as absent from the
+// blocklist as soon as ttl has elapsed, even before
+// it has been removed from shared memory.
+//
+// If presently batching, InsertWithTTL() will not
+// commit the changes to shared memory.
+//
+// Will fail if Closed() has already been called.
+func (s *Server) InsertWithTTL(ip net.IP, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+
+	return s.doInsertRemove(&s.ip4s, &s.ip6s, ip, true, time.Now().Add(ttl).UnixNano(), 0)
+}
+
+// InsertRangeWithTTL inserts all IP addresses in a
+// CIDR block into the blocklist and removes them
+// again once ttl has elapsed.
+//
+// It follows the same rules as InsertWithTTL() for
+// IP addresses that are already in the blocklist.
+//
+// If presently batching, InsertRangeWithTTL() will
+// not commit the changes to shared memory.
+//
+// Will fail if Closed() has already been called.
+func (s *Server) InsertRangeWithTTL(ip net.IP, ipnet *net.IPNet, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+
+	return s.doInsertRemoveRange(&s.ip4s, &s.ip6s, ip, ipnet, true, time.Now().Add(ttl).UnixNano(), 0)
+}
+
+// Entry describes the range of the blocklist that an IP
+// address was found in by (*Client).LookupEntry() or
+// (*Client).CheckEntry().
+type Entry struct {
+	// List is the name of the named list the range is
+	// in, or the empty string for the default blocklist.
+	List string
+
+	// Tag is the tag the range was inserted with, or
+	// zero if it is untagged.
+	Tag Tag
+
+	// TagName is the name of Tag, or the empty string if
+	// it has not been given one.
+	TagName string
+
+	// Expires is the time the range expires at, or the
+	// zero time if it never expires.
+	Expires time.Time
+}
+
+// LookupEntry returns the range of the blocklist that the
+// IP address is in and a boolean indicating whether the
+// IP address is in the blocklist at all.
+//
+// LookupEntry does not consult the allowlist.
+func (c *Client) LookupEntry(ip net.IP) (e Entry, has bool, err error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.closed {
+		return Entry{}, false, ErrClosed
+	}
+
+	ip4 := ip.To4()
+	ip6 := ip.To16()
+
+	if ip4 == nil && ip6 == nil {
+		return Entry{}, false, &net.AddrError{Err: "invalid IP address", Addr: ip.String()}
+	}
+
+	now := time.Now().UnixNano()
+
+	err = c.view(func(t *tables) {
+		e, has = t.entry("", ip4, ip6, now)
+	})
+	return
+}
+
+// entry returns the range of the named list that the IP
+// address is in, where ip4 is nil for IPv6 addresses.
+func (t *tables) entry(name string, ip4, ip6 []byte, now int64) (e Entry, has bool) {
+	ip4s, ip6s := t.blocklist([]byte(name))
+	if ip4s == nil {
+		return Entry{}, false
+	}
+
+	addr, ips := ip6, ip6s
+	if ip4 != nil {
+		addr, ips = ip4, ip4s
+	}
+
+	i := ips.Index(addr)
+	if i < 0 || isExpired(ips.value(i), now) {
+		return Entry{}, false
+	}
+
+	value := ips.value(i)
+
+	e.List, e.Tag = name, getTag(value)
+	e.TagName = string(t.tagName(e.Tag))
+
+	if expires := getExpiry(value); expires != 0 {
+		e.Expires = time.Unix(0, expires)
+	}
+
+	return e, true
+}
+
+// CheckEntry is like Check() but also returns the range
+// that the IP address was found in if it is Blocked. The
+// allowlist and the lists are consulted together, so the
+// result and the range are always consistent.
+//
+// If names are given, the IP address is Blocked if it is
+// in any of the named lists, which are searched in order.
+// The empty name refers to the default blocklist and
+// lists that do not exist are treated as empty. If no
+// names are given, only the default blocklist is
+// searched.
+func (c *Client) CheckEntry(ip net.IP, names ...string) (res Result, e Entry, err error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.closed {
+		return NotListed, Entry{}, ErrClosed
+	}
+
+	ip4 := ip.To4()
+	ip6 := ip.To16()
+
+	if ip4 == nil && ip6 == nil {
+		return NotListed, Entry{}, &net.AddrError{Err: "invalid IP address", Addr: ip.String()}
+	}
+
+	if len(names) == 0 {
+		names = []string{""}
+	}
+
+	now := time.Now().UnixNano()
+
+	err = c.view(func(t *tables) {
+		res, e = NotListed, Entry{}
+
+		addr, allows := ip6, &t.allow6s
+		if ip4 != nil {
+			addr, allows = ip4, &t.allow4s
+		}
+
+		if allows.Contains(addr) {
+			res = Allowed
+			return
+		}
+
+		for _, name := range names {
+			var has bool
+			if e, has = t.entry(name, ip4, ip6, now); has {
+				res = Blocked
+				return
+			}
+		}
+	})
+	return
+}
@@ -0,0 +1,415 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+package blocker
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestInsertWithTTL(t *testing.T) {
+	server, client, err := setup(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	if err = server.InsertWithTTL(net.ParseIP("192.0.2.0"), 100*time.Millisecond); err != nil {
+		t.Error(err)
+	}
+
+	_, ipnet, err := net.ParseCIDR("2001:db8::/64")
+	if err != nil {
+		panic(err)
+	}
+
+	if err = server.InsertRangeWithTTL(ipnet.IP, ipnet, 100*time.Millisecond); err != nil {
+		t.Error(err)
+	}
+
+	for _, addr := range [...]string{"192.0.2.0", "2001:db8::1"} {
+		has, err := client.Contains(net.ParseIP(addr))
+		if err != nil {
+			t.Error(err)
+		}
+
+		if !has {
+			t.Errorf("blocklist does not contain entry before TTL elapsed: %s", addr)
+		}
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	ip4, ip6, err := server.Count()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if ip4 != 0 || ip6 != 0 {
+		t.Errorf("expired entries were not removed, got %d ip4 and %d ip6 ranges", ip4, ip6)
+	}
+
+	for _, addr := range [...]string{"192.0.2.0", "2001:db8::1"} {
+		has, err := client.Contains(net.ParseIP(addr))
+		if err != nil {
+			t.Error(err)
+		}
+
+		if has {
+			t.Errorf("blocklist contains entry after TTL elapsed: %s", addr)
+		}
+	}
+}
+
+func TestContainsExpiredBeforeReap(t *testing.T) {
+	server, client, err := setup(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	if err = server.Batch(); err != nil {
+		t.Error(err)
+	}
+
+	if err = server.InsertWithTTL(net.ParseIP("192.0.2.0"), 50*time.Millisecond); err != nil {
+		t.Error(err)
+	}
+
+	if err = server.Commit(); err != nil {
+		t.Error(err)
+	}
+
+	if err = server.Batch(); err != nil {
+		t.Error(err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	// The server is batching, so the expired entry is
+	// still in shared memory.
+	ip4, _, err := client.Count()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if ip4 != 1 {
+		t.Errorf("expected 1 ip4 range in shared memory, got %d", ip4)
+	}
+
+	has, err := client.Contains(net.ParseIP("192.0.2.0"))
+	if err != nil {
+		t.Error(err)
+	}
+
+	if has {
+		t.Error("blocklist contains entry after TTL elapsed")
+	}
+}
+
+func TestTTLMerge(t *testing.T) {
+	server, _, err := setup(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+
+	ip := net.ParseIP("192.0.2.0")
+
+	if err = server.InsertWithTTL(ip, time.Hour); err != nil {
+		t.Error(err)
+	}
+
+	if err = server.InsertWithTTL(ip, time.Minute); err != nil {
+		t.Error(err)
+	}
+
+	if expires := getExpiry(server.ip4s.value(0)); time.Duration(expires-time.Now().UnixNano()) < 59*time.Minute {
+		t.Error("shorter TTL replaced longer TTL")
+	}
+
+	if err = server.InsertWithTTL(ip, 2*time.Hour); err != nil {
+		t.Error(err)
+	}
+
+	if expires := getExpiry(server.ip4s.value(0)); time.Duration(expires-time.Now().UnixNano()) < time.Hour+59*time.Minute {
+		t.Error("longer TTL did not replace shorter TTL")
+	}
+
+	if err = server.Insert(ip); err != nil {
+		t.Error(err)
+	}
+
+	if expires := getExpiry(server.ip4s.value(0)); expires != 0 {
+		t.Error("Insert did not remove TTL")
+	}
+
+	if err = server.InsertWithTTL(ip, time.Minute); err != nil {
+		t.Error(err)
+	}
+
+	if expires := getExpiry(server.ip4s.value(0)); expires != 0 {
+		t.Error("InsertWithTTL added TTL to permanent entry")
+	}
+
+	_, ipnet, err := net.ParseCIDR("192.0.2.0/24")
+	if err != nil {
+		panic(err)
+	}
+
+	if err = server.InsertRangeWithTTL(ipnet.IP, ipnet, time.Minute); err != nil {
+		t.Error(err)
+	}
+
+	if got := formatRanges(&server.ip4s); got != "192.0.2.0,192.0.2.1-192.0.2.255" {
+		t.Errorf("invalid ip4 ranges, got %q", got)
+	}
+
+	if expires := getExpiry(server.ip4s.value(0)); expires != 0 {
+		t.Error("InsertRangeWithTTL added TTL to permanent entry")
+	}
+
+	if expires := getExpiry(server.ip4s.value(1)); expires == 0 {
+		t.Error("InsertRangeWithTTL did not add TTL")
+	}
+}
+
+func TestInvalidTTL(t *testing.T) {
+	server, _, err := setup(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+
+	if err = server.InsertWithTTL(net.ParseIP("192.0.2.0"), 0); err != ErrInvalidTTL {
+		t.Errorf("InsertWithTTL did not return ErrInvalidTTL, got %v", err)
+	}
+
+	_, ipnet, err := net.ParseCIDR("192.0.2.0/24")
+	if err != nil {
+		panic(err)
+	}
+
+	if err = server.InsertRangeWithTTL(ipnet.IP, ipnet, -time.Second); err != ErrInvalidTTL {
+		t.Errorf("InsertRangeWithTTL did not return ErrInvalidTTL, got %v", err)
+	}
+}
+
+func TestLoadSaveTTL(t *testing.T) {
+	server1, _, err := setup(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server1.Unlink()
+	defer server1.Close()
+
+	server2, client, err := setup(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server2.Unlink()
+	defer server2.Close()
+	defer client.Close()
+
+	if err = server1.Insert(net.ParseIP("192.0.2.0")); err != nil {
+		t.Error(err)
+	}
+
+	if err = server1.InsertWithTTL(net.ParseIP("192.0.2.1"), time.Hour); err != nil {
+		t.Error(err)
+	}
+
+	if err = server1.InsertWithTTL(net.ParseIP("192.0.2.2"), 100*time.Millisecond); err != nil {
+		t.Error(err)
+	}
+
+	_, ipnet, err := net.ParseCIDR("2001:db8::/64")
+	if err != nil {
+		panic(err)
+	}
+
+	if err = server1.InsertRangeWithTTL(ipnet.IP, ipnet, time.Hour); err != nil {
+		t.Error(err)
+	}
+
+	var b bytes.Buffer
+
+	if err = server1.Save(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = server2.Load(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	server1.mu.Lock()
+	server2.mu.Lock()
+
+	if !bytes.Equal(server1.ip4s.Data, server2.ip4s.Data) {
+		t.Errorf("ip4 data differs after Load, Save")
+	}
+
+	if !bytes.Equal(server1.ip6s.Data, server2.ip6s.Data) {
+		t.Errorf("ip6 data differs after Load, Save")
+	}
+
+	server2.mu.Unlock()
+	server1.mu.Unlock()
+
+	time.Sleep(200 * time.Millisecond)
+
+	server2.mu.Lock()
+	if got := formatRanges(&server2.ip4s); got != "192.0.2.0,192.0.2.1" {
+		t.Errorf("expired entry was not removed after Load, got %q", got)
+	}
+	server2.mu.Unlock()
+
+	for _, addr := range [...]string{"192.0.2.0", "192.0.2.1", "2001:db8::1"} {
+		has, err := client.Contains(net.ParseIP(addr))
+		if err != nil {
+			t.Error(err)
+		}
+
+		if !has {
+			t.Errorf("blocklist does not contain entry after Load: %s", addr)
+		}
+	}
+}
+
+func TestLookupEntry(t *testing.T) {
+	server, client, err := setup(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	if err = server.InsertTagged(net.ParseIP("192.0.2.0"), 3); err != nil {
+		t.Error(err)
+	}
+
+	if err = server.SetTagName(3, "spam"); err != nil {
+		t.Error(err)
+	}
+
+	before := time.Now()
+
+	if err = server.InsertWithTTL(net.ParseIP("2001:db8::"), time.Hour); err != nil {
+		t.Error(err)
+	}
+
+	e, has, err := client.LookupEntry(net.ParseIP("192.0.2.0"))
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !has || e.Tag != 3 || e.TagName != "spam" || !e.Expires.IsZero() {
+		t.Errorf("LookupEntry returned (%+v, %t), expected tag 3 named spam that never expires", e, has)
+	}
+
+	e, has, err = client.LookupEntry(net.ParseIP("2001:db8::"))
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !has || e.Tag != 0 || e.TagName != "" || e.Expires.Before(before.Add(time.Hour)) || e.Expires.After(time.Now().Add(time.Hour)) {
+		t.Errorf("LookupEntry returned (%+v, %t), expected untagged entry that expires in an hour", e, has)
+	}
+
+	if _, has, err = client.LookupEntry(net.ParseIP("192.0.2.1")); err != nil || has {
+		t.Errorf("LookupEntry returned (%t, %v) for IP address not in blocklist", has, err)
+	}
+
+	if _, _, err = client.LookupEntry(net.IP{192, 0, 2}); err == nil {
+		t.Error("LookupEntry did not fail for invalid IP address")
+	}
+}
+
+func TestCheckEntry(t *testing.T) {
+	server, client, err := setup(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	if err = server.InsertTagged(net.ParseIP("192.0.2.0"), 3); err != nil {
+		t.Error(err)
+	}
+
+	if err = server.SetTagName(3, "spam"); err != nil {
+		t.Error(err)
+	}
+
+	if err = server.Insert(net.ParseIP("192.0.2.7")); err != nil {
+		t.Error(err)
+	}
+
+	if err = server.Allow(net.ParseIP("192.0.2.7")); err != nil {
+		t.Error(err)
+	}
+
+	list, err := server.List("abuse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = list.InsertWithTTL(net.ParseIP("2001:db8::"), time.Hour); err != nil {
+		t.Error(err)
+	}
+
+	for _, test := range [...]struct {
+		ip    string
+		names []string
+
+		res  Result
+		list string
+		tag  Tag
+		name string
+		ttl  bool
+	}{
+		{"192.0.2.0", nil, Blocked, "", 3, "spam", false},
+		{"192.0.2.0", []string{"abuse", ""}, Blocked, "", 3, "spam", false},
+		{"192.0.2.0", []string{"abuse"}, NotListed, "", 0, "", false},
+		{"192.0.2.7", nil, Allowed, "", 0, "", false},
+		{"2001:db8::", nil, NotListed, "", 0, "", false},
+		{"2001:db8::", []string{"", "abuse"}, Blocked, "abuse", 0, "", true},
+		{"2001:db8::", []string{"missing", "abuse"}, Blocked, "abuse", 0, "", true},
+		{"192.0.2.1", []string{"", "abuse"}, NotListed, "", 0, "", false},
+	} {
+		res, e, err := client.CheckEntry(net.ParseIP(test.ip), test.names...)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if res != test.res || e.List != test.list || e.Tag != test.tag || e.TagName != test.name || e.Expires.IsZero() == test.ttl {
+			t.Errorf("CheckEntry(%s, %q) returned (%s, %+v), expected %s in list %q with tag %d named %q (expires: %t)",
+				test.ip, test.names, res, e, test.res, test.list, test.tag, test.name, test.ttl)
+		}
+	}
+
+	if _, _, err = client.CheckEntry(net.IP{192, 0, 2}); err == nil {
+		t.Error("CheckEntry did not fail for invalid IP address")
+	}
+}
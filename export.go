@@ -0,0 +1,230 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+package blocker
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// ExportFormat is a text format that the blocklist can
+// be exported in.
+type ExportFormat int
+
+const (
+	// ExportText writes one CIDR block per line.
+	ExportText ExportFormat = iota
+
+	// ExportIPSet writes a script for `ipset restore`
+	// that creates the hash:net sets ip-blocker4 and
+	// ip-blocker6 and adds each CIDR block to them.
+	ExportIPSet
+
+	// ExportNFT writes a script for `nft -f` that
+	// creates the interval sets blocklist4 and
+	// blocklist6 in the inet table ip-blocker and adds
+	// each CIDR block to them.
+	ExportNFT
+)
+
+var exportFormatNames = [...]string{
+	ExportText:  "text",
+	ExportIPSet: "ipset",
+	ExportNFT:   "nft",
+}
+
+func (f ExportFormat) String() string {
+	if f >= 0 && int(f) < len(exportFormatNames) {
+		return exportFormatNames[f]
+	}
+
+	return fmt.Sprintf("ExportFormat(%d)", int(f))
+}
+
+// ParseExportFormat returns the ExportFormat with the
+// given name, one of text, ipset or nft.
+func ParseExportFormat(name string) (ExportFormat, error) {
+	for f, n := range exportFormatNames {
+		if strings.EqualFold(name, n) {
+			return ExportFormat(f), nil
+		}
+	}
+
+	return 0, ErrInvalidExportFormat
+}
+
+// appendCIDRs appends the smallest set of CIDR blocks
+// that exactly covers the range [first, last] to nets.
+//
+// first and last may be in shared memory, so they are
+// copied before use and nets is returned unchanged if
+// first is after last, as can happen with a torn read.
+func appendCIDRs(nets []*net.IPNet, first, last []byte) []*net.IPNet {
+	bits := len(first) * 8
+	ip := append([]byte(nil), first...)
+	last = append([]byte(nil), last...)
+
+	if len(ip) != len(last) || bytes.Compare(ip, last) > 0 {
+		return nets
+	}
+
+	for {
+		ones := bits
+		for ones > 0 {
+			mask := net.CIDRMask(ones-1, bits)
+			if !bytes.Equal(net.IP(ip).Mask(mask), ip) || bytes.Compare(lastAddr(ip, mask), last) > 0 {
+				break
+			}
+
+			ones--
+		}
+
+		mask := net.CIDRMask(ones, bits)
+		nets = append(nets, &net.IPNet{
+			IP:   append(net.IP(nil), ip...),
+			Mask: mask,
+		})
+
+		end := lastAddr(ip, mask)
+		if bytes.Equal(end, last) {
+			return nets
+		}
+
+		ip = end
+		incrBytes(ip)
+	}
+}
+
+// cidrs returns the smallest set of CIDR blocks that
+// covers every range in t that has not expired by now.
+func (t *rangeTable) cidrs(now int64) []*net.IPNet {
+	var nets []*net.IPNet
+
+	for i := 0; i < t.Len(); i++ {
+		if !isExpired(t.value(i), now) {
+			nets = appendCIDRs(nets, t.start(i), t.end(i))
+		}
+	}
+
+	return nets
+}
+
+func writeExport(w io.Writer, format ExportFormat, ip4s, ip6s []*net.IPNet) error {
+	bw := bufio.NewWriter(w)
+
+	switch format {
+	case ExportText:
+		for _, nets := range [...][]*net.IPNet{ip4s, ip6s} {
+			for _, ipnet := range nets {
+				fmt.Fprintln(bw, ipnet)
+			}
+		}
+	case ExportIPSet:
+		fmt.Fprintln(bw, "create ip-blocker4 hash:net family inet -exist")
+		fmt.Fprintln(bw, "create ip-blocker6 hash:net family inet6 -exist")
+
+		for _, set := range [...]struct {
+			name string
+			nets []*net.IPNet
+		}{
+			{"ip-blocker4", ip4s},
+			{"ip-blocker6", ip6s},
+		} {
+			for _, ipnet := range set.nets {
+				/* hash:net sets cannot hold a /0, so it is split in two */
+				if ones, bits := ipnet.Mask.Size(); ones == 0 {
+					lo := &net.IPNet{IP: ipnet.IP, Mask: net.CIDRMask(1, bits)}
+					hi := &net.IPNet{IP: append(net.IP(nil), ipnet.IP...), Mask: lo.Mask}
+					hi.IP[0] |= 0x80
+
+					fmt.Fprintf(bw, "add %s %s -exist\n", set.name, lo)
+					fmt.Fprintf(bw, "add %s %s -exist\n", set.name, hi)
+				} else {
+					fmt.Fprintf(bw, "add %s %s -exist\n", set.name, ipnet)
+				}
+			}
+		}
+	case ExportNFT:
+		fmt.Fprintln(bw, "add table inet ip-blocker")
+		fmt.Fprintln(bw, "add set inet ip-blocker blocklist4 { type ipv4_addr; flags interval; }")
+		fmt.Fprintln(bw, "add set inet ip-blocker blocklist6 { type ipv6_addr; flags interval; }")
+
+		for _, set := range [...]struct {
+			name string
+			nets []*net.IPNet
+		}{
+			{"blocklist4", ip4s},
+			{"blocklist6", ip6s},
+		} {
+			for _, ipnet := range set.nets {
+				fmt.Fprintf(bw, "add element inet ip-blocker %s { %s }\n", set.name, ipnet)
+			}
+		}
+	default:
+		return ErrInvalidExportFormat
+	}
+
+	return bw.Flush()
+}
+
+// Export writes the blocklist to w in the given text
+// format, with each range written as the smallest set of
+// CIDR blocks that covers it.
+//
+// IP addresses that were inserted with a TTL that has
+// elapsed are not written. The allowlist and named lists
+// are not written either.
+//
+// Unlike Save(), the output of Export() cannot be loaded
+// back into a Server.
+//
+// Will fail if Closed() has already been called.
+func (s *Server) Export(w io.Writer, format ExportFormat) error {
+	s.mu.Lock()
+
+	if s.closed {
+		s.mu.Unlock()
+		return ErrClosed
+	}
+
+	now := time.Now().UnixNano()
+	ip4s, ip6s := s.ip4s.cidrs(now), s.ip6s.cidrs(now)
+
+	s.mu.Unlock()
+
+	return writeExport(w, format, ip4s, ip6s)
+}
+
+// Export writes the blocklist in shared memory to w in
+// the given text format.
+//
+// It follows the same rules as (*Server).Export().
+//
+// Will fail if Closed() has been called.
+func (c *Client) Export(w io.Writer, format ExportFormat) error {
+	c.mu.RLock()
+
+	var ip4s, ip6s []*net.IPNet
+
+	now := time.Now().UnixNano()
+
+	err := c.view(func(t *tables) {
+		ip4s, ip6s = t.ip4s.cidrs(now), t.ip6s.cidrs(now)
+	})
+
+	c.mu.RUnlock()
+
+	if err != nil {
+		return err
+	}
+
+	return writeExport(w, format, ip4s, ip6s)
+}
@@ -0,0 +1,193 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+package blocker
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAppendCIDRs(t *testing.T) {
+	for _, test := range [...]struct {
+		first, last string
+		expect      []string
+	}{
+		{"192.0.2.0", "192.0.2.0", []string{"192.0.2.0/32"}},
+		{"192.0.2.0", "192.0.2.255", []string{"192.0.2.0/24"}},
+		{"192.0.2.1", "192.0.2.6", []string{"192.0.2.1/32", "192.0.2.2/31", "192.0.2.4/31", "192.0.2.6/32"}},
+		{"192.0.2.0", "192.0.3.127", []string{"192.0.2.0/24", "192.0.3.0/25"}},
+		{"0.0.0.0", "255.255.255.255", []string{"0.0.0.0/0"}},
+		{"255.255.255.254", "255.255.255.255", []string{"255.255.255.254/31"}},
+		{"2001:db8::", "2001:db8::ffff:ffff:ffff:ffff", []string{"2001:db8::/64"}},
+		{"2001:db8::1", "2001:db8::2", []string{"2001:db8::1/128", "2001:db8::2/128"}},
+		{"192.0.2.6", "192.0.2.1", nil},
+		{"2001:db8::2", "2001:db8::1", nil},
+	} {
+		first, last := net.ParseIP(test.first), net.ParseIP(test.last)
+		if first4 := first.To4(); first4 != nil {
+			first, last = first4, last.To4()
+		}
+
+		var nets []string
+		for _, ipnet := range appendCIDRs(nil, first, last) {
+			nets = append(nets, ipnet.String())
+		}
+
+		if !reflect.DeepEqual(nets, test.expect) {
+			t.Errorf("appendCIDRs(%s, %s) returned %v, expected %v", test.first, test.last, nets, test.expect)
+		}
+	}
+}
+
+func TestCIDRsInverted(t *testing.T) {
+	/* an entry that was torn by a concurrent write */
+	table := rangeTable{Size: net.IPv4len, ValueSize: valueSize}
+	table.Data = append(table.Data, 192, 0, 2, 6, 192, 0, 2, 1)
+	table.Data = append(table.Data, make([]byte, valueSize)...)
+	table.Data = append(table.Data, 192, 0, 2, 8, 192, 0, 2, 8)
+	table.Data = append(table.Data, make([]byte, valueSize)...)
+
+	var nets []string
+	for _, ipnet := range table.cidrs(time.Now().UnixNano()) {
+		nets = append(nets, ipnet.String())
+	}
+
+	if expect := []string{"192.0.2.8/32"}; !reflect.DeepEqual(nets, expect) {
+		t.Errorf("cidrs returned %v, expected %v", nets, expect)
+	}
+}
+
+func TestExport(t *testing.T) {
+	server, client, err := setup(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	if err = server.Batch(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, addr := range [...]string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "2001:db8::"} {
+		if err = server.Insert(net.ParseIP(addr)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	ip, ipnet, err := net.ParseCIDR("0.0.0.0/0")
+	if err != nil {
+		panic(err)
+	}
+
+	if err = server.doInsertRemoveRange(&server.ip4s, &server.ip6s, ip, ipnet, true, time.Now().Add(-time.Hour).UnixNano(), 0); err != nil {
+		t.Error(err)
+	}
+
+	if err = server.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	for format, expect := range map[ExportFormat]string{
+		ExportText: `192.0.2.1/32
+192.0.2.2/31
+2001:db8::/128
+`,
+		ExportIPSet: `create ip-blocker4 hash:net family inet -exist
+create ip-blocker6 hash:net family inet6 -exist
+add ip-blocker4 192.0.2.1/32 -exist
+add ip-blocker4 192.0.2.2/31 -exist
+add ip-blocker6 2001:db8::/128 -exist
+`,
+		ExportNFT: `add table inet ip-blocker
+add set inet ip-blocker blocklist4 { type ipv4_addr; flags interval; }
+add set inet ip-blocker blocklist6 { type ipv6_addr; flags interval; }
+add element inet ip-blocker blocklist4 { 192.0.2.1/32 }
+add element inet ip-blocker blocklist4 { 192.0.2.2/31 }
+add element inet ip-blocker blocklist6 { 2001:db8::/128 }
+`,
+	} {
+		var b bytes.Buffer
+
+		if err = server.Export(&b, format); err != nil {
+			t.Error(err)
+		}
+
+		if b.String() != expect {
+			t.Errorf("(*Server).Export returned invalid %s export, expected %q, got %q", format, expect, b.String())
+		}
+
+		b.Reset()
+
+		if err = client.Export(&b, format); err != nil {
+			t.Error(err)
+		}
+
+		if b.String() != expect {
+			t.Errorf("(*Client).Export returned invalid %s export, expected %q, got %q", format, expect, b.String())
+		}
+	}
+
+	if err = server.Export(new(bytes.Buffer), ExportFormat(-1)); err != ErrInvalidExportFormat {
+		t.Errorf("Export did not return ErrInvalidExportFormat, got %v", err)
+	}
+}
+
+func TestExportIPSetZeroPrefix(t *testing.T) {
+	server, _, err := setup(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+
+	ip, ipnet, err := net.ParseCIDR("0.0.0.0/0")
+	if err != nil {
+		panic(err)
+	}
+
+	if err = server.InsertRange(ip, ipnet); err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+
+	if err = server.Export(&b, ExportIPSet); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := `create ip-blocker4 hash:net family inet -exist
+create ip-blocker6 hash:net family inet6 -exist
+add ip-blocker4 0.0.0.0/1 -exist
+add ip-blocker4 128.0.0.0/1 -exist
+`
+	if b.String() != expect {
+		t.Errorf("Export returned invalid ipset export, expected %q, got %q", expect, b.String())
+	}
+}
+
+func TestParseExportFormat(t *testing.T) {
+	for _, f := range [...]ExportFormat{ExportText, ExportIPSet, ExportNFT} {
+		parsed, err := ParseExportFormat(f.String())
+		if err != nil {
+			t.Error(err)
+		}
+
+		if parsed != f {
+			t.Errorf("ParseExportFormat(%q) returned %v", f.String(), parsed)
+		}
+	}
+
+	if _, err := ParseExportFormat("csv"); err != ErrInvalidExportFormat {
+		t.Errorf("ParseExportFormat did not return ErrInvalidExportFormat, got %v", err)
+	}
+}
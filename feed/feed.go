@@ -0,0 +1,564 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+// Package feed keeps an ip-blocker-agent server up to
+// date with blocklists that are periodically fetched
+// from URLs or local files.
+package feed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tmthrgd/ip-blocker-agent"
+	"github.com/tmthrgd/ip-blocker-agent/importer"
+)
+
+// DefaultInterval is the interval between fetches of a
+// Source that does not specify one.
+const DefaultInterval = time.Hour
+
+// DefaultMaxSize is the maximum size of a Source if
+// Subscriber.MaxSize is zero.
+const DefaultMaxSize = 64 << 20
+
+var (
+	// ErrInvalidSource will be returned by New() if a
+	// Source has no name or URL, has the same name as
+	// another Source, has a URL with a scheme other
+	// than http, https or file or has an invalid List.
+	ErrInvalidSource = errors.New("invalid feed source")
+
+	// ErrUnknownSource will be returned by
+	// (*Subscriber).UpdateSource() if there is no
+	// Source with the given name.
+	ErrUnknownSource = errors.New("unknown feed source")
+
+	// ErrSourceTooLarge is returned for a Source that
+	// is larger than Subscriber.MaxSize.
+	ErrSourceTooLarge = errors.New("feed source too large")
+)
+
+// Source is a blocklist that is periodically fetched.
+type Source struct {
+	// The name of the source, used to identify it in
+	// errors.
+	Name string
+
+	// The URL to fetch the blocklist from. It may be a
+	// http or https URL, a file URL or a path to a
+	// local file.
+	URL string
+
+	// The format of the blocklist.
+	Format importer.Format
+
+	// The interval between fetches. If zero,
+	// DefaultInterval is used.
+	Interval time.Duration
+
+	// The named list to insert the blocklist into. If
+	// empty, the default blocklist is used.
+	List string
+
+	// The tag to insert the blocklist with. If zero, the
+	// entries are untagged.
+	Tag blocker.Tag
+}
+
+// SourceError records an error fetching or applying a
+// Source.
+type SourceError struct {
+	Source string
+	Err    error
+}
+
+func (e *SourceError) Error() string {
+	return fmt.Sprintf("feed %s: %v", e.Source, e.Err)
+}
+
+// ErrorList is a list of errors from one or more
+// sources.
+type ErrorList []*SourceError
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	default:
+		return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+	}
+}
+
+// target is implemented by both *blocker.Server and
+// *blocker.List.
+type target interface {
+	InsertRangeTagged(ip net.IP, ipnet *net.IPNet, tag blocker.Tag) error
+	RemoveRange(ip net.IP, ipnet *net.IPNet) error
+}
+
+// validators identify the version of a Source that was
+// last applied, so that an unchanged Source is not fetched
+// again in full.
+type validators struct {
+	etag         string
+	lastModified string
+
+	modTime time.Time
+	size    int64
+}
+
+type source struct {
+	Source
+
+	path string // set for local files
+
+	// validators and entries are only accessed with
+	// Subscriber.mu held.
+	validators
+
+	// entries is keyed by the string form of the CIDR
+	// block.
+	entries map[string]*net.IPNet
+}
+
+// Subscriber keeps the blocklist of a Server up to date
+// with a set of sources.
+//
+// Each Source is tracked separately. When a Source
+// changes, only the entries that were added or removed
+// since it was last fetched are applied, in a single
+// Batch() and Commit(). If a Source cannot be fetched,
+// the entries it previously contributed are left in
+// place and other sources are unaffected. If the Server
+// is already batching, blocker.ErrAlreadyBatching is
+// returned and the changes are applied on a later
+// update instead.
+//
+// An entry removed from one Source is restored if it
+// overlaps an entry of another Source that targets the
+// same list. Entries inserted by other means may still
+// be removed if they overlap an entry that a Source
+// removes.
+type Subscriber struct {
+	// The HTTP client to fetch sources with. If nil,
+	// http.DefaultClient is used.
+	Client *http.Client
+
+	// ErrorLog specifies an optional logger for errors
+	// encountered by Run(). If nil, logging goes to
+	// os.Stderr via the log package's standard logger.
+	ErrorLog *log.Logger
+
+	// If non-nil, ApplyHook is called to apply the
+	// changes of each Source, which it does by calling
+	// apply. It may be used to serialise the changes
+	// with other users of the Server. If it returns an
+	// error without calling apply, the changes are
+	// applied on a later update instead.
+	ApplyHook func(apply func() error) error
+
+	// MaxSize is the maximum size in bytes of a
+	// Source. A larger Source is not applied. If
+	// zero, DefaultMaxSize is used.
+	MaxSize int64
+
+	server *blocker.Server
+
+	mu      sync.Mutex
+	sources []*source
+}
+
+// New returns a Subscriber that keeps s up to date with
+// the given sources.
+//
+// Nothing is fetched until Run() or Update() is called.
+func New(s *blocker.Server, sources ...Source) (*Subscriber, error) {
+	sub := &Subscriber{server: s}
+	names := make(map[string]bool, len(sources))
+
+	for _, src := range sources {
+		if len(src.Name) == 0 || len(src.URL) == 0 || names[src.Name] {
+			return nil, ErrInvalidSource
+		}
+
+		if src.List != "" && !blocker.ValidListName(src.List) {
+			return nil, ErrInvalidSource
+		}
+
+		names[src.Name] = true
+
+		u, err := url.Parse(src.URL)
+		if err != nil {
+			return nil, err
+		}
+
+		ss := &source{Source: src}
+
+		switch u.Scheme {
+		case "http", "https":
+		case "file":
+			ss.path = u.Path
+		case "":
+			ss.path = src.URL
+		default:
+			return nil, ErrInvalidSource
+		}
+
+		if ss.Interval <= 0 {
+			ss.Interval = DefaultInterval
+		}
+
+		sub.sources = append(sub.sources, ss)
+	}
+
+	return sub, nil
+}
+
+func (sub *Subscriber) logf(format string, args ...interface{}) {
+	if sub.ErrorLog != nil {
+		sub.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// open returns the contents of src, or nil if it has not
+// changed since it was last applied, along with the
+// validators of the contents.
+//
+// The validators must only be saved once the contents
+// have been applied.
+func (sub *Subscriber) open(ctx context.Context, src *source) (io.ReadCloser, validators, error) {
+	sub.mu.Lock()
+	applied, last := src.entries != nil, src.validators
+	sub.mu.Unlock()
+
+	if src.path != "" {
+		f, err := os.Open(src.path)
+		if err != nil {
+			return nil, validators{}, err
+		}
+
+		stat, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, validators{}, err
+		}
+
+		if applied && stat.ModTime().Equal(last.modTime) && stat.Size() == last.size {
+			f.Close()
+			return nil, validators{}, nil
+		}
+
+		return f, validators{modTime: stat.ModTime(), size: stat.Size()}, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, src.URL, nil)
+	if err != nil {
+		return nil, validators{}, err
+	}
+
+	if applied {
+		if last.etag != "" {
+			req.Header.Set("If-None-Match", last.etag)
+		}
+
+		if last.lastModified != "" {
+			req.Header.Set("If-Modified-Since", last.lastModified)
+		}
+	}
+
+	client := sub.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, validators{}, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, validators{
+			etag:         resp.Header.Get("ETag"),
+			lastModified: resp.Header.Get("Last-Modified"),
+		}, nil
+	case http.StatusNotModified:
+		resp.Body.Close()
+		return nil, validators{}, nil
+	default:
+		resp.Body.Close()
+		return nil, validators{}, fmt.Errorf("unexpected HTTP status: %s", resp.Status)
+	}
+}
+
+// update fetches src and applies any changes. If some
+// lines could not be parsed, the remaining entries are
+// still applied and an importer.ErrorList is returned.
+func (sub *Subscriber) update(ctx context.Context, src *source) error {
+	body, v, err := sub.open(ctx, src)
+	if err != nil || body == nil {
+		return err
+	}
+
+	max := sub.MaxSize
+	if max <= 0 {
+		max = DefaultMaxSize
+	}
+
+	entries := make(map[string]*net.IPNet)
+
+	err = importer.Parse(&limitedReader{body, max}, src.Format, func(ip net.IP, ipnet *net.IPNet) error {
+		entries[ipnet.String()] = ipnet
+		return nil
+	})
+	body.Close()
+
+	parseErr, ok := err.(importer.ErrorList)
+	if err != nil && !ok {
+		return err
+	}
+
+	apply := func() error {
+		return sub.apply(src, entries)
+	}
+
+	if sub.ApplyHook != nil {
+		err = sub.ApplyHook(apply)
+	} else {
+		err = apply()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	/* only now can an unchanged src be skipped */
+	sub.mu.Lock()
+	src.validators = v
+	sub.mu.Unlock()
+
+	if parseErr != nil {
+		return parseErr
+	}
+
+	return nil
+}
+
+// limitedReader reads from r until n bytes remain and
+// then fails with ErrSourceTooLarge, unlike
+// io.LimitedReader which returns io.EOF.
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (n int, err error) {
+	if l.n < 0 {
+		return 0, ErrSourceTooLarge
+	}
+
+	/* read one byte more than allowed to tell a Source of exactly n bytes from a larger one */
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+
+	n, err = l.r.Read(p)
+	l.n -= int64(n)
+
+	if l.n < 0 {
+		return n, ErrSourceTooLarge
+	}
+
+	return n, err
+}
+
+func (sub *Subscriber) target(list string) (target, error) {
+	if list == "" {
+		return sub.server, nil
+	}
+
+	return sub.server.List(list)
+}
+
+func overlaps(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+// apply applies the difference between the entries of
+// src and entries to the server, and then replaces the
+// entries of src with entries. If the difference cannot
+// be applied, including because the server is already
+// batching, the entries of src are left as they were so
+// that it is applied again on the next update.
+func (sub *Subscriber) apply(src *source, entries map[string]*net.IPNet) error {
+	type entry struct {
+		ipnet *net.IPNet
+		tag   blocker.Tag
+	}
+
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	var removed []*net.IPNet
+	var added []entry
+
+	for key, ipnet := range src.entries {
+		if _, ok := entries[key]; !ok {
+			removed = append(removed, ipnet)
+		}
+	}
+
+	for key, ipnet := range entries {
+		if _, ok := src.entries[key]; !ok {
+			added = append(added, entry{ipnet, src.Tag})
+		}
+	}
+
+	if len(removed) == 0 && len(added) == 0 {
+		src.entries = entries
+		return nil
+	}
+
+	t, err := sub.target(src.List)
+	if err != nil {
+		return err
+	}
+
+	/* joining another caller's batch would leave it to publish or discard these changes */
+	if err = sub.server.Batch(); err != nil {
+		return err
+	}
+
+	for _, ipnet := range removed {
+		if err = t.RemoveRange(ipnet.IP, ipnet); err != nil {
+			break
+		}
+	}
+
+	if len(removed) != 0 {
+		/* restore any part of a removed entry that is still contributed */
+		for _, other := range sub.sources {
+			if other.List != src.List {
+				continue
+			}
+
+			otherEntries := other.entries
+			if other == src {
+				otherEntries = entries
+			}
+
+			for _, ipnet := range otherEntries {
+				for _, r := range removed {
+					if overlaps(ipnet, r) {
+						added = append(added, entry{ipnet, other.Tag})
+						break
+					}
+				}
+			}
+		}
+	}
+
+	for _, e := range added {
+		if err != nil {
+			break
+		}
+
+		err = t.InsertRangeTagged(e.ipnet.IP, e.ipnet, e.tag)
+	}
+
+	if cerr := sub.server.Commit(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		src.entries = entries
+	}
+
+	return err
+}
+
+// Update fetches every source once and applies any
+// changes.
+//
+// An error from one source does not stop the others
+// from being updated. All errors are returned together
+// as an ErrorList.
+func (sub *Subscriber) Update(ctx context.Context) error {
+	var errs ErrorList
+
+	for _, src := range sub.sources {
+		if err := sub.update(ctx, src); err != nil {
+			errs = append(errs, &SourceError{src.Name, err})
+		}
+	}
+
+	if errs != nil {
+		return errs
+	}
+
+	return nil
+}
+
+// UpdateSource fetches the source with the given name
+// and applies any changes.
+func (sub *Subscriber) UpdateSource(ctx context.Context, name string) error {
+	for _, src := range sub.sources {
+		if src.Name == name {
+			if err := sub.update(ctx, src); err != nil {
+				return &SourceError{src.Name, err}
+			}
+
+			return nil
+		}
+	}
+
+	return ErrUnknownSource
+}
+
+// Run fetches each source immediately and then at the
+// interval of that source until ctx is done. Errors are
+// logged to ErrorLog.
+//
+// Run must not be called concurrently with Update() or
+// UpdateSource().
+func (sub *Subscriber) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	for _, src := range sub.sources {
+		wg.Add(1)
+
+		go func(src *source) {
+			defer wg.Done()
+
+			for {
+				if err := sub.update(ctx, src); err != nil && ctx.Err() == nil {
+					sub.logf("%v", &SourceError{src.Name, err})
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(src.Interval):
+				}
+			}
+		}(src)
+	}
+
+	wg.Wait()
+}
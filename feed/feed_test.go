@@ -0,0 +1,628 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+package feed
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tmthrgd/ip-blocker-agent"
+	"github.com/tmthrgd/ip-blocker-agent/importer"
+)
+
+var nameRand *rand.Rand
+
+func init() {
+	var seed [8]byte
+
+	if _, err := crand.Read(seed[:]); err != nil {
+		panic(err)
+	}
+
+	seedInt := int64(binary.LittleEndian.Uint64(seed[:]))
+	nameRand = rand.New(rand.NewSource(seedInt))
+}
+
+func setup() (*blocker.Server, *blocker.Client, error) {
+	name := fmt.Sprintf("/go-test-%d", nameRand.Int())
+
+	server, err := blocker.New(name, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client, err := blocker.Open(name)
+	if err != nil {
+		server.Close()
+		server.Unlink()
+
+		return nil, nil, err
+	}
+
+	return server, client, nil
+}
+
+func testContains(t *testing.T, client *blocker.Client, expect map[string]bool) {
+	for addr, res := range expect {
+		has, err := client.Contains(net.ParseIP(addr))
+		if err != nil {
+			t.Error(err)
+		}
+
+		if has != res {
+			t.Errorf("Contains(%s) returned %t, expected %t", addr, has, res)
+		}
+	}
+}
+
+// feedHandler serves a blocklist with an ETag and
+// records the conditional requests it receives.
+type feedHandler struct {
+	mu sync.Mutex
+
+	body string
+	etag string
+	fail bool
+
+	requests    int
+	notModified int
+}
+
+func (h *feedHandler) set(body string) {
+	h.mu.Lock()
+	h.body = body
+	h.etag = fmt.Sprintf(`"%d"`, len(body)+h.requests)
+	h.mu.Unlock()
+}
+
+func (h *feedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.requests++
+
+	if h.fail {
+		http.Error(w, "failed", http.StatusInternalServerError)
+		return
+	}
+
+	if r.Header.Get("If-None-Match") == h.etag {
+		h.notModified++
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("ETag", h.etag)
+	w.Write([]byte(h.body))
+}
+
+func TestUpdate(t *testing.T) {
+	server, client, err := setup()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	h := new(feedHandler)
+	h.set("# netset\n192.0.2.0/24\n198.51.100.7\n")
+
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	sub, err := New(server, Source{
+		Name:   "test",
+		URL:    ts.URL,
+		Format: importer.Netset,
+		Tag:    1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = sub.Update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	testContains(t, client, map[string]bool{
+		"192.0.2.7":    true,
+		"198.51.100.7": true,
+		"203.0.113.0":  false,
+	})
+
+	if tag, _, err := client.LookupTag(net.ParseIP("192.0.2.7")); err != nil || tag != 1 {
+		t.Errorf("LookupTag returned (%d, %v), expected tag 1", tag, err)
+	}
+
+	if err = sub.Update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	h.mu.Lock()
+	if h.notModified != 1 {
+		t.Errorf("Update did not make a conditional request, got %d of %d not modified", h.notModified, h.requests)
+	}
+	h.mu.Unlock()
+
+	if err = server.Insert(net.ParseIP("2001:db8::")); err != nil {
+		t.Fatal(err)
+	}
+
+	h.set("192.0.2.0/25\n203.0.113.0\n")
+
+	if err = sub.Update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	testContains(t, client, map[string]bool{
+		"192.0.2.7":    true,
+		"192.0.2.128":  false,
+		"198.51.100.7": false,
+		"203.0.113.0":  true,
+		"2001:db8::":   true,
+	})
+
+	h.set("192.0.2.0/25\ninvalid\n")
+
+	err = sub.Update(context.Background())
+	if errs, ok := err.(ErrorList); !ok || len(errs) != 1 {
+		t.Errorf("Update did not return error for invalid line, got %v", err)
+	} else if _, ok := errs[0].Err.(importer.ErrorList); !ok {
+		t.Errorf("Update returned unexpected error %v", errs[0].Err)
+	}
+
+	testContains(t, client, map[string]bool{
+		"192.0.2.7":   true,
+		"203.0.113.0": false,
+	})
+}
+
+func TestUpdateFailure(t *testing.T) {
+	server, client, err := setup()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	h1, h2 := new(feedHandler), new(feedHandler)
+	h1.set("192.0.2.0\n")
+	h2.set("198.51.100.0\n")
+
+	ts1, ts2 := httptest.NewServer(h1), httptest.NewServer(h2)
+	defer ts1.Close()
+	defer ts2.Close()
+
+	sub, err := New(server,
+		Source{Name: "one", URL: ts1.URL},
+		Source{Name: "two", URL: ts2.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = sub.Update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	h1.mu.Lock()
+	h1.fail = true
+	h1.mu.Unlock()
+
+	h2.set("198.51.100.1\n")
+
+	err = sub.Update(context.Background())
+	if errs, ok := err.(ErrorList); !ok || len(errs) != 1 || errs[0].Source != "one" {
+		t.Errorf("Update did not return error for failed source, got %v", err)
+	}
+
+	testContains(t, client, map[string]bool{
+		"192.0.2.0":    true,
+		"198.51.100.0": false,
+		"198.51.100.1": true,
+	})
+
+	if err = sub.UpdateSource(context.Background(), "three"); err != ErrUnknownSource {
+		t.Errorf("UpdateSource did not return ErrUnknownSource, got %v", err)
+	}
+}
+
+func TestUpdateApplyFailure(t *testing.T) {
+	server, client, err := setup()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	h := new(feedHandler)
+	h.set("192.0.2.0\n")
+
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	sub, err := New(server, Source{Name: "test", URL: ts.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	/* New rejects invalid list names, so force a failure to apply */
+	sub.sources[0].List = "a\x00b"
+
+	if err = sub.Update(context.Background()); err == nil {
+		t.Fatal("Update did not return error for invalid list")
+	}
+
+	sub.sources[0].List = ""
+
+	if err = sub.Update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	h.mu.Lock()
+	if h.notModified != 0 {
+		t.Errorf("Update made a conditional request after failing to apply, got %d of %d not modified", h.notModified, h.requests)
+	}
+	h.mu.Unlock()
+
+	testContains(t, client, map[string]bool{
+		"192.0.2.0": true,
+	})
+}
+
+func TestUpdateBatching(t *testing.T) {
+	server, client, err := setup()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	h := new(feedHandler)
+	h.set("192.0.2.0\n")
+
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	sub, err := New(server, Source{Name: "test", URL: ts.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = server.Batch(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = sub.Update(context.Background()); err == nil {
+		t.Fatal("Update did not return error while batching")
+	} else if errs, ok := err.(ErrorList); !ok || errs[0].Err != blocker.ErrAlreadyBatching {
+		t.Errorf("Update returned unexpected error %v", err)
+	}
+
+	if err = server.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	testContains(t, client, map[string]bool{
+		"192.0.2.0": false,
+	})
+
+	if err = sub.Update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	testContains(t, client, map[string]bool{
+		"192.0.2.0": true,
+	})
+}
+
+func TestUpdateMaxSize(t *testing.T) {
+	server, client, err := setup()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	const body = "192.0.2.0\n198.51.100.0\n"
+
+	h := new(feedHandler)
+	h.set(body)
+
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	sub, err := New(server, Source{Name: "test", URL: ts.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub.MaxSize = int64(len(body)) - 1
+
+	if err = sub.Update(context.Background()); err == nil {
+		t.Fatal("Update did not return error for large source")
+	} else if errs, ok := err.(ErrorList); !ok || errs[0].Err != ErrSourceTooLarge {
+		t.Errorf("Update returned unexpected error %v", err)
+	}
+
+	testContains(t, client, map[string]bool{
+		"192.0.2.0":    false,
+		"198.51.100.0": false,
+	})
+
+	sub.MaxSize = int64(len(body))
+
+	if err = sub.Update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	testContains(t, client, map[string]bool{
+		"192.0.2.0":    true,
+		"198.51.100.0": true,
+	})
+}
+
+func TestApplyHook(t *testing.T) {
+	server, client, err := setup()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	h := new(feedHandler)
+	h.set("192.0.2.0\n")
+
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	sub, err := New(server, Source{Name: "test", URL: ts.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errDeferred := errors.New("deferred")
+	sub.ApplyHook = func(apply func() error) error {
+		return errDeferred
+	}
+
+	if err = sub.Update(context.Background()); err == nil {
+		t.Fatal("Update did not return error from ApplyHook")
+	} else if errs, ok := err.(ErrorList); !ok || errs[0].Err != errDeferred {
+		t.Errorf("Update returned unexpected error %v", err)
+	}
+
+	testContains(t, client, map[string]bool{
+		"192.0.2.0": false,
+	})
+
+	var calls int
+	sub.ApplyHook = func(apply func() error) error {
+		calls++
+		return apply()
+	}
+
+	if err = sub.Update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls != 1 {
+		t.Errorf("ApplyHook was called %d times, expected 1", calls)
+	}
+
+	testContains(t, client, map[string]bool{
+		"192.0.2.0": true,
+	})
+}
+
+func TestUpdateOverlapping(t *testing.T) {
+	server, client, err := setup()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	h1, h2, h3 := new(feedHandler), new(feedHandler), new(feedHandler)
+	h1.set("192.0.2.0/24\n")
+	h2.set("192.0.2.7\n")
+	h3.set("192.0.2.8\n")
+
+	ts1, ts2, ts3 := httptest.NewServer(h1), httptest.NewServer(h2), httptest.NewServer(h3)
+	defer ts1.Close()
+	defer ts2.Close()
+	defer ts3.Close()
+
+	sub, err := New(server,
+		Source{Name: "one", URL: ts1.URL},
+		Source{Name: "two", URL: ts2.URL},
+		Source{Name: "three", URL: ts3.URL, List: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = sub.Update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	h2.set("")
+	h3.set("")
+
+	if err = sub.Update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	testContains(t, client, map[string]bool{
+		"192.0.2.7": true,
+		"192.0.2.8": true,
+	})
+
+	if has, err := client.ContainsIn(net.ParseIP("192.0.2.8"), "other"); err != nil || has {
+		t.Errorf("ContainsIn returned (%t, %v) for removed entry", has, err)
+	}
+
+	h1.set("")
+
+	if err = sub.UpdateSource(context.Background(), "one"); err != nil {
+		t.Fatal(err)
+	}
+
+	testContains(t, client, map[string]bool{
+		"192.0.2.7": false,
+	})
+}
+
+func TestUpdateFile(t *testing.T) {
+	server, client, err := setup()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	f, err := ioutil.TempFile("", "go-test-feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.Close()
+	defer os.Remove(f.Name())
+
+	if err = ioutil.WriteFile(f.Name(), []byte("; drop\n192.0.2.0/24 ; SBL000001\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	sub, err := New(server, Source{Name: "drop", URL: "file://" + f.Name(), Format: importer.DROP})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = sub.Update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	testContains(t, client, map[string]bool{
+		"192.0.2.7": true,
+	})
+
+	if err = ioutil.WriteFile(f.Name(), []byte("; drop\n198.51.100.0/24 ; SBL000002\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = sub.Update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	testContains(t, client, map[string]bool{
+		"192.0.2.7":    false,
+		"198.51.100.7": true,
+	})
+
+	if err = os.Remove(f.Name()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = sub.Update(context.Background()); err == nil {
+		t.Error("Update did not fail for missing file")
+	}
+
+	testContains(t, client, map[string]bool{
+		"198.51.100.7": true,
+	})
+}
+
+func TestRun(t *testing.T) {
+	server, client, err := setup()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	h := new(feedHandler)
+	h.set("192.0.2.0\n")
+
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	sub, err := New(server, Source{Name: "test", URL: ts.URL, Interval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		sub.Run(ctx)
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	h.set("198.51.100.0\n")
+
+	time.Sleep(50 * time.Millisecond)
+
+	cancel()
+	<-done
+
+	testContains(t, client, map[string]bool{
+		"192.0.2.0":    false,
+		"198.51.100.0": true,
+	})
+
+	h.mu.Lock()
+	if h.requests < 3 {
+		t.Errorf("Run made %d requests, expected at least 3", h.requests)
+	}
+	h.mu.Unlock()
+}
+
+func TestNewInvalid(t *testing.T) {
+	for _, sources := range [...][]Source{
+		{{Name: "", URL: "http://example.com/"}},
+		{{Name: "test", URL: ""}},
+		{{Name: "test", URL: "ftp://example.com/"}},
+		{{Name: "test", URL: "/a"}, {Name: "test", URL: "/b"}},
+		{{Name: "test", URL: "/a", List: "a\x00b"}},
+		{{Name: "test", URL: "/a", List: strings.Repeat("a", 49)}},
+	} {
+		if _, err := New(nil, sources...); err != ErrInvalidSource {
+			t.Errorf("New did not return ErrInvalidSource for %v, got %v", sources, err)
+		}
+	}
+}
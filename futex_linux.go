@@ -0,0 +1,43 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+// +build linux
+
+package blocker
+
+import (
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+/* from linux/futex.h, the private variants cannot be used across processes */
+const (
+	futexWaitOp = 0
+	futexWakeOp = 1
+)
+
+// futexWait blocks until addr is woken by futexWake, the
+// timeout elapses or *addr is found not to equal val. It
+// returns unix.ENOSYS if futexes are not available.
+func futexWait(addr *uint32, val uint32, timeout time.Duration) error {
+	ts := unix.NsecToTimespec(timeout.Nanoseconds())
+
+	_, _, errno := unix.Syscall6(unix.SYS_FUTEX, uintptr(unsafe.Pointer(addr)), futexWaitOp,
+		uintptr(val), uintptr(unsafe.Pointer(&ts)), 0, 0)
+	switch errno {
+	case 0, unix.EAGAIN, unix.EINTR, unix.ETIMEDOUT:
+		return nil
+	default:
+		return errno
+	}
+}
+
+// futexWake wakes every process waiting on addr.
+func futexWake(addr *uint32) {
+	unix.Syscall6(unix.SYS_FUTEX, uintptr(unsafe.Pointer(addr)), futexWakeOp,
+		uintptr(^uint32(0)>>1), 0, 0, 0)
+}
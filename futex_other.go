@@ -0,0 +1,24 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+// +build !linux
+
+package blocker
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// futexWait returns unix.ENOSYS as futexes are only
+// available on linux, Watch then polls the revision.
+func futexWait(addr *uint32, val uint32, timeout time.Duration) error {
+	return unix.ENOSYS
+}
+
+// futexWake does nothing as futexes are only available
+// on linux.
+func futexWake(addr *uint32) {}
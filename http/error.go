@@ -0,0 +1,74 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+package httpblocker
+
+import (
+	"log"
+	"net"
+	"net/http"
+)
+
+// ErrorPolicy decides what happens to a request when the
+// client address could not be checked against the
+// blocklist.
+type ErrorPolicy int
+
+// The supported error policies.
+const (
+	// FailClosed responds to the request with a 500
+	// Internal Server Error HTTP status code.
+	FailClosed ErrorPolicy = iota
+
+	// FailOpen treats the address as if it were not
+	// blocked.
+	FailOpen
+)
+
+func (p ErrorPolicy) String() string {
+	switch p {
+	case FailClosed:
+		return "fail-closed"
+	case FailOpen:
+		return "fail-open"
+	default:
+		return "unknown"
+	}
+}
+
+func (h *Handler) logf(r *http.Request, format string, args ...interface{}) {
+	if h.ErrorLog != nil {
+		h.ErrorLog.Printf(format, args...)
+		return
+	}
+
+	/* http.ServerContextKey is only set by http.Server */
+	if server, ok := r.Context().Value(http.ServerContextKey).(*http.Server); ok && server.ErrorLog != nil {
+		server.ErrorLog.Printf(format, args...)
+		return
+	}
+
+	log.Printf(format, args...)
+}
+
+// handleError logs err, which occurred checking ip, and
+// applies the error policy. It returns true if the
+// request has been responded to.
+func (h *Handler) handleError(w http.ResponseWriter, r *http.Request, ip net.IP, err error) bool {
+	h.logf(r, "httpblocker: error checking %s: %v", ip, err)
+
+	if h.ErrorHandler != nil {
+		h.ErrorHandler(w, r, err)
+		return true
+	}
+
+	switch h.OnError {
+	case FailOpen:
+		return false
+	default:
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return true
+	}
+}
@@ -8,11 +8,10 @@
 package httpblocker
 
 import (
+	"log"
 	"net"
 	"net/http"
-	"net/url"
 
-	"github.com/tmthrgd/httphandlers"
 	"github.com/tmthrgd/ip-blocker-agent"
 )
 
@@ -29,49 +28,102 @@ type Handler struct {
 	Handler http.Handler
 
 	// The http.Handler to invoke when the
-	// client is blocked.
+	// client is blocked. The request context
+	// carries a *BlockInfo, see FromContext.
 	Blocked http.Handler
 
 	// If true, only clients in the block
 	// list are accepted.
+	//
+	// Clients in the allowlist are always
+	// accepted.
 	Whitelist bool
+
+	// The named lists to check clients against,
+	// in order. The empty name refers to the
+	// default blocklist. If empty, only the
+	// default blocklist is checked.
+	Lists []string
+
+	// The networks of proxies that are trusted
+	// to set forwarding headers. If empty, the
+	// forwarding headers are ignored and only
+	// r.RemoteAddr is checked.
+	//
+	// An address received with the PROXY
+	// protocol by a proxyproto.Listener is
+	// seen as r.RemoteAddr.
+	TrustedProxies []*net.IPNet
+
+	// The headers to find the forwarding chain
+	// in, in order of preference. Only the first
+	// one present in a request is used. If nil,
+	// DefaultForwardedHeaders is used.
+	ForwardedHeaders []ForwardedHeader
+
+	// If true, every address in the forwarding
+	// chain is checked rather than only the
+	// client address. A request is blocked if
+	// any of them is blocked or, with Whitelist,
+	// if any of them is not in the list.
+	CheckChain bool
+
+	// What to do with a request when the client
+	// address could not be checked, for instance
+	// while the blocklist is being remapped. The
+	// error is logged either way. The default is
+	// FailClosed.
+	OnError ErrorPolicy
+
+	// If non-nil, ErrorHandler is called to
+	// respond to the request instead of applying
+	// OnError.
+	ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
+
+	// ErrorLog specifies an optional logger for
+	// errors checking the client address. If nil,
+	// the ErrorLog of the http.Server is used,
+	// and if that is also nil, logging goes to
+	// os.Stderr via the log package's standard
+	// logger.
+	ErrorLog *log.Logger
 }
 
 // Block wraps a given http.Handler and blocks all
 // clients that are contained in the blocklist with
-// a 403 Forbidden HTTP status code.
+// a 403 Forbidden HTTP status code and BlockPage.
 func Block(c *blocker.Client, h http.Handler) http.Handler {
 	return BlockWithCode(c, h, http.StatusForbidden)
 }
 
 // BlockWithCode wraps a given http.Handler and blocks
 // all clients that are contained in the blocklist with
-// the given status code.
+// the given status code and BlockPage.
 func BlockWithCode(c *blocker.Client, h http.Handler, code int) http.Handler {
 	return &Handler{
 		Client: c,
 
 		Handler: h,
-		Blocked: handlers.ErrorCode(code),
+		Blocked: BlockPage(code),
 	}
 }
 
 // Whitelist wraps a given http.Handler and blocks
 // all clients that are not contained in the list
-// with a 403 Forbidden HTTP status code.
+// with a 403 Forbidden HTTP status code and BlockPage.
 func Whitelist(c *blocker.Client, h http.Handler) http.Handler {
 	return WhitelistWithCode(c, h, http.StatusForbidden)
 }
 
 // WhitelistWithCode wraps a given http.Handler and
 // blocks all clients that are not contained in the
-// list with the given status code.
+// list with the given status code and BlockPage.
 func WhitelistWithCode(c *blocker.Client, h http.Handler, code int) http.Handler {
 	return &Handler{
 		Client: c,
 
 		Handler: h,
-		Blocked: handlers.ErrorCode(code),
+		Blocked: BlockPage(code),
 
 		Whitelist: true,
 	}
@@ -79,18 +131,29 @@ func WhitelistWithCode(c *blocker.Client, h http.Handler, code int) http.Handler
 
 // ServeHTTP implements http.Handler.
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	addr := (&url.URL{Host: r.RemoteAddr}).Hostname()
-	has, err := h.Client.Contains(net.ParseIP(addr))
-	if err != nil {
-		server := r.Context().Value(http.ServerContextKey).(*http.Server)
-		if server.ErrorLog != nil {
-			server.ErrorLog.Println(err)
+	client, chain := h.addrs(r)
+
+	addrs := chain
+	if !h.CheckChain {
+		addrs = []net.IP{client}
+	}
+
+	for _, ip := range addrs {
+		res, e, err := h.Client.CheckEntry(ip, h.Lists...)
+		if err != nil {
+			if h.handleError(w, r, ip, err) {
+				return
+			}
+
+			continue
 		}
 
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-	} else if has == h.Whitelist {
-		h.Handler.ServeHTTP(w, r)
-	} else {
-		h.Blocked.ServeHTTP(w, r)
+		/* the allowlist takes precedence in either mode */
+		if res != blocker.Allowed && (res == blocker.Blocked) != h.Whitelist {
+			h.block(w, r, ip, e)
+			return
+		}
 	}
+
+	h.Handler.ServeHTTP(w, r)
 }
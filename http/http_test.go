@@ -0,0 +1,627 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+package httpblocker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	crand "crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tmthrgd/ip-blocker-agent"
+	"github.com/tmthrgd/ip-blocker-agent/proxyproto"
+)
+
+var nameRand *rand.Rand
+
+func init() {
+	var seed [8]byte
+
+	if _, err := crand.Read(seed[:]); err != nil {
+		panic(err)
+	}
+
+	seedInt := int64(binary.LittleEndian.Uint64(seed[:]))
+	nameRand = rand.New(rand.NewSource(seedInt))
+}
+
+func setup(blocked ...string) (*blocker.Server, *blocker.Client, error) {
+	name := fmt.Sprintf("/go-test-%d", nameRand.Int())
+
+	server, err := blocker.New(name, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, addr := range blocked {
+		if err = server.Insert(net.ParseIP(addr)); err != nil {
+			server.Close()
+			server.Unlink()
+
+			return nil, nil, err
+		}
+	}
+
+	client, err := blocker.Open(name)
+	if err != nil {
+		server.Close()
+		server.Unlink()
+
+		return nil, nil, err
+	}
+
+	return server, client, nil
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+
+		nets[i] = ipnet
+	}
+
+	return nets
+}
+
+func TestParseHop(t *testing.T) {
+	for in, expect := range map[string]string{
+		"192.0.2.1":               "192.0.2.1",
+		" 192.0.2.1 ":             "192.0.2.1",
+		"192.0.2.1:8080":          "192.0.2.1",
+		`"192.0.2.1:8080"`:        "192.0.2.1",
+		"2001:db8::1":             "2001:db8::1",
+		"[2001:db8::1]":           "2001:db8::1",
+		`"[2001:db8::1]:8080"`:    "2001:db8::1",
+		"unknown":                 "<nil>",
+		"_hidden":                 "<nil>",
+		"[2001:db8::1":            "<nil>",
+		"":                        "<nil>",
+		"example.com:80":          "<nil>",
+		"::ffff:192.0.2.1":        "192.0.2.1",
+		"[::ffff:192.0.2.1]:8080": "192.0.2.1",
+	} {
+		if got := parseHop(in).String(); got != expect {
+			t.Errorf("parseHop(%q) returned %s, expected %s", in, got, expect)
+		}
+	}
+}
+
+func TestAddrs(t *testing.T) {
+	h := &Handler{
+		TrustedProxies: mustParseCIDRs("10.0.0.0/8", "2001:db8:ffff::/48"),
+	}
+
+	for _, test := range [...]struct {
+		remoteAddr string
+		header     map[string][]string
+		headers    []ForwardedHeader
+
+		client string
+		chain  []string
+	}{
+		{
+			"192.0.2.1:1234", map[string][]string{"X-Forwarded-For": {"198.51.100.1"}}, nil,
+			"192.0.2.1", []string{"192.0.2.1"},
+		},
+		{
+			"10.0.0.1:1234", nil, nil,
+			"10.0.0.1", []string{"10.0.0.1"},
+		},
+		{
+			"10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"198.51.100.1, 192.0.2.1, 10.0.0.2"}}, nil,
+			"192.0.2.1", []string{"10.0.0.1", "10.0.0.2", "192.0.2.1", "198.51.100.1"},
+		},
+		{
+			"10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"198.51.100.1", "192.0.2.1"}}, nil,
+			"192.0.2.1", []string{"10.0.0.1", "192.0.2.1", "198.51.100.1"},
+		},
+		{
+			"10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}}, nil,
+			"10.0.0.3", []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+		},
+		{
+			"10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"192.0.2.1, garbage, 10.0.0.2"}}, nil,
+			"10.0.0.2", []string{"10.0.0.1", "10.0.0.2", "192.0.2.1"},
+		},
+		{
+			"[2001:db8:ffff::1]:1234", map[string][]string{"Forwarded": {`for=192.0.2.60;proto=http;by=203.0.113.43, For="[2001:db8:cafe::17]:4711"`}}, nil,
+			"2001:db8:cafe::17", []string{"2001:db8:ffff::1", "2001:db8:cafe::17", "192.0.2.60"},
+		},
+		{
+			"10.0.0.1:1234", map[string][]string{"Forwarded": {"for=unknown"}}, nil,
+			"10.0.0.1", []string{"10.0.0.1"},
+		},
+		{
+			"10.0.0.1:1234", map[string][]string{"X-Real-Ip": {"192.0.2.1"}}, nil,
+			"192.0.2.1", []string{"10.0.0.1", "192.0.2.1"},
+		},
+		{
+			"10.0.0.1:1234", map[string][]string{
+				"Forwarded":       {"for=198.51.100.1"},
+				"X-Forwarded-For": {"192.0.2.1"},
+			}, nil,
+			"198.51.100.1", []string{"10.0.0.1", "198.51.100.1"},
+		},
+		{
+			"10.0.0.1:1234", map[string][]string{
+				"Forwarded":       {"for=198.51.100.1"},
+				"X-Forwarded-For": {"192.0.2.1"},
+			}, []ForwardedHeader{XForwardedFor},
+			"192.0.2.1", []string{"10.0.0.1", "192.0.2.1"},
+		},
+		{
+			"10.0.0.1:1234", map[string][]string{"Forwarded": {"for=198.51.100.1"}}, []ForwardedHeader{XRealIP},
+			"10.0.0.1", []string{"10.0.0.1"},
+		},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remoteAddr
+		r.Header = test.header
+
+		h.ForwardedHeaders = test.headers
+
+		client, chain := h.addrs(r)
+
+		var got []string
+		for _, ip := range chain {
+			got = append(got, ip.String())
+		}
+
+		if client.String() != test.client || !reflect.DeepEqual(got, test.chain) {
+			t.Errorf("addrs(%s, %v) returned (%s, %v), expected (%s, %v)", test.remoteAddr, test.header, client, got, test.client, test.chain)
+		}
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	server, client, err := setup("192.0.2.1", "10.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, test := range [...]struct {
+		remoteAddr, xff string
+
+		whitelist, checkChain bool
+
+		code int
+	}{
+		{"192.0.2.1:1234", "", false, false, http.StatusForbidden},
+		{"192.0.2.2:1234", "", false, false, http.StatusOK},
+		{"192.0.2.2:1234", "192.0.2.1", false, false, http.StatusOK},
+		{"10.0.0.1:1234", "192.0.2.1", false, false, http.StatusForbidden},
+		{"10.0.0.1:1234", "192.0.2.2", false, false, http.StatusOK},
+		{"10.0.0.1:1234", "192.0.2.1, 192.0.2.2", false, false, http.StatusOK},
+		{"10.0.0.1:1234", "192.0.2.1, 192.0.2.2", false, true, http.StatusForbidden},
+		{"10.0.0.1:1234", "192.0.2.2, 10.0.0.2", false, false, http.StatusOK},
+		{"10.0.0.1:1234", "192.0.2.2, 10.0.0.2", false, true, http.StatusForbidden},
+		{"10.0.0.1:1234", "192.0.2.1", true, false, http.StatusOK},
+		{"10.0.0.1:1234", "192.0.2.2", true, false, http.StatusForbidden},
+		{"10.0.0.1:1234", "192.0.2.1", true, true, http.StatusForbidden},
+	} {
+		h := BlockWithCode(client, ok, http.StatusForbidden).(*Handler)
+		h.Whitelist = test.whitelist
+		h.CheckChain = test.checkChain
+		h.TrustedProxies = mustParseCIDRs("10.0.0.0/8")
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remoteAddr
+
+		if test.xff != "" {
+			r.Header.Set("X-Forwarded-For", test.xff)
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != test.code {
+			t.Errorf("%s with X-Forwarded-For %q (whitelist: %t, check chain: %t) returned %d, expected %d",
+				test.remoteAddr, test.xff, test.whitelist, test.checkChain, w.Code, test.code)
+		}
+	}
+}
+
+func TestAllowlist(t *testing.T) {
+	server, client, err := setup()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	blocked := mustParseCIDRs("203.0.113.0/24")[0]
+	if err = server.InsertRange(blocked.IP, blocked); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = server.Allow(net.ParseIP("203.0.113.7")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = server.Allow(net.ParseIP("192.0.2.1")); err != nil {
+		t.Fatal(err)
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, test := range [...]struct {
+		remoteAddr string
+		whitelist  bool
+
+		code int
+	}{
+		{"203.0.113.7:1234", false, http.StatusOK},
+		{"203.0.113.8:1234", false, http.StatusForbidden},
+		{"203.0.113.7:1234", true, http.StatusOK},
+		{"203.0.113.8:1234", true, http.StatusOK},
+		{"192.0.2.1:1234", true, http.StatusOK},
+		{"192.0.2.2:1234", true, http.StatusForbidden},
+	} {
+		h := BlockWithCode(client, ok, http.StatusForbidden).(*Handler)
+		h.Whitelist = test.whitelist
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remoteAddr
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != test.code {
+			t.Errorf("%s (whitelist: %t) returned %d, expected %d", test.remoteAddr, test.whitelist, w.Code, test.code)
+		}
+	}
+}
+
+func TestProxyProtocol(t *testing.T) {
+	server, client, err := setup("192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	ts := httptest.NewUnstartedServer(Block(client, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	ts.Listener = &proxyproto.Listener{
+		Listener: ts.Listener,
+		Trusted:  mustParseCIDRs("127.0.0.0/8"),
+	}
+	ts.Start()
+	defer ts.Close()
+
+	for src, code := range map[string]int{
+		"192.0.2.1": http.StatusForbidden,
+		"192.0.2.2": http.StatusOK,
+	} {
+		conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fmt.Fprintf(conn, "PROXY TCP4 %s 127.0.0.1 12345 80\r\nGET / HTTP/1.0\r\n\r\n", src)
+
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp.Body.Close()
+		conn.Close()
+
+		if resp.StatusCode != code {
+			t.Errorf("request from %s returned %d, expected %d", src, resp.StatusCode, code)
+		}
+	}
+}
+
+func TestBlockInfo(t *testing.T) {
+	server, client, err := setup()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	if err = server.InsertTagged(net.ParseIP("192.0.2.1"), 7); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = server.SetTagName(7, "abuse"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = server.InsertWithTTL(net.ParseIP("192.0.2.2"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := server.List("tor")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = list.Insert(net.ParseIP("192.0.2.3")); err != nil {
+		t.Fatal(err)
+	}
+
+	var info *BlockInfo
+	h := Block(client, nil).(*Handler)
+	h.Lists = []string{"", "tor"}
+	h.Blocked = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info = FromContext(r.Context())
+	})
+
+	for _, test := range [...]struct {
+		remoteAddr string
+
+		list       string
+		tag        blocker.Tag
+		reason     string
+		retryAfter bool
+	}{
+		{"192.0.2.1:1234", "", 7, "abuse", false},
+		{"192.0.2.2:1234", "", 0, "", true},
+		{"192.0.2.3:1234", "tor", 0, "", false},
+	} {
+		info = nil
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remoteAddr
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if info == nil {
+			t.Errorf("%s: Blocked was not called with a BlockInfo", test.remoteAddr)
+			continue
+		}
+
+		if info.IP.String()+":1234" != test.remoteAddr || info.List != test.list || info.Tag != test.tag || info.Reason != test.reason || info.Expires.IsZero() == test.retryAfter {
+			t.Errorf("%s: BlockInfo was %+v", test.remoteAddr, info)
+		}
+
+		retryAfter := w.Header().Get("Retry-After")
+		switch {
+		case !test.retryAfter && retryAfter != "":
+			t.Errorf("%s: Retry-After was set to %s for an entry that never expires", test.remoteAddr, retryAfter)
+		case test.retryAfter && retryAfter != "3600":
+			t.Errorf("%s: Retry-After was %q, expected 3600", test.remoteAddr, retryAfter)
+		}
+	}
+
+	if FromContext(httptest.NewRequest("GET", "/", nil).Context()) != nil {
+		t.Error("FromContext returned a BlockInfo for a request that was not blocked")
+	}
+}
+
+func TestBlockPage(t *testing.T) {
+	info := &BlockInfo{
+		IP:      net.ParseIP("192.0.2.1"),
+		List:    "tor",
+		Reason:  "<abuse>",
+		Expires: time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+
+	for _, test := range [...]struct {
+		accept string
+		info   *BlockInfo
+
+		contentType, body string
+	}{
+		{"", info, "text/plain; charset=utf-8", "Forbidden\n"},
+		{"*/*", info, "text/plain; charset=utf-8", "Forbidden\n"},
+		{"application/json", nil, "application/json; charset=utf-8", `{"error":"Forbidden"}` + "\n"},
+		{"application/json", info, "application/json; charset=utf-8",
+			`{"error":"Forbidden","ip":"192.0.2.1","list":"tor","reason":"\u003cabuse\u003e","expires":"2017-01-02T03:04:05Z"}` + "\n"},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", info, "text/html; charset=utf-8", `<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>403 Forbidden</title>
+</head>
+<body>
+<h1>Forbidden</h1>
+<p>Your IP address, 192.0.2.1, has been blocked: &lt;abuse&gt;.</p>
+<p>The block expires at 2017-01-02 03:04:05 UTC.</p>
+</body>
+</html>
+`},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept", test.accept)
+
+		if test.info != nil {
+			r = r.WithContext(context.WithValue(r.Context(), blockInfoKey{}, test.info))
+		}
+
+		w := httptest.NewRecorder()
+		BlockPage(http.StatusForbidden).ServeHTTP(w, r)
+
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Accept %q: returned %d, expected 403", test.accept, w.Code)
+		}
+
+		if ct := w.Header().Get("Content-Type"); ct != test.contentType {
+			t.Errorf("Accept %q: Content-Type was %q, expected %q", test.accept, ct, test.contentType)
+		}
+
+		if w.Body.String() != test.body {
+			t.Errorf("Accept %q: body was invalid", test.accept)
+			t.Errorf("expected:\t%q", test.body)
+			t.Errorf("got:\t%q", w.Body.String())
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	offers := []string{"text/plain", "text/html", "application/json"}
+
+	for accept, expect := range map[string]string{
+		"":                                "text/plain",
+		"*/*":                             "text/plain",
+		"application/json":                "application/json",
+		"application/*":                   "application/json",
+		"text/*":                          "text/plain",
+		"text/html;q=0.5, */*;q=0.1":      "text/html",
+		"text/plain;q=0, */*":             "text/html",
+		"application/json, text/html":     "text/html",
+		"image/png":                       "text/plain",
+		"TEXT/HTML":                       "text/html",
+		"application/json;q=1, text/*;q=": "text/plain",
+	} {
+		if got := negotiate(accept, offers...); got != expect {
+			t.Errorf("negotiate(%q) returned %s, expected %s", accept, got, expect)
+		}
+	}
+}
+
+func TestOnError(t *testing.T) {
+	server, client, err := setup("192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+
+	/* a closed client fails every check with ErrClosed */
+	client.Close()
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	var handlerErr error
+	errorHandler := func(w http.ResponseWriter, r *http.Request, err error) {
+		handlerErr = err
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	for _, test := range [...]struct {
+		policy       ErrorPolicy
+		errorHandler bool
+		whitelist    bool
+
+		code int
+	}{
+		{FailClosed, false, false, http.StatusInternalServerError},
+		{FailClosed, false, true, http.StatusInternalServerError},
+		{FailOpen, false, false, http.StatusOK},
+		{FailOpen, false, true, http.StatusOK},
+		{FailOpen, true, false, http.StatusServiceUnavailable},
+		{FailClosed, true, false, http.StatusServiceUnavailable},
+	} {
+		var logBuf bytes.Buffer
+		handlerErr = nil
+
+		h := BlockWithCode(client, ok, http.StatusForbidden).(*Handler)
+		h.Whitelist = test.whitelist
+		h.OnError = test.policy
+		h.ErrorLog = log.New(&logBuf, "", 0)
+		h.TrustedProxies = mustParseCIDRs("10.0.0.0/8")
+
+		if test.errorHandler {
+			h.ErrorHandler = errorHandler
+		}
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		r.Header.Set("X-Forwarded-For", "192.0.2.1")
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != test.code {
+			t.Errorf("%s (error handler: %t, whitelist: %t) returned %d, expected %d",
+				test.policy, test.errorHandler, test.whitelist, w.Code, test.code)
+		}
+
+		if test.errorHandler && handlerErr != blocker.ErrClosed {
+			t.Errorf("%s: ErrorHandler was called with %v, expected %v", test.policy, handlerErr, blocker.ErrClosed)
+		}
+
+		if !strings.Contains(logBuf.String(), blocker.ErrClosed.Error()) {
+			t.Errorf("%s: error was not logged, got %q", test.policy, logBuf.String())
+		}
+
+		/* the client address was checked, not the proxy's */
+		if !strings.Contains(logBuf.String(), "192.0.2.1") || strings.Contains(logBuf.String(), "10.0.0.1") {
+			t.Errorf("%s: error was not logged with the client address, got %q", test.policy, logBuf.String())
+		}
+	}
+}
+
+func TestErrorLog(t *testing.T) {
+	server, client, err := setup()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+
+	client.Close()
+
+	h := Block(client, nil).(*Handler)
+
+	/* without ErrorLog the http.Server's ErrorLog is used */
+	var serverBuf bytes.Buffer
+	srv := &http.Server{ErrorLog: log.New(&serverBuf, "", 0)}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r = r.WithContext(context.WithValue(r.Context(), http.ServerContextKey, srv))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("returned %d, expected 500", w.Code)
+	}
+
+	if !strings.Contains(serverBuf.String(), blocker.ErrClosed.Error()) {
+		t.Errorf("error was not logged to the http.Server's ErrorLog, got %q", serverBuf.String())
+	}
+
+	/* outside of http.Server the standard logger is used */
+	var stdBuf bytes.Buffer
+	log.SetOutput(&stdBuf)
+	defer log.SetOutput(os.Stderr)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("returned %d, expected 500", w.Code)
+	}
+
+	if !strings.Contains(stdBuf.String(), blocker.ErrClosed.Error()) {
+		t.Errorf("error was not logged to the standard logger, got %q", stdBuf.String())
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+package httpblocker
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tmthrgd/ip-blocker-agent"
+)
+
+// BlockInfo describes why a request was blocked. It is
+// passed to Handler.Blocked in the request context.
+type BlockInfo struct {
+	// IP is the address that was blocked.
+	IP net.IP
+
+	// List is the name of the list the IP address
+	// matched, or the empty string for the default
+	// blocklist.
+	List string
+
+	// Tag is the tag of the blocklist entry the IP
+	// address matched, or zero if it is untagged.
+	Tag blocker.Tag
+
+	// Reason is the name of Tag, or the empty string if
+	// it has not been given one.
+	Reason string
+
+	// Expires is the time the blocklist entry expires
+	// at, or the zero time if it never expires.
+	Expires time.Time
+}
+
+type blockInfoKey struct{}
+
+// FromContext returns the BlockInfo of a blocked
+// request, or nil if the request was not blocked.
+func FromContext(ctx context.Context) *BlockInfo {
+	info, _ := ctx.Value(blockInfoKey{}).(*BlockInfo)
+	return info
+}
+
+// block describes why ip was blocked in the context of r
+// and calls h.Blocked. e is the blocklist entry that ip
+// matched, it is empty in Whitelist mode.
+//
+// If the blocklist entry has an expiry, Retry-After is
+// set to the time remaining.
+func (h *Handler) block(w http.ResponseWriter, r *http.Request, ip net.IP, e blocker.Entry) {
+	info := &BlockInfo{
+		IP: ip,
+
+		List:    e.List,
+		Tag:     e.Tag,
+		Reason:  e.TagName,
+		Expires: e.Expires,
+	}
+
+	if !info.Expires.IsZero() {
+		if wait := info.Expires.Sub(time.Now()); wait > 0 {
+			secs := (wait + time.Second - 1) / time.Second
+			w.Header().Set("Retry-After", strconv.FormatInt(int64(secs), 10))
+		}
+	}
+
+	h.Blocked.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), blockInfoKey{}, info)))
+}
+
+// negotiate returns the media type out of offers that is
+// most acceptable according to accept, which is the value
+// of an Accept header. Ties are broken in the order of
+// offers, and the first offer is returned if none are
+// acceptable.
+func negotiate(accept string, offers ...string) string {
+	if accept == "" {
+		return offers[0]
+	}
+
+	best, bestQ := offers[0], 0.0
+
+	for _, offer := range offers {
+		/* the most specific matching media range sets q */
+		q, specificity := 0.0, -1
+
+		for _, spec := range strings.Split(accept, ",") {
+			params := strings.Split(spec, ";")
+
+			mediaRange := strings.ToLower(strings.TrimSpace(params[0]))
+
+			var s int
+			switch {
+			case mediaRange == offer:
+				s = 2
+			case mediaRange == "*/*":
+				s = 0
+			case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(offer, mediaRange[:len(mediaRange)-1]):
+				s = 1
+			default:
+				continue
+			}
+
+			if s < specificity {
+				continue
+			}
+
+			specQ := 1.0
+			for _, param := range params[1:] {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(param, "q=") {
+					if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+						specQ = v
+					}
+				}
+			}
+
+			q, specificity = specQ, s
+		}
+
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+
+	return best
+}
+
+var blockPageTemplate = template.Must(template.New("block").Parse(`<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Code}} {{.Status}}</title>
+</head>
+<body>
+<h1>{{.Status}}</h1>
+{{- with .Info}}
+<p>Your IP address, {{.IP}}, has been blocked{{with .Reason}}: {{.}}{{end}}.</p>
+{{- if not .Expires.IsZero}}
+<p>The block expires at {{.Expires.UTC.Format "2006-01-02 15:04:05 MST"}}.</p>
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
+
+type blockPageJSON struct {
+	Error   string `json:"error"`
+	IP      string `json:"ip,omitempty"`
+	List    string `json:"list,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+	Expires string `json:"expires,omitempty"`
+}
+
+type blockPage int
+
+// BlockPage returns a http.Handler that responds with
+// the given status code and a description of why the
+// request was blocked. The description is taken from the
+// BlockInfo in the request context, if any.
+//
+// The response is plain text, HTML or JSON depending on
+// the Accept header of the request.
+func BlockPage(code int) http.Handler {
+	return blockPage(code)
+}
+
+func (code blockPage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	info := FromContext(r.Context())
+	status := http.StatusText(int(code))
+
+	switch negotiate(r.Header.Get("Accept"), "text/plain", "text/html", "application/json") {
+	case "text/html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(int(code))
+
+		blockPageTemplate.Execute(w, struct {
+			Code   int
+			Status string
+			Info   *BlockInfo
+		}{int(code), status, info})
+	case "application/json":
+		resp := blockPageJSON{Error: status}
+		if info != nil {
+			resp.IP, resp.List, resp.Reason = info.IP.String(), info.List, info.Reason
+
+			if !info.Expires.IsZero() {
+				resp.Expires = info.Expires.UTC().Format(time.RFC3339)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(int(code))
+
+		json.NewEncoder(w).Encode(&resp)
+	default:
+		http.Error(w, status, int(code))
+	}
+}
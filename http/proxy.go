@@ -0,0 +1,175 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+package httpblocker
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ForwardedHeader is a request header that a proxy uses
+// to pass on the address of the client it forwarded the
+// request for.
+type ForwardedHeader int
+
+// The supported forwarding headers.
+const (
+	// Forwarded is the RFC 7239 Forwarded header, the
+	// for parameter of each element is used.
+	Forwarded ForwardedHeader = iota
+
+	// XForwardedFor is the X-Forwarded-For header, a
+	// comma separated list of addresses.
+	XForwardedFor
+
+	// XRealIP is the X-Real-IP header, a single address.
+	XRealIP
+)
+
+// DefaultForwardedHeaders are the headers consulted when
+// Handler.ForwardedHeaders is nil.
+var DefaultForwardedHeaders = []ForwardedHeader{Forwarded, XForwardedFor, XRealIP}
+
+func (h ForwardedHeader) String() string {
+	switch h {
+	case Forwarded:
+		return "Forwarded"
+	case XForwardedFor:
+		return "X-Forwarded-For"
+	case XRealIP:
+		return "X-Real-IP"
+	default:
+		return "unknown"
+	}
+}
+
+// parseHop parses a single address from a forwarding
+// header. The address may be quoted, bracketed and have a
+// port. It returns nil for obfuscated or unknown
+// addresses.
+func parseHop(s string) net.IP {
+	s = strings.Trim(strings.TrimSpace(s), `"`)
+
+	if strings.HasPrefix(s, "[") {
+		end := strings.IndexByte(s, ']')
+		if end < 0 {
+			return nil
+		}
+
+		return net.ParseIP(s[1:end])
+	}
+
+	if ip := net.ParseIP(s); ip != nil {
+		return ip
+	}
+
+	/* an IPv4 address with a port */
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		return net.ParseIP(host)
+	}
+
+	return nil
+}
+
+// hops returns the addresses in header, in the order they
+// appear, along with whether the header was present. An
+// address that cannot be parsed is returned as nil.
+func hops(r *http.Request, header ForwardedHeader) (addrs []net.IP, ok bool) {
+	values := r.Header[http.CanonicalHeaderKey(header.String())]
+	if len(values) == 0 {
+		return nil, false
+	}
+
+	for _, value := range values {
+		if header == XRealIP {
+			addrs = append(addrs, parseHop(value))
+			continue
+		}
+
+		for _, elem := range strings.Split(value, ",") {
+			if header != Forwarded {
+				addrs = append(addrs, parseHop(elem))
+				continue
+			}
+
+			var ip net.IP
+			for _, pair := range strings.Split(elem, ";") {
+				if eq := strings.IndexByte(pair, '='); eq >= 0 && strings.EqualFold(strings.TrimSpace(pair[:eq]), "for") {
+					ip = parseHop(pair[eq+1:])
+				}
+			}
+
+			addrs = append(addrs, ip)
+		}
+	}
+
+	return addrs, true
+}
+
+func (h *Handler) trusted(ip net.IP) bool {
+	for _, ipnet := range h.TrustedProxies {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addrs returns the address of the client that made r
+// and every address in the forwarding chain of r,
+// starting with r.RemoteAddr and working backwards.
+//
+// The forwarding headers are only consulted if
+// r.RemoteAddr is a trusted proxy. The chain is then
+// walked from right to left until an address that is not
+// a trusted proxy is found, that is the client. If an
+// address cannot be parsed, the walk stops and the last
+// proxy is treated as the client.
+func (h *Handler) addrs(r *http.Request) (client net.IP, chain []net.IP) {
+	client = net.ParseIP((&url.URL{Host: r.RemoteAddr}).Hostname())
+	chain = []net.IP{client}
+
+	if client == nil || !h.trusted(client) {
+		return
+	}
+
+	headers := h.ForwardedHeaders
+	if headers == nil {
+		headers = DefaultForwardedHeaders
+	}
+
+	for _, header := range headers {
+		addrs, ok := hops(r, header)
+		if !ok {
+			continue
+		}
+
+		/* only the first header present is used */
+		i := len(addrs) - 1
+		for ; i >= 0 && addrs[i] != nil; i-- {
+			client = addrs[i]
+			chain = append(chain, client)
+
+			if !h.trusted(client) {
+				break
+			}
+		}
+
+		/* the rest of the chain was set by the client */
+		for i--; i >= 0; i-- {
+			if addrs[i] != nil {
+				chain = append(chain, addrs[i])
+			}
+		}
+
+		break
+	}
+
+	return
+}
@@ -0,0 +1,281 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+// Package importer parses common blocklist text formats
+// and loads them into an ip-blocker-agent server.
+package importer
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/tmthrgd/ip-blocker-agent"
+)
+
+// ErrUnknownFormat is returned by ParseFormat when the
+// name does not match any supported format.
+var ErrUnknownFormat = errors.New("unknown blocklist format")
+
+// Format is a blocklist text format.
+type Format int
+
+const (
+	// Plain is a list of IP addresses or CIDR blocks,
+	// one per line. Anything following a '#' or ';' is
+	// treated as a comment.
+	Plain Format = iota
+
+	// Netset is the FireHOL netset format, a list of IP
+	// addresses or CIDR blocks, one per line, with '#'
+	// comments.
+	Netset
+
+	// DROP is the format of the Spamhaus DROP and EDROP
+	// lists, a CIDR block per line followed by a ';'
+	// comment.
+	DROP
+
+	// IPSet is the output of `ipset save`. Only add
+	// lines are read; the set name and any options are
+	// ignored. Entries with the nomatch option are
+	// exceptions to a wider entry rather than entries to
+	// block, and are reported as errors.
+	IPSet
+)
+
+var formatNames = [...]string{
+	Plain:  "plain",
+	Netset: "netset",
+	DROP:   "drop",
+	IPSet:  "ipset",
+}
+
+func (f Format) String() string {
+	if f >= 0 && int(f) < len(formatNames) {
+		return formatNames[f]
+	}
+
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+// ParseFormat returns the Format with the given name,
+// one of plain, netset, drop or ipset.
+func ParseFormat(name string) (Format, error) {
+	for f, n := range formatNames {
+		if strings.EqualFold(name, n) {
+			return Format(f), nil
+		}
+	}
+
+	return 0, ErrUnknownFormat
+}
+
+// SyntaxError records a line that could not be parsed.
+type SyntaxError struct {
+	Line int
+	Text string
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d: %s: %q", e.Line, e.Msg, e.Text)
+}
+
+// ErrorList is a list of lines that could not be
+// parsed, in the order they were read.
+type ErrorList []*SyntaxError
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	default:
+		return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+	}
+}
+
+func stripComment(line string, format Format) string {
+	var comments string
+
+	switch format {
+	case Plain:
+		comments = "#;"
+	case Netset, IPSet:
+		comments = "#"
+	case DROP:
+		comments = ";"
+	}
+
+	if i := strings.IndexAny(line, comments); i >= 0 {
+		line = line[:i]
+	}
+
+	return line
+}
+
+func parseAddr(addr string) (net.IP, *net.IPNet, string) {
+	if strings.Contains(addr, "/") {
+		ip, ipnet, err := net.ParseCIDR(addr)
+		if err != nil {
+			return nil, nil, "invalid CIDR block"
+		}
+
+		return ip, ipnet, ""
+	}
+
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, nil, "invalid IP address"
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	return ip, &net.IPNet{
+		IP:   ip,
+		Mask: net.CIDRMask(len(ip)*8, len(ip)*8),
+	}, ""
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Parse reads a blocklist in the given format from r and
+// calls fn with each IP address or CIDR block in turn.
+// Single IP addresses are passed as a CIDR block of
+// one address.
+//
+// Lines that cannot be parsed are skipped and, once all
+// of r has been read, are returned as an ErrorList. Any
+// error from reading r or returned by fn stops Parse and
+// is returned as is.
+func Parse(r io.Reader, format Format, fn func(ip net.IP, ipnet *net.IPNet) error) error {
+	if format < 0 || int(format) >= len(formatNames) {
+		return ErrUnknownFormat
+	}
+
+	var errs ErrorList
+
+	scanner := bufio.NewScanner(r)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+
+		fields := strings.Fields(stripComment(text, format))
+		if len(fields) == 0 {
+			continue
+		}
+
+		if format == IPSet {
+			switch fields[0] {
+			case "add":
+			case "create", "flush", "destroy", "rename", "swap":
+				continue
+			default:
+				errs = append(errs, &SyntaxError{line, text, "unknown ipset command"})
+				continue
+			}
+
+			if len(fields) < 3 {
+				errs = append(errs, &SyntaxError{line, text, "missing ipset entry"})
+				continue
+			}
+
+			if containsString(fields[3:], "nomatch") {
+				errs = append(errs, &SyntaxError{line, text, "unsupported ipset nomatch entry"})
+				continue
+			}
+
+			// Only the address is used, any other options
+			// such as timeout or comment are ignored.
+			fields = fields[2:3]
+		}
+
+		if len(fields) != 1 {
+			errs = append(errs, &SyntaxError{line, text, "unexpected text after address"})
+			continue
+		}
+
+		ip, ipnet, msg := parseAddr(fields[0])
+		if msg != "" {
+			errs = append(errs, &SyntaxError{line, text, msg})
+			continue
+		}
+
+		if err := fn(ip, ipnet); err != nil {
+			return err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if errs != nil {
+		return errs
+	}
+
+	return nil
+}
+
+// Import reads a blocklist in the given format from r and
+// inserts every IP address and CIDR block it contains
+// into the blocklist of s. It returns the number of
+// entries inserted.
+//
+// All entries are inserted within a single Batch() and
+// Commit(), so clients see either none or all of them.
+// If s is already batching, the entries are inserted
+// into the existing batch and not committed. They are
+// then only published when the caller that started the
+// batch calls Commit(), and a later Clear() or Load()
+// in that batch discards them.
+//
+// Lines that cannot be parsed are skipped and returned
+// as an ErrorList once the remaining entries have been
+// committed. If reading r fails, the entries read so far
+// are still committed.
+func Import(s *blocker.Server, r io.Reader, format Format) (n int, err error) {
+	batching := true
+
+	switch err = s.Batch(); err {
+	case nil:
+	case blocker.ErrAlreadyBatching:
+		batching = false
+	default:
+		return 0, err
+	}
+
+	err = Parse(r, format, func(ip net.IP, ipnet *net.IPNet) error {
+		if err := s.InsertRange(ip, ipnet); err != nil {
+			return err
+		}
+
+		n++
+		return nil
+	})
+
+	if batching {
+		if cerr := s.Commit(); cerr != nil {
+			return n, cerr
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,218 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+package importer
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tmthrgd/ip-blocker-agent"
+)
+
+var nameRand *rand.Rand
+
+func init() {
+	var seed [8]byte
+
+	if _, err := crand.Read(seed[:]); err != nil {
+		panic(err)
+	}
+
+	seedInt := int64(binary.LittleEndian.Uint64(seed[:]))
+	nameRand = rand.New(rand.NewSource(seedInt))
+}
+
+func parseAll(input string, format Format) ([]string, error) {
+	var nets []string
+
+	err := Parse(strings.NewReader(input), format, func(ip net.IP, ipnet *net.IPNet) error {
+		nets = append(nets, ipnet.String())
+		return nil
+	})
+	return nets, err
+}
+
+func TestParse(t *testing.T) {
+	for _, test := range [...]struct {
+		format Format
+		input  string
+		expect []string
+	}{
+		{Plain, `# comment
+192.0.2.0
+  198.51.100.0/24   ; comment
+
+2001:db8::/32 # comment
+; comment
+`, []string{"192.0.2.0/32", "198.51.100.0/24", "2001:db8::/32"}},
+		{Netset, `#
+# firehol_level1
+#
+# Source: https://iplists.firehol.org/
+#
+192.0.2.0/24
+198.51.100.7
+`, []string{"192.0.2.0/24", "198.51.100.7/32"}},
+		{DROP, `; Spamhaus DROP List 2017/06/01 - (c) 2017 The Spamhaus Project
+; Last-Modified: Thu, 1 Jun 2017 12:00:00 GMT
+; Expires: Thu, 1 Jun 2017 13:00:00 GMT
+192.0.2.0/24 ; SBL000001
+198.51.100.0/22 ; SBL000002
+`, []string{"192.0.2.0/24", "198.51.100.0/22"}},
+		{IPSet, `create blacklist hash:net family inet hashsize 1024 maxelem 65536
+add blacklist 192.0.2.0/24
+add blacklist 198.51.100.7 timeout 3600
+create blacklist6 hash:net family inet6 hashsize 1024 maxelem 65536
+add blacklist6 2001:db8::/32 comment "test"
+`, []string{"192.0.2.0/24", "198.51.100.7/32", "2001:db8::/32"}},
+	} {
+		nets, err := parseAll(test.input, test.format)
+		if err != nil {
+			t.Errorf("Parse failed for %s format: %v", test.format, err)
+		}
+
+		if !reflect.DeepEqual(nets, test.expect) {
+			t.Errorf("Parse returned invalid entries for %s format, expected %v, got %v", test.format, test.expect, nets)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, test := range [...]struct {
+		format Format
+		input  string
+		lines  []int
+	}{
+		{Plain, "192.0.2.0\n192.0.2.256\n198.51.100.0/33\n", []int{2, 3}},
+		{Netset, "192.0.2.0/24 ; comment\n", []int{1}},
+		{DROP, "192.0.2.0/24 SBL000001\n192.0.2.0/24 ; SBL000001\n", []int{1}},
+		{IPSet, "add blacklist\ndel blacklist 192.0.2.0\nadd blacklist 192.0.2.1-192.0.2.7\n", []int{1, 2, 3}},
+		{IPSet, "add blacklist 192.0.2.0/24\nadd blacklist 192.0.2.7 nomatch\nadd blacklist 192.0.2.8 timeout 60 nomatch\n", []int{2, 3}},
+	} {
+		_, err := parseAll(test.input, test.format)
+
+		errs, ok := err.(ErrorList)
+		if !ok {
+			t.Errorf("Parse did not return ErrorList for %s format, got %v", test.format, err)
+			continue
+		}
+
+		var lines []int
+		for _, err := range errs {
+			lines = append(lines, err.Line)
+		}
+
+		if !reflect.DeepEqual(lines, test.lines) {
+			t.Errorf("Parse returned errors for invalid lines of %s format, expected %v, got %v", test.format, test.lines, lines)
+		}
+	}
+
+	errStop := errors.New("stop")
+
+	var calls int
+
+	err := Parse(strings.NewReader("192.0.2.0\n192.0.2.1\n"), Plain, func(ip net.IP, ipnet *net.IPNet) error {
+		calls++
+		return errStop
+	})
+	if err != errStop || calls != 1 {
+		t.Errorf("Parse did not stop on error from fn, got %v after %d calls", err, calls)
+	}
+
+	if _, err = parseAll("", Format(-1)); err != ErrUnknownFormat {
+		t.Errorf("Parse did not return ErrUnknownFormat, got %v", err)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, f := range [...]Format{Plain, Netset, DROP, IPSet} {
+		parsed, err := ParseFormat(f.String())
+		if err != nil {
+			t.Error(err)
+		}
+
+		if parsed != f {
+			t.Errorf("ParseFormat(%q) returned %v", f.String(), parsed)
+		}
+	}
+
+	if _, err := ParseFormat("csv"); err != ErrUnknownFormat {
+		t.Errorf("ParseFormat did not return ErrUnknownFormat, got %v", err)
+	}
+}
+
+func TestImport(t *testing.T) {
+	name := fmt.Sprintf("/go-test-%d", nameRand.Int())
+
+	server, err := blocker.New(name, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+
+	client, err := blocker.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer client.Close()
+
+	n, err := Import(server, strings.NewReader(`192.0.2.0/24
+invalid
+2001:db8::1
+`), Netset)
+	if errs, ok := err.(ErrorList); !ok || len(errs) != 1 || errs[0].Line != 2 {
+		t.Errorf("Import did not return error for line 2, got %v", err)
+	}
+
+	if n != 2 {
+		t.Errorf("Import returned %d, expected 2", n)
+	}
+
+	if server.IsBatching() {
+		t.Error("Import did not end batch")
+	}
+
+	for addr, expect := range map[string]bool{
+		"192.0.2.7":   true,
+		"2001:db8::1": true,
+		"2001:db8::2": false,
+	} {
+		has, err := client.Contains(net.ParseIP(addr))
+		if err != nil {
+			t.Error(err)
+		}
+
+		if has != expect {
+			t.Errorf("Contains(%s) returned %t, expected %t", addr, has, expect)
+		}
+	}
+
+	if err = server.Batch(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = Import(server, strings.NewReader("198.51.100.0\n"), Plain); err != nil {
+		t.Error(err)
+	}
+
+	if !server.IsBatching() {
+		t.Error("Import ended existing batch")
+	}
+
+	if has, _ := client.Contains(net.ParseIP("198.51.100.0")); has {
+		t.Error("Import committed existing batch")
+	}
+}
@@ -8,14 +8,23 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 
 	"github.com/tmthrgd/ip-blocker-agent"
+	"github.com/tmthrgd/ip-blocker-agent/feed"
+	"github.com/tmthrgd/ip-blocker-agent/importer"
 )
 
 type octalValue int
@@ -34,13 +43,86 @@ func (o *octalValue) String() string {
 	return fmt.Sprintf("%#o", *o)
 }
 
-func printServer(server *blocker.Server) {
-	ip4, ip6, ip6r, err := server.Count()
+func printServer(w io.Writer, server *blocker.Server) {
+	ip4, ip6, err := server.Count()
 	if err != nil {
 		panic(err)
 	}
 
-	fmt.Printf("IP4: %d, IP6: %d, IP6 routes: %d\n", ip4, ip6, ip6r)
+	fmt.Fprintf(w, "IP4: %d, IP6: %d\n", ip4, ip6)
+}
+
+func importFile(name string, perms os.FileMode, args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+
+	var format string
+	flags.StringVar(&format, "format", "plain", "the blocklist format: plain, netset, drop or ipset")
+
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "%s [-name <path>] [-perms <perms>] import [-format <format>] <file>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	f, err := importer.ParseFormat(format)
+	if err != nil {
+		fmt.Printf("%v: %s\n", err, format)
+		os.Exit(1)
+	}
+
+	file, err := os.Open(os.ExpandEnv(flags.Arg(0)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		panic(err)
+	}
+
+	defer file.Close()
+
+	server, err := blocker.Attach(name)
+	if os.IsNotExist(err) {
+		server, err = blocker.New(name, perms)
+	}
+
+	if err != nil {
+		if err == blocker.ErrWriterAlive || err == blocker.ErrInvalidSharedMemory {
+			fmt.Println(err)
+			os.Exit(1)
+		} else {
+			panic(err)
+		}
+	}
+
+	n, err := importer.Import(server, file, f)
+
+	errs, invalid := err.(importer.ErrorList)
+	if invalid {
+		for _, err := range errs {
+			fmt.Printf("%s: %v\n", flags.Arg(0), err)
+		}
+	} else if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("imported %d entries\n", n)
+	printServer(os.Stdout, server)
+
+	if err = server.Close(); err != nil {
+		panic(err)
+	}
+
+	if invalid {
+		os.Exit(1)
+	}
 }
 
 func main() {
@@ -50,8 +132,32 @@ func main() {
 	perms := 0600
 	flag.Var((*octalValue)(&perms), "perms", "permissions")
 
+	var attach bool
+	flag.BoolVar(&attach, "attach", false, "take over an existing blocklist and leave it in place on exit")
+
+	var socket string
+	flag.StringVar(&socket, "socket", "", "the path of a Unix-domain control socket to listen on")
+
+	socketPerms := 0600
+	flag.Var((*octalValue)(&socketPerms), "socket-perms", "control socket permissions")
+
+	var asJSON bool
+	flag.BoolVar(&asJSON, "json", false, "reply to every command with a single line of JSON")
+
+	var daemon bool
+	flag.BoolVar(&daemon, "daemon", false, "do not read commands from stdin and run until SIGINT or SIGTERM")
+
+	var httpAddr string
+	flag.StringVar(&httpAddr, "http", "", "the address to serve the HTTP/JSON management API on")
+
+	var tokenFile string
+	flag.StringVar(&tokenFile, "http-token-file", "", "a file containing the bearer token the HTTP/JSON management API requires")
+
+	var feeds string
+	flag.StringVar(&feeds, "feeds", "", "a JSON file of blocklist feeds to keep the blocklist up to date with")
+
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "%s [-name <path>] [-perms <perms>] [unlink]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "%s [-name <path>] [-perms <perms>] [-attach] [-socket <path>] [-socket-perms <perms>] [-json] [-daemon] [-http <addr> -http-token-file <path>] [-feeds <path>] [unlink|recover|import]\n", os.Args[0])
 		flag.PrintDefaults()
 	}
 
@@ -62,21 +168,31 @@ func main() {
 		os.Exit(1)
 	}
 
+	if flag.Arg(0) == "import" {
+		importFile(name, os.FileMode(perms), flag.Args()[1:])
+		return
+	}
+
 	switch flag.NArg() {
 	case 0:
 	case 1:
-		if flag.Arg(0) != "unlink" {
+		var err error
+
+		switch flag.Arg(0) {
+		case "unlink":
+			err = blocker.Unlink(name)
+		case "recover":
+			err = blocker.Recover(name)
+		default:
 			flag.Usage()
 			os.Exit(1)
 		}
 
-		if err := blocker.Unlink(name); err != nil {
-			if os.IsNotExist(err) {
-				fmt.Println(err)
-				os.Exit(1)
-			} else {
-				panic(err)
-			}
+		if err != nil {
+			r := &reply{Status: "error"}
+			r.failErr(err)
+			r.write(os.Stdout, asJSON)
+			os.Exit(1)
 		}
 
 		return
@@ -85,9 +201,43 @@ func main() {
 		os.Exit(1)
 	}
 
-	server, err := blocker.New(name, os.FileMode(perms))
+	var token string
+
+	if httpAddr != "" {
+		b, err := ioutil.ReadFile(tokenFile)
+		if err != nil && !os.IsNotExist(err) {
+			panic(err)
+		}
+
+		if token = strings.TrimSpace(string(b)); len(token) == 0 {
+			fmt.Println("-http requires a non-empty -http-token-file")
+			os.Exit(1)
+		}
+	}
+
+	var sources []feed.Source
+
+	if feeds != "" {
+		var err error
+		if sources, err = loadFeeds(feeds); err != nil {
+			fmt.Printf("invalid feeds: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var server *blocker.Server
+	var err error
+
+	if attach {
+		server, err = blocker.Attach(name)
+	}
+
+	if !attach || os.IsNotExist(err) {
+		server, err = blocker.New(name, os.FileMode(perms))
+	}
+
 	if err != nil {
-		if os.IsExist(err) {
+		if os.IsExist(err) || err == blocker.ErrWriterAlive || err == blocker.ErrInvalidSharedMemory {
 			fmt.Println(err)
 			os.Exit(1)
 		} else {
@@ -95,172 +245,110 @@ func main() {
 		}
 	}
 
-	defer server.Unlink()
-	defer server.Close()
-
-	printServer(server)
-
-	stdin := bufio.NewScanner(os.Stdin)
-
-	for stdin.Scan() {
-		line := stdin.Text()
-		if len(line) == 0 {
-			fmt.Printf("invalid input: %s\n", line)
-			continue
+	/* registered first so that it runs after every other deferred call */
+	exitCode := 0
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
 		}
+	}()
 
-		switch line[0] {
-		case '+':
-			fallthrough
-		case '-':
-			if len(line) <= 1 {
-				fmt.Printf("invalid input: %s\n", line)
-				continue
-			}
+	if !attach {
+		defer server.Unlink()
+	}
 
-			if strings.Contains(line[1:], "/") {
-				ip, ipnet, err := net.ParseCIDR(line[1:])
-				if err != nil {
-					fmt.Printf("invalid cidr mask: %s (%v)\n", line[1:], err)
-					continue
-				}
-
-				switch line[0] {
-				case '+':
-					err = server.InsertRange(ip, ipnet)
-				case '-':
-					err = server.RemoveRange(ip, ipnet)
-				}
-			} else {
-				ip := net.ParseIP(line[1:])
-				if ip == nil {
-					fmt.Printf("invalid ip address: %s\n", line[1:])
-					continue
-				}
-
-				switch line[0] {
-				case '+':
-					err = server.Insert(ip)
-				case '-':
-					err = server.Remove(ip)
-				}
-			}
+	defer server.Close()
 
-			if err != nil {
-				panic(err)
-			}
+	var sub *feed.Subscriber
 
-			if !server.IsBatching() {
-				printServer(server)
-			}
-		case '!':
-			if len(line) != 1 {
-				fmt.Printf("invalid input: %s\n", line)
-				continue
-			}
+	if sources != nil {
+		if sub, err = feed.New(server, sources...); err != nil {
+			fmt.Printf("invalid feeds: %v\n", err)
+			return
+		}
 
-			if err = server.Clear(); err != nil {
-				panic(err)
-			}
+		sub.ErrorLog = log.New(os.Stderr, "", log.LstdFlags)
+		sub.ApplyHook = applyFeed(server)
 
-			if !server.IsBatching() {
-				printServer(server)
-			}
-		case 'b':
-			if len(line) != 1 && !strings.EqualFold(line, "batch") {
-				fmt.Printf("invalid input: %s\n", line)
-				continue
-			}
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
 
-			if err = server.Batch(); err != nil {
-				if err == blocker.ErrAlreadyBatching {
-					fmt.Println(err)
-				} else {
-					panic(err)
-				}
-			}
-		case 'B':
-			if len(line) != 1 && !strings.EqualFold(line, "batch") {
-				fmt.Printf("invalid input: %s\n", line)
-				continue
-			}
+		go func() {
+			sub.Run(ctx)
+			close(done)
+		}()
 
-			if err = server.Commit(); err != nil {
-				if err == blocker.ErrNotBatching {
-					fmt.Println(err)
-					continue
-				} else {
-					panic(err)
-				}
-			}
+		defer func() {
+			cancel()
+			<-done
+		}()
+	}
 
-			printServer(server)
-		case 'q':
-			fallthrough
-		case 'Q':
-			if len(line) == 1 || strings.EqualFold(line, "quit") {
+	if socket != "" {
+		ctl, err := listenControl(server, socket, os.FileMode(socketPerms), asJSON)
+		if err != nil {
+			if err == errSocketInUse {
+				fmt.Println(err)
 				return
 			}
 
-			fmt.Printf("invalid input: %s\n", line)
-		case 's':
-			fallthrough
-		case 'S':
-			if len(line) <= 1 {
-				fmt.Printf("invalid input: %s\n", line)
-				continue
-			}
+			panic(err)
+		}
 
-			f, err := os.Create(os.ExpandEnv(line[1:]))
-			if err != nil {
-				panic(err)
-			}
+		go ctl.serve()
+		defer ctl.Close()
+	}
 
-			err = server.Save(f)
-			f.Close()
+	if httpAddr != "" {
+		client, err := blocker.Open(name)
+		if err != nil {
+			panic(err)
+		}
 
-			if err != nil {
-				panic(err)
-			}
-		case 'l':
-			fallthrough
-		case 'L':
-			if len(line) <= 1 {
-				fmt.Printf("invalid input: %s\n", line)
-				continue
-			}
+		defer client.Close()
 
-			f, err := os.Open(os.ExpandEnv(line[1:]))
-			if err != nil {
-				if os.IsNotExist(err) {
-					fmt.Println(err)
-					continue
-				}
+		ln, err := net.Listen("tcp", httpAddr)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
 
-				panic(err)
-			}
+		srv := &http.Server{Handler: newAPIHandler(server, client, token)}
+		go srv.Serve(ln)
 
-			err = server.Load(f)
-			f.Close()
+		defer srv.Shutdown(context.Background())
+	}
 
-			if err != nil {
-				if _, ok := err.(blocker.InvalidDataError); ok {
-					fmt.Println(err)
-					continue
-				}
+	status := &reply{showCount: true}
+	status.finish(server)
+	status.write(os.Stdout, asJSON)
 
-				panic(err)
-			}
+	if daemon {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		return
+	}
 
-			if !server.IsBatching() {
-				printServer(server)
-			}
-		default:
-			fmt.Printf("invalid operation: %c\n", line[0])
+	stdin := bufio.NewScanner(os.Stdin)
+
+	for stdin.Scan() {
+		r := command(server, stdin.Text(), false)
+		r.write(os.Stdout, asJSON)
+
+		if r.quit {
+			return
 		}
 	}
 
 	if err = stdin.Err(); err != nil {
-		panic(err)
+		commandMu.Lock()
+		r := new(reply)
+		r.failErr(err)
+		r.finish(server)
+		commandMu.Unlock()
+
+		r.write(os.Stdout, asJSON)
+		exitCode = 1
 	}
 }
@@ -6,17 +6,23 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	crand "crypto/rand"
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"math/rand"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
+	"reflect"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 
@@ -85,7 +91,11 @@ b
 +2001:db8::/126
 -192.0.2.3
 -192.0.2.4
+=192.0.2.6
+=192.0.2.128/31
+~192.0.2.128/31
 B
+d
 `), quit)
 
 	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
@@ -116,6 +126,21 @@ B
 				t.Errorf("server does not contain %s", addr)
 			}
 		}
+
+		for addr, expect := range map[string]blocker.Result{
+			"192.0.2.0":   blocker.Blocked,
+			"192.0.2.6":   blocker.Allowed,
+			"192.0.2.128": blocker.NotListed,
+		} {
+			res, err := client.Check(net.ParseIP(addr))
+			if err != nil {
+				t.Error(err)
+			}
+
+			if res != expect {
+				t.Errorf("Check(%s) returned %v, expected %v", addr, res, expect)
+			}
+		}
 	}()
 
 	cmd.Run()
@@ -124,19 +149,463 @@ B
 		t.Errorf("stderr was not empty, got: %s", stderr.Bytes())
 	}
 
-	expect := `IP4: 0, IP6: 0, IP6 routes: 0
-IP4: 1, IP6: 0, IP6 routes: 0
-IP4: 2, IP6: 0, IP6 routes: 0
-IP4: 1, IP6: 0, IP6 routes: 0
-IP4: 3, IP6: 0, IP6 routes: 0
-IP4: 3, IP6: 1, IP6 routes: 0
-IP4: 3, IP6: 2, IP6 routes: 0
-IP4: 0, IP6: 0, IP6 routes: 0
-IP4: 6, IP6: 4, IP6 routes: 0
+	expect := `IP4: 0, IP6: 0
+IP4: 1, IP6: 0
+IP4: 1, IP6: 0
+IP4: 1, IP6: 0
+IP4: 2, IP6: 0
+IP4: 2, IP6: 1
+IP4: 2, IP6: 1
+IP4: 0, IP6: 0
+IP4: 2, IP6: 1
+192.0.2.0/31
+192.0.2.2/32
+192.0.2.5/32
+192.0.2.6/31
+2001:db8::/126
+`
+	if stdout.String() != expect {
+		t.Error("stdout was invalid")
+		t.Errorf("expected:\t%q", expect)
+		t.Errorf("got:\t\t%q", stdout.String())
+	}
+}
+
+func TestImport(t *testing.T) {
+	name := fmt.Sprintf("/go-test-%d", nameRand.Int())
+
+	f, err := ioutil.TempFile("", "go-test-import")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.Remove(f.Name())
+
+	_, err = f.WriteString(`# firehol netset
+192.0.2.0/24
+invalid
+2001:db8::/126
+`)
+	f.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(agentExe, "-name", name, "import", "-format=netset", f.Name())
+
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err = cmd.Run(); err == nil {
+		t.Error("import did not fail for invalid line")
+	}
+
+	defer blocker.Unlink(name)
+
+	if stderr.Len() != 0 {
+		t.Errorf("stderr was not empty, got: %s", stderr.Bytes())
+	}
+
+	expect := f.Name() + `: line 3: invalid IP address: "invalid"
+imported 2 entries
+IP4: 1, IP6: 1
 `
 	if stdout.String() != expect {
 		t.Error("stdout was invalid")
 		t.Errorf("expected:\t%q", expect)
 		t.Errorf("got:\t\t%q", stdout.String())
 	}
+
+	client, err := blocker.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer client.Close()
+
+	for _, addr := range [...]string{"192.0.2.7", "2001:db8::3"} {
+		has, err := client.Contains(net.ParseIP(addr))
+		if err != nil {
+			t.Error(err)
+		}
+
+		if !has {
+			t.Errorf("server does not contain %s", addr)
+		}
+	}
+}
+
+func TestFeeds(t *testing.T) {
+	name := fmt.Sprintf("/go-test-%d", nameRand.Int())
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "# netset\n192.0.2.0/24\n2001:db8::/64\n")
+	}))
+	defer ts.Close()
+
+	f, err := ioutil.TempFile("", "go-test-feeds")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.Remove(f.Name())
+
+	_, err = fmt.Fprintf(f, `{"sources": [{"name": "test", "url": %q, "format": "netset", "interval": "1h"}]}`, ts.URL)
+	f.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(agentExe, "-name", name, "-feeds", f.Name())
+
+	quit := quitReader{make(chan struct{})}
+	cmd.Stdin = quit
+
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	go func() {
+		defer close(quit.ch)
+
+		time.Sleep(100 * time.Millisecond)
+
+		client, err := blocker.Open(name)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		defer client.Close()
+
+		for _, addr := range [...]string{"192.0.2.7", "2001:db8::1"} {
+			has, err := client.Contains(net.ParseIP(addr))
+			if err != nil {
+				t.Error(err)
+			}
+
+			if !has {
+				t.Errorf("server does not contain %s", addr)
+			}
+		}
+	}()
+
+	cmd.Run()
+
+	if stderr.Len() != 0 {
+		t.Errorf("stderr was not empty, got: %s", stderr.Bytes())
+	}
+}
+
+func TestApplyFeed(t *testing.T) {
+	name := fmt.Sprintf("/go-test-%d", nameRand.Int())
+
+	server, err := blocker.New(name, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+
+	hook := applyFeed(server)
+
+	var applied bool
+	apply := func() error {
+		applied = true
+		return nil
+	}
+
+	if r := command(server, "b", false); r.Error != "" {
+		t.Fatal(r.Error)
+	}
+
+	if err = hook(apply); err != errFeedBatching || applied {
+		t.Errorf("feed update was applied during a batch, got %v", err)
+	}
+
+	if r := command(server, "B", false); r.Error != "" {
+		t.Fatal(r.Error)
+	}
+
+	if err = hook(apply); err != nil || !applied {
+		t.Errorf("feed update was not applied after the batch was committed, got %v", err)
+	}
+}
+
+func TestSocket(t *testing.T) {
+	name := fmt.Sprintf("/go-test-%d", nameRand.Int())
+
+	dir, err := ioutil.TempDir("", "go-test-socket")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	socket := dir + "/ctl.sock"
+	snapshot := dir + "/snapshot"
+
+	cmd := exec.Command(agentExe, "-name", name, "-socket", socket, "-socket-perms", "0660", "-daemon")
+
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err = cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	var conns [2]net.Conn
+
+	for i := range conns {
+		for try := 0; ; try++ {
+			if conns[i], err = net.Dial("unix", socket); err == nil || try == 20 {
+				break
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			t.Fatal(err)
+		}
+
+		defer conns[i].Close()
+	}
+
+	if stat, err := os.Stat(socket); err != nil {
+		t.Error(err)
+	} else if stat.Mode().Perm() != 0660 {
+		t.Errorf("socket has permissions %#o, expected 0660", stat.Mode().Perm())
+	}
+
+	readers := [...]*bufio.Reader{bufio.NewReader(conns[0]), bufio.NewReader(conns[1])}
+
+	for _, test := range [...]struct {
+		conn        int
+		line, reply string
+	}{
+		{0, "+192.0.2.0", "IP4: 1, IP6: 0"},
+		{1, "b", "ok"},
+		{0, "+2001:db8::", "ok"},
+		{1, "B", "IP4: 1, IP6: 1"},
+		{1, "invalid", "invalid operation: i"},
+		{0, "+192.0.2.1/33", `invalid cidr mask: 192.0.2.1/33 (invalid CIDR address: 192.0.2.1/33)`},
+		{1, "s" + snapshot, "forbidden on the control socket: s"},
+		{1, "l" + snapshot, "forbidden on the control socket: l"},
+		{0, "q", "ok"},
+	} {
+		if _, err := io.WriteString(conns[test.conn], test.line+"\n"); err != nil {
+			t.Fatal(err)
+		}
+
+		reply, err := readers[test.conn].ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if reply != test.reply+"\n" {
+			t.Errorf("%q returned %q, expected %q", test.line, reply, test.reply+"\n")
+		}
+	}
+
+	if _, err = readers[0].ReadString('\n'); err != io.EOF {
+		t.Errorf("connection was not closed after q, got %v", err)
+	}
+
+	if _, err = os.Stat(snapshot); !os.IsNotExist(err) {
+		t.Errorf("s over the control socket created %s", snapshot)
+	}
+
+	client, err := blocker.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, addr := range [...]string{"192.0.2.0", "2001:db8::"} {
+		has, err := client.Contains(net.ParseIP(addr))
+		if err != nil {
+			t.Error(err)
+		}
+
+		if !has {
+			t.Errorf("server does not contain %s", addr)
+		}
+	}
+
+	client.Close()
+
+	if err = cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = cmd.Wait(); err != nil {
+		t.Errorf("agent did not exit cleanly: %v", err)
+	}
+
+	if stderr.Len() != 0 {
+		t.Errorf("stderr was not empty, got: %s", stderr.Bytes())
+	}
+
+	if _, err = os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("socket was not removed, got %v", err)
+	}
+
+	if _, err = blocker.Open(name); !os.IsNotExist(err) {
+		t.Errorf("shared memory was not unlinked, got %v", err)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	name := fmt.Sprintf("/go-test-%d", nameRand.Int())
+
+	dir, err := ioutil.TempDir("", "go-test-json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	cmd := exec.Command(agentExe, "-name", name, "-json")
+	cmd.Stdin = strings.NewReader(`+192.0.2.0
++192.0.2.0/33
+x
+b
+b
++2001:db8::
+B
+B
+s` + dir + `/missing/file
+l` + dir + `/missing
+s` + dir + `/file
+l` + dir + `/file
+d
+q
+`)
+
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err = cmd.Run(); err != nil {
+		t.Errorf("agent did not exit cleanly: %v", err)
+	}
+
+	if stderr.Len() != 0 {
+		t.Errorf("stderr was not empty, got: %s", stderr.Bytes())
+	}
+
+	type reply struct {
+		Status   string   `json:"status"`
+		Code     string   `json:"code"`
+		IP4      int      `json:"ip4"`
+		IP6      int      `json:"ip6"`
+		Batching bool     `json:"batching"`
+		Output   []string `json:"output"`
+	}
+
+	var replies []reply
+
+	dec := json.NewDecoder(stdout)
+	for dec.More() {
+		var r reply
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+
+		replies = append(replies, r)
+	}
+
+	expect := []reply{
+		{"ok", "", 0, 0, false, nil},
+		{"ok", "", 1, 0, false, nil},
+		{"error", "invalid_address", 1, 0, false, nil},
+		{"error", "invalid_operation", 1, 0, false, nil},
+		{"ok", "", 1, 0, true, nil},
+		{"error", "already_batching", 1, 0, true, nil},
+		{"ok", "", 1, 0, true, nil},
+		{"ok", "", 1, 1, false, nil},
+		{"error", "not_batching", 1, 1, false, nil},
+		{"error", "not_found", 1, 1, false, nil},
+		{"error", "not_found", 1, 1, false, nil},
+		{"ok", "", 1, 1, false, nil},
+		{"ok", "", 1, 1, false, nil},
+		{"ok", "", 1, 1, false, []string{"192.0.2.0/32", "2001:db8::/128"}},
+		{"ok", "", 1, 1, false, nil},
+	}
+
+	if !reflect.DeepEqual(replies, expect) {
+		t.Error("replies were invalid")
+		t.Errorf("expected:\t%+v", expect)
+		t.Errorf("got:\t\t%+v", replies)
+	}
+}
+
+func TestJSONFatal(t *testing.T) {
+	name := fmt.Sprintf("/go-test-%d", nameRand.Int())
+
+	dir, err := ioutil.TempDir("", "go-test-json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	/* reading a directory fails with EISDIR */
+	stdin, err := os.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer stdin.Close()
+
+	for _, test := range [...]struct {
+		args  []string
+		stdin *os.File
+		code  string
+	}{
+		{[]string{"-name", name, "-json"}, stdin, "error"},
+		{[]string{"-name", name, "-json", "unlink"}, nil, "not_found"},
+	} {
+		cmd := exec.Command(agentExe, test.args...)
+		if test.stdin != nil {
+			cmd.Stdin = test.stdin
+		}
+
+		stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+
+		if err = cmd.Run(); err == nil {
+			t.Errorf("%v: agent exited cleanly", test.args)
+		} else if _, ok := err.(*exec.ExitError); !ok {
+			t.Fatal(err)
+		}
+
+		if stderr.Len() != 0 {
+			t.Errorf("%v: stderr was not empty, got: %s", test.args, stderr.Bytes())
+		}
+
+		var r struct {
+			Status string `json:"status"`
+			Code   string `json:"code"`
+		}
+
+		dec := json.NewDecoder(stdout)
+		for dec.More() {
+			if err := dec.Decode(&r); err != nil {
+				t.Fatalf("%v: %v", test.args, err)
+			}
+		}
+
+		if r.Status != "error" || r.Code != test.code {
+			t.Errorf("%v: last reply was %+v, expected error with code %s", test.args, r, test.code)
+		}
+	}
 }
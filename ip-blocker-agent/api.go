@@ -0,0 +1,279 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/tmthrgd/ip-blocker-agent"
+)
+
+// apiHandler serves the HTTP/JSON management API.
+//
+// Changes are made to server and serialised with commands
+// from stdin and the control socket. Membership queries
+// are answered by client, so they reflect what has been
+// committed to shared memory.
+type apiHandler struct {
+	server *blocker.Server
+	client *blocker.Client
+
+	token []byte
+
+	mux *http.ServeMux
+}
+
+func newAPIHandler(server *blocker.Server, client *blocker.Client, token string) *apiHandler {
+	h := &apiHandler{
+		server: server,
+		client: client,
+
+		token: []byte(token),
+
+		mux: http.NewServeMux(),
+	}
+
+	h.mux.HandleFunc("/v1/blocklist/", h.list("/v1/blocklist/", server.Insert, server.InsertRange, server.Remove, server.RemoveRange))
+	h.mux.HandleFunc("/v1/allowlist/", h.list("/v1/allowlist/", server.Allow, server.AllowRange, server.Disallow, server.DisallowRange))
+	h.mux.HandleFunc("/v1/check/", h.check)
+	h.mux.HandleFunc("/v1/clear", h.post(server.Clear))
+	h.mux.HandleFunc("/v1/batch", h.post(server.Batch))
+	h.mux.HandleFunc("/v1/commit", h.post(server.Commit))
+	h.mux.HandleFunc("/v1/count", h.count)
+	h.mux.HandleFunc("/v1/snapshot", h.snapshot)
+	return h
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+type apiCount struct {
+	IP4      int  `json:"ip4"`
+	IP6      int  `json:"ip6"`
+	Batching bool `json:"batching"`
+}
+
+type apiCheck struct {
+	IP     string `json:"ip"`
+	Result string `json:"result"`
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// errorCode returns the HTTP status code for an error
+// returned by the server or client.
+func errorCode(err error) int {
+	switch err.(type) {
+	case blocker.InvalidDataError:
+		if err.(blocker.InvalidDataError).Err == blocker.ErrSnapshotTooLarge {
+			return http.StatusRequestEntityTooLarge
+		}
+
+		return http.StatusBadRequest
+	case *net.AddrError, *net.ParseError:
+		return http.StatusBadRequest
+	}
+
+	switch err {
+	case io.EOF, io.ErrUnexpectedEOF:
+		/* a truncated snapshot passed to Load */
+		return http.StatusBadRequest
+	case blocker.ErrAlreadyBatching, blocker.ErrNotBatching:
+		return http.StatusConflict
+	case blocker.ErrClosed, blocker.ErrInvalidSharedMemory:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	writeJSON(w, errorCode(err), &apiError{err.Error()})
+}
+
+func writeStatus(w http.ResponseWriter, code int) {
+	writeJSON(w, code, &apiError{http.StatusText(code)})
+}
+
+func (h *apiHandler) writeCount(w http.ResponseWriter) {
+	ip4, ip6, err := h.server.Count()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &apiCount{ip4, ip6, h.server.IsBatching()})
+}
+
+// parseAddr parses an IP address or a CIDR block. ipnet
+// is nil for an IP address.
+func parseAddr(addr string) (ip net.IP, ipnet *net.IPNet, err error) {
+	if strings.Contains(addr, "/") {
+		return net.ParseCIDR(addr)
+	}
+
+	if ip = net.ParseIP(addr); ip == nil {
+		return nil, nil, &net.ParseError{Type: "IP address", Text: addr}
+	}
+
+	return ip, nil, nil
+}
+
+// list handles PUT and DELETE of an IP address or CIDR
+// block under the path of a list.
+func (h *apiHandler) list(prefix string, insert func(net.IP) error, insertRange func(net.IP, *net.IPNet) error, remove func(net.IP) error, removeRange func(net.IP, *net.IPNet) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var fn func(net.IP) error
+		var fnRange func(net.IP, *net.IPNet) error
+
+		switch r.Method {
+		case http.MethodPut:
+			fn, fnRange = insert, insertRange
+		case http.MethodDelete:
+			fn, fnRange = remove, removeRange
+		default:
+			w.Header().Set("Allow", "PUT, DELETE")
+			writeStatus(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		ip, ipnet, err := parseAddr(strings.TrimPrefix(r.URL.Path, prefix))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		commandMu.Lock()
+		defer commandMu.Unlock()
+
+		if ipnet != nil {
+			err = fnRange(ip, ipnet)
+		} else {
+			err = fn(ip)
+		}
+
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		h.writeCount(w)
+	}
+}
+
+// post handles a POST that calls fn.
+func (h *apiHandler) post(fn func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeStatus(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		commandMu.Lock()
+		defer commandMu.Unlock()
+
+		if err := fn(); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		h.writeCount(w)
+	}
+}
+
+func (h *apiHandler) check(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeStatus(w, http.StatusMethodNotAllowed)
+		return
+	}
+
+	addr := strings.TrimPrefix(r.URL.Path, "/v1/check/")
+
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		writeError(w, &net.ParseError{Type: "IP address", Text: addr})
+		return
+	}
+
+	res, err := h.client.Check(ip)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &apiCheck{ip.String(), res.String()})
+}
+
+func (h *apiHandler) count(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeStatus(w, http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.writeCount(w)
+}
+
+// snapshot handles GET, which saves the blocklist to the
+// response, and PUT, which loads the blocklist from the
+// request body.
+func (h *apiHandler) snapshot(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		/* save to memory first so that errors can still be reported */
+		var b bytes.Buffer
+		if err := h.server.Save(&b); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(b.Len()))
+		b.WriteTo(w)
+	case http.MethodPut:
+		commandMu.Lock()
+		defer commandMu.Unlock()
+
+		if err := h.server.Load(r.Body); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		h.writeCount(w)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		writeStatus(w, http.StatusMethodNotAllowed)
+	}
+}
+
+// ServeHTTP implements http.Handler. Every request must
+// carry the token as a bearer token.
+func (h *apiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	const prefix = "Bearer "
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, prefix) || subtle.ConstantTimeCompare([]byte(auth[len(prefix):]), h.token) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="ip-blocker-agent"`)
+		writeStatus(w, http.StatusUnauthorized)
+		return
+	}
+
+	h.mux.ServeHTTP(w, r)
+}
@@ -0,0 +1,174 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tmthrgd/ip-blocker-agent"
+)
+
+const testToken = "test-token"
+
+func apiRequest(t *testing.T, ts *httptest.Server, method, path string, body io.Reader) (int, []byte) {
+	req, err := http.NewRequest(method, ts.URL+path, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+testToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp.StatusCode, b
+}
+
+func TestAPI(t *testing.T) {
+	name := fmt.Sprintf("/go-test-%d", nameRand.Int())
+
+	server, err := blocker.New(name, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+
+	client, err := blocker.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer client.Close()
+
+	ts := httptest.NewServer(newAPIHandler(server, client, testToken))
+	defer ts.Close()
+
+	for _, test := range [...]struct {
+		method, path string
+		code         int
+		body         string
+	}{
+		{"PUT", "/v1/blocklist/192.0.2.0", 200, `{"ip4":1,"ip6":0,"batching":false}`},
+		{"PUT", "/v1/blocklist/2001:db8::/64", 200, `{"ip4":1,"ip6":1,"batching":false}`},
+		{"PUT", "/v1/allowlist/2001:db8::1", 200, `{"ip4":1,"ip6":1,"batching":false}`},
+		{"GET", "/v1/check/192.0.2.0", 200, `{"ip":"192.0.2.0","result":"blocked"}`},
+		{"GET", "/v1/check/2001:db8::1", 200, `{"ip":"2001:db8::1","result":"allowed"}`},
+		{"GET", "/v1/check/192.0.2.1", 200, `{"ip":"192.0.2.1","result":"not listed"}`},
+		{"GET", "/v1/check/invalid", 400, `{"error":"invalid IP address: invalid"}`},
+		{"PUT", "/v1/blocklist/192.0.2.0/33", 400, `{"error":"invalid CIDR address: 192.0.2.0/33"}`},
+		{"POST", "/v1/batch", 200, `{"ip4":1,"ip6":1,"batching":true}`},
+		{"POST", "/v1/batch", 409, `{"error":"already batching"}`},
+		{"DELETE", "/v1/blocklist/192.0.2.0", 200, `{"ip4":1,"ip6":1,"batching":true}`},
+		{"GET", "/v1/check/192.0.2.0", 200, `{"ip":"192.0.2.0","result":"blocked"}`},
+		{"POST", "/v1/commit", 200, `{"ip4":0,"ip6":1,"batching":false}`},
+		{"POST", "/v1/commit", 409, `{"error":"not batching"}`},
+		{"GET", "/v1/check/192.0.2.0", 200, `{"ip":"192.0.2.0","result":"not listed"}`},
+		{"GET", "/v1/count", 200, `{"ip4":0,"ip6":1,"batching":false}`},
+		{"GET", "/v1/blocklist/192.0.2.0", 405, `{"error":"Method Not Allowed"}`},
+		{"GET", "/v1/clear", 405, `{"error":"Method Not Allowed"}`},
+		{"POST", "/v1/clear", 200, `{"ip4":0,"ip6":0,"batching":false}`},
+		{"PUT", "/v1/snapshot", 400, `{"error":"unexpected EOF"}`},
+	} {
+		code, body := apiRequest(t, ts, test.method, test.path, strings.NewReader("invalid"))
+		if code != test.code || string(bytes.TrimSpace(body)) != test.body {
+			t.Errorf("%s %s returned (%d, %s), expected (%d, %s)", test.method, test.path, code, bytes.TrimSpace(body), test.code, test.body)
+		}
+	}
+}
+
+func TestAPISnapshot(t *testing.T) {
+	name := fmt.Sprintf("/go-test-%d", nameRand.Int())
+
+	server, err := blocker.New(name, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+
+	ts := httptest.NewServer(newAPIHandler(server, nil, testToken))
+	defer ts.Close()
+
+	apiRequest(t, ts, "PUT", "/v1/blocklist/192.0.2.0/24", nil)
+
+	code, snapshot := apiRequest(t, ts, "GET", "/v1/snapshot", nil)
+	if code != 200 {
+		t.Fatalf("GET /v1/snapshot returned %d", code)
+	}
+
+	apiRequest(t, ts, "POST", "/v1/clear", nil)
+
+	code, body := apiRequest(t, ts, "PUT", "/v1/snapshot", bytes.NewReader(snapshot))
+	if code != 200 {
+		t.Fatalf("PUT /v1/snapshot returned %d: %s", code, body)
+	}
+
+	var count apiCount
+	if err = json.Unmarshal(body, &count); err != nil {
+		t.Fatal(err)
+	}
+
+	if count.IP4 != 1 {
+		t.Errorf("PUT /v1/snapshot did not restore the blocklist, got %+v", count)
+	}
+
+	server.SetMaxLoadSize(1)
+
+	if code, _ = apiRequest(t, ts, "PUT", "/v1/snapshot", bytes.NewReader(snapshot)); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("PUT /v1/snapshot returned %d for snapshot over limit", code)
+	}
+}
+
+func TestAPIUnauthorized(t *testing.T) {
+	ts := httptest.NewServer(newAPIHandler(nil, nil, testToken))
+	defer ts.Close()
+
+	for _, auth := range [...]string{"", testToken, "Bearer", "Bearer ", "Bearer wrong-token", "Basic " + testToken} {
+		req, err := http.NewRequest("GET", ts.URL+"/v1/count", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Authorization %q returned %d, expected 401", auth, resp.StatusCode)
+		}
+
+		if resp.Header.Get("WWW-Authenticate") == "" {
+			t.Errorf("Authorization %q did not set WWW-Authenticate", auth)
+		}
+	}
+}
@@ -0,0 +1,344 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/tmthrgd/ip-blocker-agent"
+)
+
+// The error codes of a reply.
+const (
+	codeInvalidInput     = "invalid_input"
+	codeInvalidOperation = "invalid_operation"
+	codeInvalidAddress   = "invalid_address"
+	codeAlreadyBatching  = "already_batching"
+	codeNotBatching      = "not_batching"
+	codeNotFound         = "not_found"
+	codeInvalidData      = "invalid_data"
+	codeClosed           = "closed"
+	codeForbidden        = "forbidden"
+	codeError            = "error"
+)
+
+// reply is the result of a single command.
+type reply struct {
+	Status string `json:"status"`
+	Code   string `json:"code,omitempty"`
+	Error  string `json:"error,omitempty"`
+
+	IP4      int  `json:"ip4"`
+	IP6      int  `json:"ip6"`
+	Batching bool `json:"batching"`
+
+	Output []string `json:"output,omitempty"`
+
+	// showCount is set when the text reply includes the
+	// counts.
+	showCount bool
+	quit      bool
+}
+
+func (r *reply) fail(code, format string, args ...interface{}) {
+	r.Code = code
+	r.Error = fmt.Sprintf(format, args...)
+}
+
+// failErr records err, which was returned by the server
+// or the file system.
+func (r *reply) failErr(err error) {
+	code := codeError
+
+	switch {
+	case err == blocker.ErrAlreadyBatching:
+		code = codeAlreadyBatching
+	case err == blocker.ErrNotBatching:
+		code = codeNotBatching
+	case err == blocker.ErrClosed:
+		code = codeClosed
+	case err == io.EOF, err == io.ErrUnexpectedEOF:
+		/* a truncated blocklist passed to Load */
+		code = codeInvalidData
+	case os.IsNotExist(err):
+		code = codeNotFound
+	default:
+		switch err.(type) {
+		case blocker.InvalidDataError:
+			code = codeInvalidData
+		case *net.AddrError:
+			code = codeInvalidAddress
+		}
+	}
+
+	r.fail(code, "%v", err)
+}
+
+// write writes r to w, either as a single line of JSON or
+// as the free text that the agent has always printed.
+func (r *reply) write(w io.Writer, asJSON bool) error {
+	if asJSON {
+		return json.NewEncoder(w).Encode(r)
+	}
+
+	var b bytes.Buffer
+
+	if r.Error != "" {
+		fmt.Fprintln(&b, r.Error)
+	}
+
+	for _, line := range r.Output {
+		fmt.Fprintln(&b, line)
+	}
+
+	if r.showCount {
+		fmt.Fprintf(&b, "IP4: %d, IP6: %d\n", r.IP4, r.IP6)
+	}
+
+	_, err := b.WriteTo(w)
+	return err
+}
+
+func parseDump(line string) (format blocker.ExportFormat, ok bool) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || len(fields) > 2 || !(fields[0] == "d" || strings.EqualFold(fields[0], "dump")) {
+		return 0, false
+	}
+
+	if len(fields) == 1 {
+		return blocker.ExportText, true
+	}
+
+	format, err := blocker.ParseExportFormat(fields[1])
+	return format, err == nil
+}
+
+// commandMu serialises commands from stdin, every
+// control socket connection, the HTTP API and feed
+// updates so that each runs as a whole.
+var commandMu sync.Mutex
+
+// command runs a single line of the control protocol
+// against server and returns its reply. Errors are
+// reported in the reply and never cause a panic.
+//
+// If remote is true, the line came from the control
+// socket and commands that name a file are refused.
+func command(server *blocker.Server, line string, remote bool) *reply {
+	commandMu.Lock()
+	defer commandMu.Unlock()
+
+	r := new(reply)
+	runCommand(server, line, remote, r)
+	r.finish(server)
+	return r
+}
+
+// finish fills in the counts and status of r.
+func (r *reply) finish(server *blocker.Server) {
+	ip4, ip6, err := server.Count()
+	if err != nil {
+		r.showCount = false
+
+		if r.Code == "" {
+			r.failErr(err)
+		}
+	}
+
+	r.IP4, r.IP6, r.Batching = ip4, ip6, server.IsBatching()
+
+	if r.Code == "" {
+		r.Status = "ok"
+	} else {
+		r.Status = "error"
+	}
+}
+
+func runCommand(server *blocker.Server, line string, remote bool, r *reply) {
+	if len(line) == 0 {
+		r.fail(codeInvalidInput, "invalid input: %s", line)
+		return
+	}
+
+	switch line[0] {
+	case '+', '-', '=', '~':
+		if len(line) <= 1 {
+			r.fail(codeInvalidInput, "invalid input: %s", line)
+			return
+		}
+
+		var err error
+
+		if strings.Contains(line[1:], "/") {
+			ip, ipnet, perr := net.ParseCIDR(line[1:])
+			if perr != nil {
+				r.fail(codeInvalidAddress, "invalid cidr mask: %s (%v)", line[1:], perr)
+				return
+			}
+
+			switch line[0] {
+			case '+':
+				err = server.InsertRange(ip, ipnet)
+			case '-':
+				err = server.RemoveRange(ip, ipnet)
+			case '=':
+				err = server.AllowRange(ip, ipnet)
+			case '~':
+				err = server.DisallowRange(ip, ipnet)
+			}
+		} else {
+			ip := net.ParseIP(line[1:])
+			if ip == nil {
+				r.fail(codeInvalidAddress, "invalid ip address: %s", line[1:])
+				return
+			}
+
+			switch line[0] {
+			case '+':
+				err = server.Insert(ip)
+			case '-':
+				err = server.Remove(ip)
+			case '=':
+				err = server.Allow(ip)
+			case '~':
+				err = server.Disallow(ip)
+			}
+		}
+
+		if err != nil {
+			r.failErr(err)
+			return
+		}
+
+		r.showCount = !server.IsBatching()
+	case '!':
+		if len(line) != 1 {
+			r.fail(codeInvalidInput, "invalid input: %s", line)
+			return
+		}
+
+		if err := server.Clear(); err != nil {
+			r.failErr(err)
+			return
+		}
+
+		r.showCount = !server.IsBatching()
+	case 'b':
+		if len(line) != 1 && !strings.EqualFold(line, "batch") {
+			r.fail(codeInvalidInput, "invalid input: %s", line)
+			return
+		}
+
+		if err := server.Batch(); err != nil {
+			r.failErr(err)
+		}
+	case 'B':
+		if len(line) != 1 && !strings.EqualFold(line, "batch") {
+			r.fail(codeInvalidInput, "invalid input: %s", line)
+			return
+		}
+
+		if err := server.Commit(); err != nil {
+			r.failErr(err)
+			return
+		}
+
+		r.showCount = true
+	case 'q':
+		fallthrough
+	case 'Q':
+		if len(line) == 1 || strings.EqualFold(line, "quit") {
+			r.quit = true
+			return
+		}
+
+		r.fail(codeInvalidInput, "invalid input: %s", line)
+	case 's':
+		fallthrough
+	case 'S':
+		if len(line) <= 1 {
+			r.fail(codeInvalidInput, "invalid input: %s", line)
+			return
+		}
+
+		if remote {
+			/* the agent's user may be able to write files that the peer cannot */
+			r.fail(codeForbidden, "forbidden on the control socket: %c", line[0])
+			return
+		}
+
+		f, err := os.Create(os.ExpandEnv(line[1:]))
+		if err != nil {
+			r.failErr(err)
+			return
+		}
+
+		err = server.Save(f)
+
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+
+		if err != nil {
+			r.failErr(err)
+		}
+	case 'l':
+		fallthrough
+	case 'L':
+		if len(line) <= 1 {
+			r.fail(codeInvalidInput, "invalid input: %s", line)
+			return
+		}
+
+		if remote {
+			/* the agent's user may be able to write files that the peer cannot */
+			r.fail(codeForbidden, "forbidden on the control socket: %c", line[0])
+			return
+		}
+
+		f, err := os.Open(os.ExpandEnv(line[1:]))
+		if err != nil {
+			r.failErr(err)
+			return
+		}
+
+		err = server.Load(f)
+		f.Close()
+
+		if err != nil {
+			r.failErr(err)
+			return
+		}
+
+		r.showCount = !server.IsBatching()
+	case 'd':
+		format, ok := parseDump(line)
+		if !ok {
+			r.fail(codeInvalidInput, "invalid input: %s", line)
+			return
+		}
+
+		var b bytes.Buffer
+
+		if err := server.Export(&b, format); err != nil {
+			r.failErr(err)
+			return
+		}
+
+		if b.Len() != 0 {
+			r.Output = strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+		}
+	default:
+		r.fail(codeInvalidOperation, "invalid operation: %c", line[0])
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/tmthrgd/ip-blocker-agent"
+	"github.com/tmthrgd/ip-blocker-agent/feed"
+	"github.com/tmthrgd/ip-blocker-agent/importer"
+)
+
+var errFeedBatching = errors.New("a batch is in progress, feed changes will be applied on the next update")
+
+// applyFeed returns a feed.Subscriber ApplyHook that
+// serialises feed updates with commands. An update is
+// deferred while a batch started by a command is in
+// progress, so that it is neither committed by that batch
+// nor left half applied by it.
+func applyFeed(server *blocker.Server) func(apply func() error) error {
+	return func(apply func() error) error {
+		commandMu.Lock()
+		defer commandMu.Unlock()
+
+		if server.IsBatching() {
+			return errFeedBatching
+		}
+
+		return apply()
+	}
+}
+
+type feedsConfig struct {
+	Sources []struct {
+		Name     string `json:"name"`
+		URL      string `json:"url"`
+		Format   string `json:"format"`
+		Interval string `json:"interval"`
+		List     string `json:"list"`
+		Tag      uint32 `json:"tag"`
+	} `json:"sources"`
+}
+
+func loadFeeds(path string) ([]feed.Source, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	var config feedsConfig
+	if err = json.NewDecoder(f).Decode(&config); err != nil {
+		return nil, err
+	}
+
+	sources := make([]feed.Source, 0, len(config.Sources))
+
+	for _, src := range config.Sources {
+		format := importer.Plain
+		if src.Format != "" {
+			if format, err = importer.ParseFormat(src.Format); err != nil {
+				return nil, err
+			}
+		}
+
+		var interval time.Duration
+		if src.Interval != "" {
+			if interval, err = time.ParseDuration(src.Interval); err != nil {
+				return nil, err
+			}
+		}
+
+		sources = append(sources, feed.Source{
+			Name:     src.Name,
+			URL:      src.URL,
+			Format:   format,
+			Interval: interval,
+			List:     src.List,
+			Tag:      blocker.Tag(src.Tag),
+		})
+	}
+
+	return sources, nil
+}
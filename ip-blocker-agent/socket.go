@@ -0,0 +1,153 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/tmthrgd/ip-blocker-agent"
+	"golang.org/x/sys/unix"
+)
+
+var errSocketInUse = errors.New("control socket is in use by another process")
+
+// controlServer accepts connections on a Unix-domain
+// socket and runs each line received as a command.
+type controlServer struct {
+	server *blocker.Server
+	ln     *net.UnixListener
+
+	asJSON bool
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+
+	done chan struct{}
+}
+
+// listenControl creates a Unix-domain socket at path
+// with the given permissions, replacing a stale socket
+// left behind by an agent that did not exit cleanly.
+//
+// The socket is created accessible only to the owner and
+// is then changed to perms, so that no other user can
+// connect to it before perms is applied.
+func listenControl(server *blocker.Server, path string, perms os.FileMode, asJSON bool) (*controlServer, error) {
+	if stat, err := os.Lstat(path); err == nil {
+		if stat.Mode()&os.ModeSocket == 0 {
+			return nil, &os.PathError{Op: "listen", Path: path, Err: errors.New("file exists and is not a socket")}
+		}
+
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, errSocketInUse
+		}
+
+		if err = os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	/* the umask is process wide, but nothing else creates files while the agent starts */
+	mask := unix.Umask(0177)
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	unix.Umask(mask)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = os.Chmod(path, perms); err != nil {
+		ln.Close()
+		return nil, err
+	}
+
+	return &controlServer{
+		server: server,
+		ln:     ln,
+
+		asJSON: asJSON,
+
+		conns: make(map[net.Conn]struct{}),
+
+		done: make(chan struct{}),
+	}, nil
+}
+
+// serve accepts connections until Close() is called.
+func (c *controlServer) serve() {
+	defer close(c.done)
+
+	for {
+		conn, err := c.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		c.mu.Lock()
+		c.conns[conn] = struct{}{}
+		c.wg.Add(1)
+		c.mu.Unlock()
+
+		go c.handle(conn)
+	}
+}
+
+// handle runs each line received on conn as a command.
+// Every line gets a reply, in text mode commands that
+// would otherwise print nothing reply with ok.
+func (c *controlServer) handle(conn net.Conn) {
+	defer func() {
+		c.mu.Lock()
+		delete(c.conns, conn)
+		c.mu.Unlock()
+
+		conn.Close()
+		c.wg.Done()
+	}()
+
+	r := bufio.NewScanner(conn)
+
+	var reply bytes.Buffer
+
+	for r.Scan() {
+		reply.Reset()
+
+		res := command(c.server, r.Text(), true)
+		res.write(&reply, c.asJSON)
+
+		if reply.Len() == 0 {
+			reply.WriteString("ok\n")
+		}
+
+		if _, err := conn.Write(reply.Bytes()); err != nil || res.quit {
+			return
+		}
+	}
+}
+
+// Close stops accepting connections, closes any open
+// connections and waits for their commands to finish.
+// The socket file is removed.
+func (c *controlServer) Close() error {
+	err := c.ln.Close()
+	<-c.done
+
+	c.mu.Lock()
+	for conn := range c.conns {
+		conn.Close()
+	}
+	c.mu.Unlock()
+
+	c.wg.Wait()
+	return err
+}
@@ -0,0 +1,258 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tmthrgd/ip-blocker-agent"
+)
+
+// The results of checking a line.
+const (
+	resultBlocked     = "blocked"
+	resultNotBlocked  = "not-blocked"
+	resultInvalidAddr = "invalid"
+)
+
+var errNoAddress = errors.New("no address found")
+
+// checker checks the address on each line of its input
+// against the blocklist and keeps count of the results.
+type checker struct {
+	client *blocker.Client
+
+	// extract returns the address on a line.
+	extract func(line string) (string, error)
+
+	// all requires every address in a CIDR block to be
+	// blocked for it to count as blocked.
+	all      bool
+	annotate bool
+
+	w *bufio.Writer
+
+	lines, checked, blocked, invalid int
+	unique                           map[string]struct{}
+}
+
+// extractLog returns the remote host of a line in the
+// common or combined log format.
+func extractLog(line string) (string, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", errNoAddress
+	}
+
+	return fields[0], nil
+}
+
+// extractCSV returns a function that returns the given
+// one-based column of a CSV line.
+func extractCSV(column int, comma rune) func(line string) (string, error) {
+	return func(line string) (string, error) {
+		r := csv.NewReader(strings.NewReader(line))
+		r.Comma = comma
+		r.FieldsPerRecord = -1
+		r.LazyQuotes = true
+
+		record, err := r.Read()
+		if err == io.EOF || (err == nil && len(record) < column) {
+			return "", errNoAddress
+		} else if err != nil {
+			return "", err
+		}
+
+		return strings.TrimSpace(record[column-1]), nil
+	}
+}
+
+func extractAddr(line string) (string, error) {
+	return strings.TrimSpace(line), nil
+}
+
+// check reports whether addr, an IP address or a CIDR
+// block, is blocked.
+func (c *checker) check(addr string) (has bool, err error) {
+	if !strings.Contains(addr, "/") {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return false, &net.ParseError{Type: "IP address", Text: addr}
+		}
+
+		return c.client.Contains(ip)
+	}
+
+	_, ipnet, err := net.ParseCIDR(addr)
+	if err != nil {
+		return false, err
+	}
+
+	if c.all {
+		return c.client.ContainsAll(ipnet)
+	}
+
+	return c.client.ContainsAny(ipnet)
+}
+
+// run checks every line read from r. Lines that are
+// blocked are written out, or every line is written out
+// with its result when annotating.
+func (c *checker) run(r io.Reader) error {
+	s := bufio.NewScanner(r)
+
+	for s.Scan() {
+		line := s.Text()
+		c.lines++
+
+		result := resultInvalidAddr
+
+		addr, err := c.extract(line)
+		if err == nil {
+			var has bool
+			has, err = c.check(addr)
+
+			switch err.(type) {
+			case nil:
+				c.checked++
+
+				result = resultNotBlocked
+				if has {
+					result = resultBlocked
+
+					c.blocked++
+					c.unique[addr] = struct{}{}
+				}
+			case *net.ParseError:
+			default:
+				return err
+			}
+		}
+
+		if result == resultInvalidAddr {
+			c.invalid++
+		}
+
+		switch {
+		case c.annotate:
+			fmt.Fprintf(c.w, "%s\t%s\n", result, line)
+		case result == resultBlocked:
+			fmt.Fprintln(c.w, line)
+		}
+	}
+
+	return s.Err()
+}
+
+func (c *checker) printStats(w io.Writer) {
+	fmt.Fprintf(w, "lines: %d, checked: %d, blocked: %d (%d unique), invalid: %d\n",
+		c.lines, c.checked, c.blocked, len(c.unique), c.invalid)
+}
+
+func checkFiles(client *blocker.Client, args []string) {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+
+	var input string
+	flags.StringVar(&input, "input", "addr", "the input format: addr, log or csv")
+
+	var column int
+	flags.IntVar(&column, "column", 1, "the one-based column of the address with -input=csv")
+
+	var comma string
+	flags.StringVar(&comma, "comma", ",", "the field separator with -input=csv")
+
+	var all bool
+	flags.BoolVar(&all, "all", false, "only report a CIDR block if every address in it is blocked")
+
+	var annotate bool
+	flags.BoolVar(&annotate, "annotate", false, "print every line prefixed with its result")
+
+	var stats bool
+	flags.BoolVar(&stats, "stats", false, "print summary statistics to stderr")
+
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "%s [-name <path>] check [-input addr|log|csv] [-column <n>] [-comma <c>] [-all] [-annotate] [-stats] [file ...]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+
+	flags.Parse(args)
+
+	c := &checker{
+		client: client,
+
+		all:      all,
+		annotate: annotate,
+
+		w: bufio.NewWriter(os.Stdout),
+
+		unique: make(map[string]struct{}),
+	}
+
+	switch input {
+	case "addr":
+		c.extract = extractAddr
+	case "log":
+		c.extract = extractLog
+	case "csv":
+		r, size := utf8.DecodeRuneInString(comma)
+		if column < 1 || r == utf8.RuneError || size != len(comma) {
+			flags.Usage()
+			os.Exit(2)
+		}
+
+		c.extract = extractCSV(column, r)
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	files := flags.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	for _, name := range files {
+		var err error
+
+		if name == "-" {
+			err = c.run(os.Stdin)
+		} else {
+			var f *os.File
+			if f, err = os.Open(name); err == nil {
+				err = c.run(f)
+				f.Close()
+			}
+		}
+
+		if err != nil {
+			c.w.Flush()
+
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+
+	if err := c.w.Flush(); err != nil {
+		panic(err)
+	}
+
+	if stats {
+		c.printStats(os.Stderr)
+	}
+
+	if c.blocked == 0 {
+		os.Exit(1)
+	}
+}
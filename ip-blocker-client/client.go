@@ -18,12 +18,26 @@ import (
 )
 
 func printClient(client *blocker.Client) {
-	ip4, ip6, ip6r, err := client.Count()
+	ip4, ip6, err := client.Count()
 	if err != nil {
 		panic(err)
 	}
 
-	fmt.Printf("IP4: %d, IP6: %d, IP6 routes: %d\n", ip4, ip6, ip6r)
+	fmt.Printf("IP4: %d, IP6: %d\n", ip4, ip6)
+}
+
+func parseDump(line string) (format blocker.ExportFormat, ok bool) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || len(fields) > 2 || !(fields[0] == "d" || strings.EqualFold(fields[0], "dump")) {
+		return 0, false
+	}
+
+	if len(fields) == 1 {
+		return blocker.ExportText, true
+	}
+
+	format, err := blocker.ParseExportFormat(fields[1])
+	return format, err == nil
 }
 
 func main() {
@@ -31,7 +45,7 @@ func main() {
 	flag.StringVar(&name, "name", "/ngx-ip-blocker", "the shared memory name")
 
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "%s [-name <path>] [ip-address]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "%s [-name <path>] [ip-address|dump [text|ipset|nft]|check ...]\n", os.Args[0])
 		flag.PrintDefaults()
 	}
 
@@ -44,9 +58,14 @@ func main() {
 
 	var query net.IP
 
-	switch flag.NArg() {
-	case 0:
-	case 1:
+	dumpFormat, dump := parseDump(strings.Join(flag.Args(), " "))
+
+	check := flag.Arg(0) == "check"
+
+	switch {
+	case dump, check:
+	case flag.NArg() == 0:
+	case flag.NArg() == 1:
 		query = net.ParseIP(flag.Arg(0))
 		if query == nil {
 			flag.Usage()
@@ -82,6 +101,19 @@ func main() {
 		}
 	}
 
+	if check {
+		checkFiles(client, flag.Args()[1:])
+		return
+	}
+
+	if dump {
+		if err = client.Export(os.Stdout, dumpFormat); err != nil {
+			panic(err)
+		}
+
+		return
+	}
+
 	printClient(client)
 
 	stdin := bufio.NewScanner(os.Stdin)
@@ -105,6 +137,14 @@ func main() {
 		case '?':
 			printClient(client)
 		default:
+			if format, ok := parseDump(line); ok {
+				if err = client.Export(os.Stdout, format); err != nil {
+					panic(err)
+				}
+
+				continue
+			}
+
 			ip := net.ParseIP(line)
 			if ip == nil {
 				fmt.Printf("invalid ip address: %s\n", line)
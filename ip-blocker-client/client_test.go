@@ -128,6 +128,7 @@ func TestTUI(t *testing.T) {
 2001:db8::
 2001:db8::1
 ?
+dump nft
 q`)
 	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
 	cmd.Stdout = stdout
@@ -138,14 +139,19 @@ q`)
 		t.Errorf("stderr was not empty, got: %s", stderr.Bytes())
 	}
 
-	expect := `IP4: 1, IP6: 1, IP6 routes: 0
+	expect := `IP4: 1, IP6: 1
 true
 false
 false
 true
 true
 false
-IP4: 1, IP6: 1, IP6 routes: 0
+IP4: 1, IP6: 1
+add table inet ip-blocker
+add set inet ip-blocker blocklist4 { type ipv4_addr; flags interval; }
+add set inet ip-blocker blocklist6 { type ipv6_addr; flags interval; }
+add element inet ip-blocker blocklist4 { 192.0.2.0/32 }
+add element inet ip-blocker blocklist6 { 2001:db8::/128 }
 `
 	if stdout.String() != expect {
 		t.Error("stdout was invalid")
@@ -153,3 +159,141 @@ IP4: 1, IP6: 1, IP6 routes: 0
 		t.Errorf("got:\t%q", stdout.String())
 	}
 }
+
+func TestDump(t *testing.T) {
+	server, err := setup()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ip, ipnet, err := net.ParseCIDR("192.0.2.0/24")
+	if err != nil {
+		panic(err)
+	}
+
+	if err := server.InsertRange(ip, ipnet); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.Insert(net.ParseIP("2001:db8::")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [...][]string{{"dump"}, {"dump", "text"}} {
+		cmd := exec.Command(clientExe, append([]string{"-name", server.Name()}, args...)...)
+
+		stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+
+		if err = cmd.Run(); err != nil {
+			t.Error(err)
+		}
+
+		if stderr.Len() != 0 {
+			t.Errorf("stderr was not empty, got: %s", stderr.Bytes())
+		}
+
+		expect := `192.0.2.0/24
+2001:db8::/128
+`
+		if stdout.String() != expect {
+			t.Error("stdout was invalid")
+			t.Errorf("expected:\t%q", expect)
+			t.Errorf("got:\t%q", stdout.String())
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	server, err := setup()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ip, ipnet, err := net.ParseCIDR("192.0.2.0/24")
+	if err != nil {
+		panic(err)
+	}
+
+	if err := server.InsertRange(ip, ipnet); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.Insert(net.ParseIP("2001:db8::")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range [...]struct {
+		args          []string
+		input, expect string
+	}{
+		{
+			[]string{"-stats"},
+			"192.0.2.1\n198.51.100.1\n 192.0.2.1 \n2001:db8::\nexample.com\n192.0.2.0/23\n",
+			"192.0.2.1\n 192.0.2.1 \n2001:db8::\n192.0.2.0/23\n",
+		},
+		{
+			[]string{"-all", "-annotate"},
+			"192.0.2.128/25\n192.0.2.0/23\nexample.com\n2001:db8::/128\n2001:db8::/127\n",
+			"blocked\t192.0.2.128/25\nnot-blocked\t192.0.2.0/23\ninvalid\texample.com\nblocked\t2001:db8::/128\nnot-blocked\t2001:db8::/127\n",
+		},
+		{
+			[]string{"-input", "log"},
+			`192.0.2.7 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.0" 200 2326
+198.51.100.7 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.0" 200 2326
+`,
+			`192.0.2.7 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.0" 200 2326
+`,
+		},
+		{
+			[]string{"-input", "csv", "-column", "2", "-comma", ";"},
+			"time;addr\n1;\"192.0.2.9\"\n2;198.51.100.9\n3\n",
+			"1;\"192.0.2.9\"\n",
+		},
+	} {
+		cmd := exec.Command(clientExe, append([]string{"-name", server.Name(), "check"}, test.args...)...)
+		cmd.Stdin = strings.NewReader(test.input)
+
+		stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+
+		if err = cmd.Run(); err != nil {
+			t.Error(err)
+		}
+
+		if stdout.String() != test.expect {
+			t.Errorf("check %v: stdout was invalid", test.args)
+			t.Errorf("expected:\t%q", test.expect)
+			t.Errorf("got:\t%q", stdout.String())
+		}
+
+		expect := ""
+		if test.args[0] == "-stats" {
+			expect = "lines: 6, checked: 5, blocked: 4 (3 unique), invalid: 1\n"
+		}
+
+		if stderr.String() != expect {
+			t.Errorf("check %v: stderr was invalid, expected %q, got %q", test.args, expect, stderr.String())
+		}
+	}
+
+	cmd := exec.Command(clientExe, "-name", server.Name(), "check")
+	cmd.Stdin = strings.NewReader("198.51.100.1\n")
+
+	err = cmd.Run()
+	if exit, ok := err.(*exec.ExitError); ok {
+		if status, ok := exit.Sys().(syscall.WaitStatus); ok {
+			if status.ExitStatus() != 1 {
+				t.Errorf("%s did not exit with code 1, got %d", clientExe, status.ExitStatus())
+			}
+		} else {
+			t.Log("cannot get exit code")
+		}
+	} else if err != nil {
+		t.Error(err)
+	} else {
+		t.Errorf("%s did not exit with code 1 without any blocked addresses", clientExe)
+	}
+}
@@ -0,0 +1,277 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+// Package listenerblocker implements IP address
+// blocking for net.Listener.
+package listenerblocker
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/tmthrgd/ip-blocker-agent"
+)
+
+// DefaultMaxPending is the number of connections that
+// may be pending at once if Listener.MaxPending is zero.
+const DefaultMaxPending = 64
+
+// Listener is a net.Listener that closes connections
+// from clients with IP addresses that are/are-not in
+// the block list as soon as they are accepted.
+//
+// Connections with a remote address that is not an IP
+// address, such as those on a Unix-domain socket, are
+// never blocked.
+//
+// Each connection is checked in its own goroutine, so a
+// client that is slow to reveal its address does not hold
+// up any other. To check the address of clients behind a
+// load balancer that sends the PROXY protocol, wrap a
+// proxyproto.Listener; the header is then read while the
+// connection is checked.
+//
+// At most MaxPending connections are checked, or wait to
+// be returned by Accept, at any one time. While that many
+// are pending, no more are accepted from the wrapped
+// listener.
+type Listener struct {
+	rejected uint64 // accessed atomically, first for alignment
+
+	net.Listener
+
+	// The ip-blocker-agent client to check
+	// IP addresses against.
+	Client *blocker.Client
+
+	// If true, only clients in the block
+	// list are accepted.
+	//
+	// Clients in the allowlist are always
+	// accepted.
+	Whitelist bool
+
+	// If true, blocked connections are reset
+	// rather than closed gracefully, where the
+	// connection supports SetLinger.
+	Reset bool
+
+	// If non-nil, OnReject is called with the
+	// remote address of each connection that is
+	// rejected. err is nil if the client was
+	// blocked, otherwise it is the error that
+	// prevented the client from being checked.
+	// It may be called concurrently.
+	OnReject func(addr net.Addr, err error)
+
+	// MaxPending is the maximum number of
+	// connections that may be checked, or wait
+	// to be returned by Accept, at once. If
+	// zero, DefaultMaxPending is used.
+	MaxPending int
+
+	initOnce  sync.Once
+	serveOnce sync.Once
+	closeOnce sync.Once
+
+	conns   chan net.Conn
+	pending chan struct{} // one entry per pending connection
+	errs    chan error    // temporary errors from Accept
+	done    chan struct{} // closed by Close
+	stopped chan struct{} // closed once serve returns
+	err     error         // the error serve returned with
+}
+
+// Block wraps a given net.Listener and closes all
+// connections from clients that are contained in the
+// blocklist.
+func Block(c *blocker.Client, l net.Listener) *Listener {
+	return &Listener{
+		Listener: l,
+
+		Client: c,
+	}
+}
+
+// Whitelist wraps a given net.Listener and closes all
+// connections from clients that are not contained in
+// the list.
+func Whitelist(c *blocker.Client, l net.Listener) *Listener {
+	return &Listener{
+		Listener: l,
+
+		Client: c,
+
+		Whitelist: true,
+	}
+}
+
+func remoteIP(addr net.Addr) net.IP {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return addr.IP
+	case *net.UDPAddr:
+		return addr.IP
+	case *net.IPAddr:
+		return addr.IP
+	case nil:
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+
+	return net.ParseIP(host)
+}
+
+// reject closes conn, resetting it if l.Reset is set.
+func (l *Listener) reject(conn net.Conn, err error) {
+	atomic.AddUint64(&l.rejected, 1)
+
+	if l.OnReject != nil {
+		l.OnReject(conn.RemoteAddr(), err)
+	}
+
+	if l.Reset {
+		if lc, ok := conn.(interface {
+			SetLinger(sec int) error
+		}); ok {
+			lc.SetLinger(0)
+		}
+	}
+
+	conn.Close()
+}
+
+func (l *Listener) init() {
+	max := l.MaxPending
+	if max <= 0 {
+		max = DefaultMaxPending
+	}
+
+	l.conns = make(chan net.Conn)
+	l.pending = make(chan struct{}, max)
+	l.errs = make(chan error)
+	l.done = make(chan struct{})
+	l.stopped = make(chan struct{})
+}
+
+// serve accepts connections from the wrapped listener and
+// checks each one in its own goroutine until the wrapped
+// listener fails. It waits for a pending connection to be
+// returned or rejected before accepting more than
+// MaxPending.
+func (l *Listener) serve() {
+	for {
+		acquired := false
+
+		select {
+		case l.pending <- struct{}{}:
+			acquired = true
+		case <-l.done:
+			/* Close was called, wait for the wrapped listener to fail */
+		}
+
+		conn, err := l.Listener.Accept()
+		if err == nil {
+			if acquired {
+				go l.check(conn)
+			} else {
+				conn.Close()
+			}
+
+			continue
+		}
+
+		if acquired {
+			<-l.pending
+		}
+
+		if nerr, ok := err.(net.Error); ok && nerr.Temporary() {
+			select {
+			case l.errs <- err:
+			case <-l.done:
+			}
+
+			continue
+		}
+
+		l.err = err
+		close(l.stopped)
+		return
+	}
+}
+
+// check passes conn to Accept if the client is not
+// blocked and rejects it otherwise.
+func (l *Listener) check(conn net.Conn) {
+	defer func() { <-l.pending }()
+
+	/* this may block while a PROXY protocol header is read */
+	if ip := remoteIP(conn.RemoteAddr()); ip != nil {
+		res, err := l.Client.Check(ip)
+		if err != nil {
+			l.reject(conn, err)
+			return
+		}
+
+		/* the allowlist takes precedence in either mode */
+		if res != blocker.Allowed && (res == blocker.Blocked) != l.Whitelist {
+			l.reject(conn, nil)
+			return
+		}
+	}
+
+	select {
+	case l.conns <- conn:
+	case <-l.stopped:
+		conn.Close()
+	}
+}
+
+// Accept implements net.Listener. It waits for and
+// returns the next connection from a client that is not
+// blocked, closing any blocked connections that are
+// accepted in the meantime.
+//
+// If a client cannot be checked against the blocklist,
+// its connection is closed.
+func (l *Listener) Accept() (net.Conn, error) {
+	l.initOnce.Do(l.init)
+	l.serveOnce.Do(func() {
+		go l.serve()
+	})
+
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.stopped:
+		return nil, l.err
+	}
+}
+
+// Close implements net.Listener. Any connections that
+// have been accepted but not yet returned by Accept are
+// closed.
+func (l *Listener) Close() error {
+	l.initOnce.Do(l.init)
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+
+	return l.Listener.Close()
+}
+
+// Rejected returns the number of connections that have
+// been closed because they were blocked or could not be
+// checked.
+func (l *Listener) Rejected() uint64 {
+	return atomic.LoadUint64(&l.rejected)
+}
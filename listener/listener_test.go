@@ -0,0 +1,536 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+package listenerblocker
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tmthrgd/ip-blocker-agent"
+	"github.com/tmthrgd/ip-blocker-agent/proxyproto"
+)
+
+var nameRand *rand.Rand
+
+func init() {
+	var seed [8]byte
+
+	if _, err := crand.Read(seed[:]); err != nil {
+		panic(err)
+	}
+
+	seedInt := int64(binary.LittleEndian.Uint64(seed[:]))
+	nameRand = rand.New(rand.NewSource(seedInt))
+}
+
+func setup(blocked ...string) (*blocker.Server, *blocker.Client, error) {
+	name := fmt.Sprintf("/go-test-%d", nameRand.Int())
+
+	server, err := blocker.New(name, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, addr := range blocked {
+		if err = server.Insert(net.ParseIP(addr)); err != nil {
+			server.Close()
+			server.Unlink()
+
+			return nil, nil, err
+		}
+	}
+
+	client, err := blocker.Open(name)
+	if err != nil {
+		server.Close()
+		server.Unlink()
+
+		return nil, nil, err
+	}
+
+	return server, client, nil
+}
+
+// dialFrom connects to addr from the given loopback
+// address.
+func dialFrom(t *testing.T, local string, addr net.Addr) net.Conn {
+	d := &net.Dialer{
+		LocalAddr: &net.TCPAddr{IP: net.ParseIP(local)},
+		Timeout:   time.Second,
+	}
+
+	conn, err := d.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return conn
+}
+
+func testListener(t *testing.T, whitelist, reset bool) {
+	server, client, err := setup("127.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var l *Listener
+	if whitelist {
+		l = Whitelist(client, ln)
+	} else {
+		l = Block(client, ln)
+	}
+
+	defer l.Close()
+
+	l.Reset = reset
+
+	var mu sync.Mutex
+	var rejected []string
+
+	l.OnReject = func(addr net.Addr, err error) {
+		if err != nil {
+			t.Error(err)
+		}
+
+		mu.Lock()
+		rejected = append(rejected, remoteIP(addr).String())
+		mu.Unlock()
+	}
+
+	blockedAddr, allowedAddr := "127.0.0.2", "127.0.0.3"
+	if whitelist {
+		blockedAddr, allowedAddr = allowedAddr, blockedAddr
+	}
+
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+		}
+
+		accepted <- conn
+	}()
+
+	d := &net.Dialer{
+		LocalAddr: &net.TCPAddr{IP: net.ParseIP(blockedAddr)},
+		Timeout:   time.Second,
+	}
+
+	/* the reset can arrive before Dial returns */
+	blocked, err := d.Dial("tcp", l.Addr().String())
+	if err == nil {
+		defer blocked.Close()
+
+		blocked.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = blocked.Read(make([]byte, 1))
+	}
+
+	if err == nil {
+		t.Error("blocked connection was not closed")
+	} else if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		t.Error("blocked connection was not closed")
+	} else if reset && err == io.EOF {
+		t.Error("blocked connection was not reset")
+	} else if !reset && err != io.EOF {
+		t.Errorf("blocked connection was not closed gracefully, got %v", err)
+	}
+
+	conn := dialFrom(t, allowedAddr, l.Addr())
+	defer conn.Close()
+
+	select {
+	case c := <-accepted:
+		if c == nil {
+			break
+		}
+
+		defer c.Close()
+
+		if ip := remoteIP(c.RemoteAddr()).String(); ip != allowedAddr {
+			t.Errorf("Accept returned connection from %s, expected %s", ip, allowedAddr)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Accept did not return allowed connection")
+	}
+
+	if n := l.Rejected(); n != 1 {
+		t.Errorf("Rejected returned %d, expected 1", n)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(rejected) != 1 || rejected[0] != blockedAddr {
+		t.Errorf("OnReject was called for %v, expected [%s]", rejected, blockedAddr)
+	}
+}
+
+func TestBlock(t *testing.T) {
+	testListener(t, false, false)
+}
+
+func TestBlockReset(t *testing.T) {
+	testListener(t, false, true)
+}
+
+func TestWhitelist(t *testing.T) {
+	testListener(t, true, false)
+}
+
+func TestAllowlist(t *testing.T) {
+	server, client, err := setup()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	_, blocked, err := net.ParseCIDR("127.0.0.0/29")
+	if err != nil {
+		panic(err)
+	}
+
+	if err = server.InsertRange(blocked.IP, blocked); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = server.Allow(net.ParseIP("127.0.0.3")); err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := Block(client, ln)
+	defer l.Close()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+		}
+
+		accepted <- conn
+	}()
+
+	blockedConn := dialFrom(t, "127.0.0.2", l.Addr())
+	defer blockedConn.Close()
+
+	conn := dialFrom(t, "127.0.0.3", l.Addr())
+	defer conn.Close()
+
+	select {
+	case c := <-accepted:
+		if c == nil {
+			break
+		}
+
+		defer c.Close()
+
+		if ip := remoteIP(c.RemoteAddr()).String(); ip != "127.0.0.3" {
+			t.Errorf("Accept returned connection from %s, expected 127.0.0.3", ip)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Accept did not return connection from allowlisted address")
+	}
+}
+
+func TestAcceptClosed(t *testing.T) {
+	server, client, err := setup()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := Block(client, ln)
+	defer l.Close()
+
+	var mu sync.Mutex
+	var rejectErr error
+
+	l.OnReject = func(addr net.Addr, err error) {
+		mu.Lock()
+		rejectErr = err
+		mu.Unlock()
+	}
+
+	client.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		/* the only connection is rejected, so Accept fails once the listener is closed */
+		if conn, err := l.Accept(); err == nil {
+			conn.Close()
+			t.Error("Accept returned a connection that could not be checked")
+		}
+	}()
+
+	conn := dialFrom(t, "127.0.0.1", l.Addr())
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	conn.Read(make([]byte, 1))
+
+	l.Close()
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if rejectErr != blocker.ErrClosed {
+		t.Errorf("OnReject was called with %v, expected %v", rejectErr, blocker.ErrClosed)
+	}
+}
+
+func TestRemoteIP(t *testing.T) {
+	for _, test := range [...]struct {
+		addr   net.Addr
+		expect string
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 80}, "192.0.2.1"},
+		{&net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 53}, "2001:db8::1"},
+		{&net.IPAddr{IP: net.ParseIP("192.0.2.2")}, "192.0.2.2"},
+		{&net.UnixAddr{Name: "/tmp/sock", Net: "unix"}, "<nil>"},
+		{nil, "<nil>"},
+	} {
+		if got := remoteIP(test.addr).String(); got != test.expect {
+			t.Errorf("remoteIP(%v) returned %s, expected %s", test.addr, got, test.expect)
+		}
+	}
+}
+
+func TestProxyProtocol(t *testing.T) {
+	server, client, err := setup("192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, trusted, err := net.ParseCIDR("127.0.0.0/8")
+	if err != nil {
+		panic(err)
+	}
+
+	l := Block(client, &proxyproto.Listener{
+		Listener: ln,
+		Trusted:  []*net.IPNet{trusted},
+	})
+	defer l.Close()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+		}
+
+		accepted <- conn
+	}()
+
+	for _, src := range [...]string{"192.0.2.1", "192.0.2.2"} {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer conn.Close()
+
+		if _, err = fmt.Fprintf(conn, "PROXY TCP4 %s 127.0.0.1 12345 80\r\n", src); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case c := <-accepted:
+		if c == nil {
+			break
+		}
+
+		defer c.Close()
+
+		if ip := remoteIP(c.RemoteAddr()).String(); ip != "192.0.2.2" {
+			t.Errorf("Accept returned connection from %s, expected 192.0.2.2", ip)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Accept did not return allowed connection")
+	}
+
+	/* connections are checked concurrently, so the blocked one may still be pending */
+	for deadline := time.Now().Add(time.Second); l.Rejected() == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if n := l.Rejected(); n != 1 {
+		t.Errorf("Rejected returned %d, expected 1", n)
+	}
+}
+
+func TestProxyProtocolSilentPeer(t *testing.T) {
+	server, client, err := setup()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, trusted, err := net.ParseCIDR("127.0.0.0/8")
+	if err != nil {
+		panic(err)
+	}
+
+	l := Block(client, &proxyproto.Listener{
+		Listener: ln,
+		Trusted:  []*net.IPNet{trusted},
+	})
+	defer l.Close()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+		}
+
+		accepted <- conn
+	}()
+
+	/* never sends a header, so is held for proxyproto.DefaultTimeout */
+	silent := dialFrom(t, "127.0.0.2", l.Addr())
+	defer silent.Close()
+
+	conn := dialFrom(t, "127.0.0.3", l.Addr())
+	defer conn.Close()
+
+	if _, err = fmt.Fprint(conn, "PROXY TCP4 192.0.2.2 127.0.0.1 12345 80\r\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case c := <-accepted:
+		if c == nil {
+			break
+		}
+
+		defer c.Close()
+
+		if ip := remoteIP(c.RemoteAddr()).String(); ip != "192.0.2.2" {
+			t.Errorf("Accept returned connection from %s, expected 192.0.2.2", ip)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Accept was held up by a peer that did not send a header")
+	}
+}
+
+type countingListener struct {
+	net.Listener
+	accepted int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		atomic.AddInt32(&l.accepted, 1)
+	}
+
+	return conn, err
+}
+
+func TestMaxPending(t *testing.T) {
+	server, client, err := setup()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, trusted, err := net.ParseCIDR("127.0.0.0/8")
+	if err != nil {
+		panic(err)
+	}
+
+	cl := &countingListener{Listener: ln}
+
+	const maxPending = 2
+
+	l := Block(client, &proxyproto.Listener{
+		Listener: cl,
+		Trusted:  []*net.IPNet{trusted},
+	})
+	l.MaxPending = maxPending
+	defer l.Close()
+
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+
+	/* none send a header, so each is held for proxyproto.DefaultTimeout */
+	for i := 0; i < 2*maxPending; i++ {
+		conn := dialFrom(t, "127.0.0.2", l.Addr())
+		defer conn.Close()
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if n := atomic.LoadInt32(&cl.accepted); n != maxPending {
+		t.Errorf("wrapped listener accepted %d connections, expected %d", n, maxPending)
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+package blocker
+
+import (
+	"net"
+	"strings"
+	"time"
+)
+
+// listNameSize is the maximum length of the name of a
+// named list.
+const listNameSize = len(listEntry{}.Name)
+
+// ValidListName returns whether name may be used as the
+// name of a named list. See (*Server).List().
+func ValidListName(name string) bool {
+	return len(name) != 0 && len(name) <= listNameSize && strings.IndexByte(name, 0) < 0
+}
+
+// List is a named blocklist that is published to the
+// same shared memory as, and independently of, the
+// default blocklist.
+//
+// Changes to a List follow the batching of the Server
+// it belongs to, so changes to any number of lists can
+// be committed together.
+type List struct {
+	s *Server
+	l *namedList
+}
+
+// List returns the named list called name, creating it
+// if it does not already exist.
+//
+// The name must be no longer than 48 bytes and must
+// not contain a NUL byte.
+//
+// Will fail if Closed() has already been called.
+func (s *Server) List(name string) (*List, error) {
+	if !ValidListName(name) {
+		return nil, ErrInvalidListName
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, ErrClosed
+	}
+
+	l := s.list([]byte(name))
+	if l == nil {
+		l = newNamedList([]byte(name))
+		s.addList(l)
+	}
+
+	return &List{s, l}, nil
+}
+
+// Name returns the name of the list.
+func (l *List) Name() string {
+	return string(l.l.name)
+}
+
+// Insert inserts a single IP address into the list.
+//
+// It follows the same rules as (*Server).Insert().
+func (l *List) Insert(ip net.IP) error {
+	return l.s.doInsertRemove(&l.l.ip4s, &l.l.ip6s, ip, true, 0, 0)
+}
+
+// InsertWithTTL inserts a single IP address into the
+// list and removes it again once ttl has elapsed.
+//
+// It follows the same rules as
+// (*Server).InsertWithTTL().
+func (l *List) InsertWithTTL(ip net.IP, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+
+	return l.s.doInsertRemove(&l.l.ip4s, &l.l.ip6s, ip, true, time.Now().Add(ttl).UnixNano(), 0)
+}
+
+// Remove removes a single IP address from the list.
+//
+// It follows the same rules as (*Server).Remove().
+func (l *List) Remove(ip net.IP) error {
+	return l.s.doInsertRemove(&l.l.ip4s, &l.l.ip6s, ip, false, 0, 0)
+}
+
+// InsertRange inserts all IP addresses in a CIDR block
+// into the list.
+//
+// It follows the same rules as (*Server).InsertRange().
+func (l *List) InsertRange(ip net.IP, ipnet *net.IPNet) error {
+	return l.s.doInsertRemoveRange(&l.l.ip4s, &l.l.ip6s, ip, ipnet, true, 0, 0)
+}
+
+// InsertRangeWithTTL inserts all IP addresses in a
+// CIDR block into the list and removes them again once
+// ttl has elapsed.
+//
+// It follows the same rules as
+// (*Server).InsertRangeWithTTL().
+func (l *List) InsertRangeWithTTL(ip net.IP, ipnet *net.IPNet, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+
+	return l.s.doInsertRemoveRange(&l.l.ip4s, &l.l.ip6s, ip, ipnet, true, time.Now().Add(ttl).UnixNano(), 0)
+}
+
+// RemoveRange removes all IP addresses in a CIDR block
+// from the list.
+//
+// It follows the same rules as (*Server).RemoveRange().
+func (l *List) RemoveRange(ip net.IP, ipnet *net.IPNet) error {
+	return l.s.doInsertRemoveRange(&l.l.ip4s, &l.l.ip6s, ip, ipnet, false, 0, 0)
+}
+
+// Clear removes all IP addresses and ranges from the
+// list.
+//
+// If presently batching, Clear() will not commit the
+// changes to shared memory.
+//
+// Will fail if Closed() has already been called.
+func (l *List) Clear() error {
+	s := l.s
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	l.l.ip4s.Clear()
+	l.l.ip6s.Clear()
+
+	if s.batching {
+		return nil
+	}
+
+	return s.commit()
+}
+
+// Match records a list that an IP address was found in
+// by (*Client).Lookup().
+type Match struct {
+	// List is the name of the named list, or the empty
+	// string for the default blocklist.
+	List string
+
+	// Tag is the tag the IP address was inserted into
+	// the list with, or zero if it is untagged.
+	Tag Tag
+}
+
+// ContainsIn returns a boolean indicating whether the
+// IP address is in any of the named lists. The empty
+// name refers to the default blocklist.
+//
+// Lists that do not exist are treated as empty.
+func (c *Client) ContainsIn(ip net.IP, names ...string) (has bool, err error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.closed {
+		return false, ErrClosed
+	}
+
+	ip4 := ip.To4()
+	ip6 := ip.To16()
+
+	if ip4 == nil && ip6 == nil {
+		return false, &net.AddrError{Err: "invalid IP address", Addr: ip.String()}
+	}
+
+	now := time.Now().UnixNano()
+
+	err = c.view(func(t *tables) {
+		has = false
+
+		for _, name := range names {
+			ip4s, ip6s := t.blocklist([]byte(name))
+
+			switch {
+			case ip4s == nil:
+			case ip4 != nil:
+				has = ip4s.containsUnexpired(ip4, now)
+			default:
+				has = ip6s.containsUnexpired(ip6, now)
+			}
+
+			if has {
+				return
+			}
+		}
+	})
+	return
+}
+
+// Lookup returns every list that the IP address is in,
+// starting with the default blocklist and followed by
+// the named lists in order of name.
+//
+// Lookup does not consult the allowlist.
+func (c *Client) Lookup(ip net.IP) (matches []Match, err error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.closed {
+		return nil, ErrClosed
+	}
+
+	ip4 := ip.To4()
+	ip6 := ip.To16()
+
+	if ip4 == nil && ip6 == nil {
+		return nil, &net.AddrError{Err: "invalid IP address", Addr: ip.String()}
+	}
+
+	now := time.Now().UnixNano()
+
+	err = c.view(func(t *tables) {
+		matches = matches[:0]
+
+		addr, ips := ip6, &t.ip6s
+		if ip4 != nil {
+			addr, ips = ip4, &t.ip4s
+		}
+
+		if i := ips.Index(addr); i >= 0 && !isExpired(ips.value(i), now) {
+			matches = append(matches, Match{Tag: getTag(ips.value(i))})
+		}
+
+		for _, l := range t.lists {
+			ips := &l.ip6s
+			if ip4 != nil {
+				ips = &l.ip4s
+			}
+
+			if i := ips.Index(addr); i >= 0 && !isExpired(ips.value(i), now) {
+				matches = append(matches, Match{
+					List: string(l.name),
+					Tag:  getTag(ips.value(i)),
+				})
+			}
+		}
+	})
+	return
+}
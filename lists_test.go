@@ -0,0 +1,476 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+package blocker
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testLookup(t *testing.T, client *Client, expect map[string][]string) {
+	for addr, lists := range expect {
+		matches, err := client.Lookup(net.ParseIP(addr))
+		if err != nil {
+			t.Error(err)
+		}
+
+		got := make([]string, len(matches))
+		for i, m := range matches {
+			got[i] = m.List
+		}
+
+		if len(got) != 0 || len(lists) != 0 {
+			if !reflect.DeepEqual(got, lists) {
+				t.Errorf("Lookup(%s) returned %q, expected %q", addr, got, lists)
+			}
+		}
+	}
+}
+
+func TestLists(t *testing.T) {
+	server, client, err := setup(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	spam, err := server.List("spam")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	abuse, err := server.List("abuse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if spam.Name() != "spam" {
+		t.Errorf("invalid list name, expected %q, got %q", "spam", spam.Name())
+	}
+
+	if err = server.Insert(net.ParseIP("192.0.2.1")); err != nil {
+		t.Error(err)
+	}
+
+	if err = spam.Insert(net.ParseIP("192.0.2.1")); err != nil {
+		t.Error(err)
+	}
+
+	if err = spam.Insert(net.ParseIP("2001:db8::1")); err != nil {
+		t.Error(err)
+	}
+
+	ip, ipnet, err := net.ParseCIDR("192.0.2.0/24")
+	if err != nil {
+		panic(err)
+	}
+
+	if err = abuse.InsertRange(ip, ipnet); err != nil {
+		t.Error(err)
+	}
+
+	testLookup(t, client, map[string][]string{
+		"192.0.2.1":    {"", "abuse", "spam"},
+		"192.0.2.2":    {"abuse"},
+		"2001:db8::1":  {"spam"},
+		"198.51.100.0": nil,
+		"2001:db8::2":  nil,
+	})
+
+	for _, test := range [...]struct {
+		addr  string
+		names []string
+		has   bool
+	}{
+		{"192.0.2.1", []string{""}, true},
+		{"192.0.2.2", []string{""}, false},
+		{"192.0.2.2", []string{"spam"}, false},
+		{"192.0.2.2", []string{"spam", "abuse"}, true},
+		{"192.0.2.2", []string{"missing"}, false},
+		{"192.0.2.2", nil, false},
+		{"2001:db8::1", []string{"abuse", "spam"}, true},
+	} {
+		has, err := client.ContainsIn(net.ParseIP(test.addr), test.names...)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if has != test.has {
+			t.Errorf("ContainsIn(%s, %q) returned %t, expected %t", test.addr, test.names, has, test.has)
+		}
+	}
+
+	has, err := client.Contains(net.ParseIP("192.0.2.2"))
+	if err != nil {
+		t.Error(err)
+	}
+
+	if has {
+		t.Error("named list changed the result of Contains")
+	}
+
+	if err = abuse.RemoveRange(ip, ipnet); err != nil {
+		t.Error(err)
+	}
+
+	if err = spam.Remove(net.ParseIP("2001:db8::1")); err != nil {
+		t.Error(err)
+	}
+
+	testLookup(t, client, map[string][]string{
+		"192.0.2.1":   {"", "spam"},
+		"192.0.2.2":   nil,
+		"2001:db8::1": nil,
+	})
+
+	if err = spam.Clear(); err != nil {
+		t.Error(err)
+	}
+
+	testLookup(t, client, map[string][]string{
+		"192.0.2.1": {""},
+	})
+
+	again, err := server.List("spam")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = again.Insert(net.ParseIP("192.0.2.3")); err != nil {
+		t.Error(err)
+	}
+
+	if err = server.Clear(); err != nil {
+		t.Error(err)
+	}
+
+	if err = spam.Insert(net.ParseIP("192.0.2.4")); err != nil {
+		t.Error(err)
+	}
+
+	testLookup(t, client, map[string][]string{
+		"192.0.2.1": nil,
+		"192.0.2.3": nil,
+		"192.0.2.4": {"spam"},
+	})
+}
+
+func TestListsBatch(t *testing.T) {
+	server, client, err := setup(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	header := castToHeader(&client.data[0])
+	revision := atomic.LoadUint32((*uint32)(&header.Revision))
+
+	if err = server.Batch(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, name := range [...]string{"a", "b", "c"} {
+		l, err := server.List(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = l.Insert(net.IPv4(192, 0, 2, byte(i))); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if err = server.Insert(net.ParseIP("192.0.2.0")); err != nil {
+		t.Error(err)
+	}
+
+	testLookup(t, client, map[string][]string{
+		"192.0.2.0": nil,
+	})
+
+	if err = server.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if atomic.LoadUint32((*uint32)(&header.Revision)) != revision+1 {
+		t.Error("lists were not committed under a single revision")
+	}
+
+	testLookup(t, client, map[string][]string{
+		"192.0.2.0": {"", "a"},
+		"192.0.2.1": {"b"},
+		"192.0.2.2": {"c"},
+	})
+}
+
+func TestListInvalidName(t *testing.T) {
+	server, _, err := setup(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+
+	for _, name := range [...]string{"", "a\x00b", strings.Repeat("a", listNameSize+1)} {
+		if _, err = server.List(name); err != ErrInvalidListName {
+			t.Errorf("List(%q) did not return ErrInvalidListName, got %v", name, err)
+		}
+	}
+
+	if _, err = server.List(strings.Repeat("a", listNameSize)); err != nil {
+		t.Errorf("List failed for name of maximum length: %v", err)
+	}
+}
+
+func TestListWithTTL(t *testing.T) {
+	server, client, err := setup(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	l, err := server.List("temp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = l.InsertWithTTL(net.ParseIP("192.0.2.0"), 100*time.Millisecond); err != nil {
+		t.Error(err)
+	}
+
+	if err = l.InsertWithTTL(net.ParseIP("192.0.2.1"), 0); err != ErrInvalidTTL {
+		t.Errorf("InsertWithTTL did not return ErrInvalidTTL, got %v", err)
+	}
+
+	testLookup(t, client, map[string][]string{
+		"192.0.2.0": {"temp"},
+	})
+
+	time.Sleep(200 * time.Millisecond)
+
+	server.mu.Lock()
+	n := l.l.ip4s.Len()
+	server.mu.Unlock()
+
+	if n != 0 {
+		t.Errorf("expired entries were not removed from list, got %d ip4 ranges", n)
+	}
+
+	testLookup(t, client, map[string][]string{
+		"192.0.2.0": nil,
+	})
+}
+
+func TestLoadSaveLists(t *testing.T) {
+	server1, _, err := setup(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server1.Unlink()
+	defer server1.Close()
+
+	server2, client, err := setup(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server2.Unlink()
+	defer server2.Close()
+	defer client.Close()
+
+	spam, err := server1.List("spam")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = spam.Insert(net.ParseIP("192.0.2.0")); err != nil {
+		t.Error(err)
+	}
+
+	if err = spam.InsertWithTTL(net.ParseIP("2001:db8::"), time.Hour); err != nil {
+		t.Error(err)
+	}
+
+	if _, err = server1.List("empty"); err != nil {
+		t.Fatal(err)
+	}
+
+	stale, err := server2.List("stale")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = stale.Insert(net.ParseIP("198.51.100.0")); err != nil {
+		t.Error(err)
+	}
+
+	var b bytes.Buffer
+
+	if err = server1.Save(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = server2.Load(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	server2.mu.Lock()
+	l := server2.list([]byte("spam"))
+
+	if l == nil {
+		t.Error("Load did not restore named list")
+	} else if !bytes.Equal(l.ip4s.Data, spam.l.ip4s.Data) || !bytes.Equal(l.ip6s.Data, spam.l.ip6s.Data) {
+		t.Error("named list differs after Load, Save")
+	}
+
+	if server2.list([]byte("empty")) == nil {
+		t.Error("Load did not restore empty named list")
+	}
+	server2.mu.Unlock()
+
+	testLookup(t, client, map[string][]string{
+		"192.0.2.0":    {"spam"},
+		"2001:db8::":   {"spam"},
+		"198.51.100.0": nil,
+	})
+
+	if err = stale.Insert(net.ParseIP("198.51.100.0")); err != nil {
+		t.Error(err)
+	}
+
+	testLookup(t, client, map[string][]string{
+		"198.51.100.0": {"stale"},
+	})
+}
+
+func TestLoadInvalidLists(t *testing.T) {
+	server, _, err := setup(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+
+	for _, sections := range [...][]section{
+		{{sectionListIP4, make([]byte, 2*net.IPv4len+expirySize)}},
+		{{sectionList, nil}},
+		{{sectionList, []byte("a\x00")}},
+		{{sectionList, []byte("a")}, {sectionList, []byte("a")}},
+		{{sectionList, []byte("a")}, {sectionListIP6, make([]byte, 2*net.IPv6len)}},
+	} {
+		var b bytes.Buffer
+		b.WriteString(serializedHeader)
+
+		for _, s := range sections {
+			if err = writeSection(&b, s.id, s.data); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		b.Write([]byte{0, 0, 0, 0})
+
+		if err = server.Load(&b); err != (InvalidDataError{errInvalidSection}) {
+			t.Errorf("Load did not fail with invalid section, got %v", err)
+		}
+	}
+}
+
+func TestAttachLists(t *testing.T) {
+	server1, client, err := setup(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer client.Close()
+
+	l, err := server1.List("spam")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = l.Insert(net.ParseIP("192.0.2.0")); err != nil {
+		t.Error(err)
+	}
+
+	if err = server1.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	server2, err := Attach(client.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server2.Unlink()
+	defer server2.Close()
+
+	if l, err = server2.List("spam"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = l.Insert(net.ParseIP("2001:db8::")); err != nil {
+		t.Error(err)
+	}
+
+	testLookup(t, client, map[string][]string{
+		"192.0.2.0":  {"spam"},
+		"2001:db8::": {"spam"},
+	})
+}
+
+func TestListClosed(t *testing.T) {
+	server, client, err := setup(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+
+	l, err := server.List("spam")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.Close()
+	client.Close()
+
+	if _, err = server.List("spam"); err != ErrClosed {
+		t.Errorf("List did not return ErrClosed on closed, got %v", err)
+	}
+
+	if err = l.Insert(net.IPv4zero); err != ErrClosed {
+		t.Errorf("Insert did not return ErrClosed on closed, got %v", err)
+	}
+
+	if err = l.Clear(); err != ErrClosed {
+		t.Errorf("Clear did not return ErrClosed on closed, got %v", err)
+	}
+
+	if _, err = client.ContainsIn(net.IPv4zero, "spam"); err != ErrClosed {
+		t.Errorf("ContainsIn did not return ErrClosed on closed, got %v", err)
+	}
+
+	if _, err = client.Lookup(net.IPv4zero); err != ErrClosed {
+		t.Errorf("Lookup did not return ErrClosed on closed, got %v", err)
+	}
+}
@@ -1,167 +0,0 @@
-// Copyright 2009 The Go Authors. All rights reserved.
-// Use of this source code is governed by a BSD-style
-// license that can be found in the LICENSE file.
-
-// GOMAXPROCS=10 go test
-
-package blocker
-
-import (
-	"fmt"
-	"sync/atomic"
-	"testing"
-)
-
-func HammerMutex(m *mutex, loops int, cdone chan bool) {
-	for i := 0; i < loops; i++ {
-		m.Lock()
-		m.Unlock()
-	}
-	cdone <- true
-}
-
-func TestMutex(t *testing.T) {
-	m := new(mutex)
-	m.Create()
-	c := make(chan bool)
-	for i := 0; i < 10; i++ {
-		go HammerMutex(m, 1000, c)
-	}
-	for i := 0; i < 10; i++ {
-		<-c
-	}
-}
-
-func TestMutexContended(t *testing.T) {
-	m := new(mutex)
-	m.Create()
-	c := make(chan bool)
-	var activity int32
-	for i := 0; i < 10; i++ {
-		go func() {
-			m.Lock()
-			n := atomic.AddInt32(&activity, 1)
-			if n != 1 {
-				panic(fmt.Sprintf("wlock(%d)\n", n))
-			}
-			for i := 0; i < 100; i++ {
-			}
-			atomic.AddInt32(&activity, -1)
-			m.Unlock()
-			c <- true
-		}()
-	}
-	for i := 0; i < 10; i++ {
-		<-c
-	}
-}
-
-func BenchmarkMutexUncontended(b *testing.B) {
-	type PaddedMutex struct {
-		mutex
-		pad [128]uint8
-	}
-	b.RunParallel(func(pb *testing.PB) {
-		var mu PaddedMutex
-		mu.Create()
-		for pb.Next() {
-			mu.Lock()
-			mu.Unlock()
-		}
-	})
-}
-
-func benchmarkMutex(b *testing.B, slack, work bool) {
-	var mu mutex
-	mu.Create()
-	if slack {
-		b.SetParallelism(10)
-	}
-	b.RunParallel(func(pb *testing.PB) {
-		foo := 0
-		for pb.Next() {
-			mu.Lock()
-			mu.Unlock()
-			if work {
-				for i := 0; i < 100; i++ {
-					foo *= 2
-					foo /= 2
-				}
-			}
-		}
-		_ = foo
-	})
-}
-
-func BenchmarkMutex(b *testing.B) {
-	benchmarkMutex(b, false, false)
-}
-
-func BenchmarkMutexSlack(b *testing.B) {
-	benchmarkMutex(b, true, false)
-}
-
-func BenchmarkMutexWork(b *testing.B) {
-	benchmarkMutex(b, false, true)
-}
-
-func BenchmarkMutexWorkSlack(b *testing.B) {
-	benchmarkMutex(b, true, true)
-}
-
-func BenchmarkMutexNoSpin(b *testing.B) {
-	// This benchmark models a situation where spinning in the mutex should be
-	// non-profitable and allows to confirm that spinning does not do harm.
-	// To achieve this we create excess of goroutines most of which do local work.
-	// These goroutines yield during local work, so that switching from
-	// a blocked goroutine to other goroutines is profitable.
-	// As a matter of fact, this benchmark still triggers some spinning in the mutex.
-	var m mutex
-	m.Create()
-	var acc0, acc1 uint64
-	b.SetParallelism(4)
-	b.RunParallel(func(pb *testing.PB) {
-		c := make(chan bool)
-		var data [4 << 10]uint64
-		for i := 0; pb.Next(); i++ {
-			if i%4 == 0 {
-				m.Lock()
-				acc0 -= 100
-				acc1 += 100
-				m.Unlock()
-			} else {
-				for i := 0; i < len(data); i += 4 {
-					data[i]++
-				}
-				// Elaborate way to say runtime.Gosched
-				// that does not put the goroutine onto global runq.
-				go func() {
-					c <- true
-				}()
-				<-c
-			}
-		}
-	})
-}
-
-func BenchmarkMutexSpin(b *testing.B) {
-	// This benchmark models a situation where spinning in the mutex should be
-	// profitable. To achieve this we create a goroutine per-proc.
-	// These goroutines access considerable amount of local data so that
-	// unnecessary rescheduling is penalized by cache misses.
-	var m mutex
-	m.Create()
-	var acc0, acc1 uint64
-	b.RunParallel(func(pb *testing.PB) {
-		var data [16 << 10]uint64
-		for i := 0; pb.Next(); i++ {
-			m.Lock()
-			acc0 -= 100
-			acc1 += 100
-			m.Unlock()
-			for i := 0; i < len(data); i += 4 {
-				data[i]++
-			}
-		}
-	})
-}
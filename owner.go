@@ -0,0 +1,176 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+package blocker
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"os"
+	"sync/atomic"
+
+	"github.com/tmthrgd/go-shm"
+	"golang.org/x/sys/unix"
+)
+
+// newOwnerToken returns a random, non-zero token that
+// identifies a server as the owner of shared memory.
+func newOwnerToken() (uint64, error) {
+	var b [8]byte
+
+	for {
+		if _, err := rand.Read(b[:]); err != nil {
+			return 0, err
+		}
+
+		if token := binary.LittleEndian.Uint64(b[:]); token != 0 {
+			return token, nil
+		}
+	}
+}
+
+// claim makes token the owner of the shared memory if
+// it is currently owned by old.
+func (h *shmHeader) claim(old, token uint64) bool {
+	if !atomic.CompareAndSwapUint64((*uint64)(&h.OwnerToken), old, token) {
+		return false
+	}
+
+	atomic.StoreInt32((*int32)(&h.OwnerPID), int32(os.Getpid()))
+	return true
+}
+
+// release gives up ownership of the shared memory if it
+// is still owned by token.
+func (h *shmHeader) release(token uint64) {
+	if atomic.LoadUint64((*uint64)(&h.OwnerToken)) != token {
+		return
+	}
+
+	atomic.StoreInt32((*int32)(&h.OwnerPID), 0)
+	atomic.CompareAndSwapUint64((*uint64)(&h.OwnerToken), token, 0)
+}
+
+// ownerAlive returns a boolean indicating whether the
+// server that owns the shared memory is still running.
+func (h *shmHeader) ownerAlive() bool {
+	if atomic.LoadUint64((*uint64)(&h.OwnerToken)) == 0 {
+		return false
+	}
+
+	pid := atomic.LoadInt32((*int32)(&h.OwnerPID))
+	return pid != 0 && processAlive(int(pid))
+}
+
+// processAlive returns a boolean indicating whether a
+// process with the given pid exists.
+//
+// Processes in other PID namespaces cannot be seen and
+// are reported as having exited.
+func processAlive(pid int) bool {
+	err := unix.Kill(pid, 0)
+	return err == nil || err == unix.EPERM
+}
+
+// Recover repairs shared memory that was left behind by
+// a server that exited without calling Close(), so that
+// it can be reused without restarting any clients.
+//
+// Clients never wait on the server, so a server that
+// dies part way through a commit simply leaves the
+// previous revision in place. Recover releases the dead
+// server's ownership of the shared memory and, should
+// the active revision be invalid, replaces it with an
+// empty blocklist.
+//
+// It will fail with ErrWriterAlive if the server that
+// owns the shared memory is still running.
+func Recover(name string) error {
+	file, err := shm.Open(name, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if stat.Size() < int64(headerSize) {
+		return ErrInvalidSharedMemory
+	}
+
+	data, err := unix.Mmap(int(file.Fd()), 0, int(stat.Size()), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		return err
+	}
+
+	defer unix.Munmap(data)
+
+	header := castToHeader(&data[0])
+
+	if atomic.LoadUint32((*uint32)(&header.Version)) != version {
+		return ErrInvalidSharedMemory
+	}
+
+	old := atomic.LoadUint64((*uint64)(&header.OwnerToken))
+	if header.ownerAlive() {
+		return ErrWriterAlive
+	}
+
+	token, err := newOwnerToken()
+	if err != nil {
+		return err
+	}
+
+	if !header.claim(old, token) {
+		return ErrWriterAlive
+	}
+
+	defer header.release(token)
+
+	revision := atomic.LoadUint32((*uint32)(&header.Revision))
+	if _, ok := loadTables(data, header.slot(revision)); ok {
+		return nil
+	}
+
+	pos := align(int(headerSize), cachelineSize)
+
+	next := header.slot(revision + 1)
+	for _, b := range next.blocks() {
+		b.set(pos, 0)
+	}
+
+	next.Lists.set(pos, 0)
+	next.Tags.set(pos, 0)
+
+	atomic.AddUint32((*uint32)(&header.Revision), 1)
+	futexWake((*uint32)(&header.Revision))
+	return nil
+}
+
+// IsWriterAlive returns a boolean indicating whether the
+// server that owns the shared memory is still running.
+//
+// If it is not, the blocklist will not change until
+// another server takes over with Attach().
+//
+// Will fail if Closed() has been called.
+func (c *Client) IsWriterAlive() (bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.closed {
+		return false, ErrClosed
+	}
+
+	if len(c.data) < int(headerSize) {
+		return false, ErrInvalidSharedMemory
+	}
+
+	return castToHeader(&c.data[0]).ownerAlive(), nil
+}
@@ -0,0 +1,161 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+package blocker
+
+import (
+	"net"
+	"os/exec"
+	"sync/atomic"
+	"testing"
+)
+
+// deadPID returns the pid of a process that has exited.
+func deadPID(t *testing.T) int32 {
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skip(err)
+	}
+
+	return int32(cmd.Process.Pid)
+}
+
+func TestIsWriterAlive(t *testing.T) {
+	server, client, err := setup(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer client.Close()
+
+	if alive, err := client.IsWriterAlive(); err != nil {
+		t.Error(err)
+	} else if !alive {
+		t.Error("IsWriterAlive returned false for running server")
+	}
+
+	header := castToHeader(&server.data[0])
+	pid := atomic.LoadInt32((*int32)(&header.OwnerPID))
+
+	atomic.StoreInt32((*int32)(&header.OwnerPID), deadPID(t))
+
+	if alive, err := client.IsWriterAlive(); err != nil {
+		t.Error(err)
+	} else if alive {
+		t.Error("IsWriterAlive returned true for dead server")
+	}
+
+	atomic.StoreInt32((*int32)(&header.OwnerPID), pid)
+
+	if err = server.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if alive, err := client.IsWriterAlive(); err != nil {
+		t.Error(err)
+	} else if alive {
+		t.Error("IsWriterAlive returned true for closed server")
+	}
+}
+
+func TestRecoverWriterAlive(t *testing.T) {
+	server, _, err := setup(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+
+	if err = Recover(server.Name()); err != ErrWriterAlive {
+		t.Errorf("Recover did not return ErrWriterAlive for running server, got %v", err)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	server, client, err := setup(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	if err = server.Insert(net.ParseIP("192.0.2.0")); err != nil {
+		t.Fatal(err)
+	}
+
+	header := castToHeader(&server.data[0])
+	atomic.StoreInt32((*int32)(&header.OwnerPID), deadPID(t))
+
+	revision := atomic.LoadUint32((*uint32)(&header.Revision))
+
+	if err = Recover(server.Name()); err != nil {
+		t.Fatal(err)
+	}
+
+	if atomic.LoadUint64((*uint64)(&header.OwnerToken)) != 0 {
+		t.Error("Recover did not release ownership")
+	}
+
+	if atomic.LoadUint32((*uint32)(&header.Revision)) != revision {
+		t.Error("Recover changed revision of valid shared memory")
+	}
+
+	has, err := client.Contains(net.ParseIP("192.0.2.0"))
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !has {
+		t.Error("blocklist does not contain entry after Recover")
+	}
+}
+
+func TestRecoverCorrupt(t *testing.T) {
+	server, client, err := setup(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	if err = server.Insert(net.ParseIP("192.0.2.0")); err != nil {
+		t.Fatal(err)
+	}
+
+	header := castToHeader(&server.data[0])
+	atomic.StoreInt32((*int32)(&header.OwnerPID), deadPID(t))
+
+	active := header.slot(header.Revision)
+	active.IP4.Base, active.IP4.Len = 0, 32
+
+	if _, err = client.Contains(net.ParseIP("192.0.2.0")); err != ErrInvalidSharedMemory {
+		t.Errorf("Contains did not return ErrInvalidSharedMemory for corrupt header, got %v", err)
+	}
+
+	if err = Recover(server.Name()); err != nil {
+		t.Fatal(err)
+	}
+
+	ip4, ip6, err := client.Count()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if ip4 != 0 || ip6 != 0 {
+		t.Errorf("expected empty blocklist after Recover, got %d ip4 and %d ip6 ranges", ip4, ip6)
+	}
+}
+
+func TestRecoverNonExist(t *testing.T) {
+	if err := Recover("/go-test-does-not-exist"); err == nil {
+		t.Error("Recover did not fail for non-existent shared memory")
+	}
+}
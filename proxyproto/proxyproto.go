@@ -0,0 +1,347 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+// Package proxyproto implements a net.Listener that
+// reads the PROXY protocol header sent by load balancers
+// such as HAProxy, so that the real address of each
+// client can be checked against the blocklist.
+//
+// Wrap a net.Listener with a Listener before passing it
+// to listenerblocker or to an http.Server that uses
+// httpblocker:
+//
+//	ln = &proxyproto.Listener{Listener: ln, Trusted: upstreams}
+//	ln = listenerblocker.Block(client, ln)
+//
+// Both versions 1 and 2 of the protocol are supported.
+// See https://www.haproxy.org/download/1.8/doc/proxy-protocol.txt.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout is the time allowed to read the header
+// when Listener.Timeout is zero.
+const DefaultTimeout = 5 * time.Second
+
+var (
+	// ErrNoHeader will be returned by Conn.Read if a
+	// trusted upstream did not send a PROXY protocol
+	// header.
+	ErrNoHeader = errors.New("no PROXY protocol header")
+
+	// ErrInvalidHeader will be returned by Conn.Read if a
+	// trusted upstream sent a PROXY protocol header that
+	// could not be parsed.
+	ErrInvalidHeader = errors.New("invalid PROXY protocol header")
+)
+
+var (
+	v1Signature = []byte("PROXY ")
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+/* the longest header, including the CRLF, is 107 bytes */
+const v1MaxLen = 107
+
+// Listener is a net.Listener that reads the PROXY
+// protocol header from connections accepted from trusted
+// upstreams and reports the addresses in it as the
+// addresses of the connection.
+type Listener struct {
+	net.Listener
+
+	// The networks of upstreams that are trusted
+	// to send a PROXY protocol header. Connections
+	// from any other address are returned as is.
+	// If empty, no upstream is trusted and every
+	// connection is returned as is.
+	Trusted []*net.IPNet
+
+	// The time allowed to read the header. If
+	// zero, DefaultTimeout is used.
+	Timeout time.Duration
+}
+
+func (l *Listener) trusted(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	for _, ipnet := range l.Trusted {
+		if ipnet.Contains(tcp.IP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Accept implements net.Listener.
+//
+// The header is not read until Read, LocalAddr or
+// RemoteAddr is first called on the returned connection,
+// so a slow upstream does not hold up Accept.
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if !l.trusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+
+	timeout := l.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+
+	return &Conn{
+		Conn: conn,
+
+		r: bufio.NewReader(conn),
+
+		timeout: timeout,
+	}, nil
+}
+
+// Conn is a net.Conn accepted from a trusted upstream.
+type Conn struct {
+	net.Conn
+
+	r *bufio.Reader
+
+	timeout time.Duration
+
+	once     sync.Once
+	src, dst net.Addr
+	err      error
+
+	mu       sync.Mutex
+	deadline time.Time // the read deadline set by the caller
+}
+
+// readHeader reads and parses the header, waiting at most
+// c.timeout for it.
+func (c *Conn) readHeader() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		c.src, c.dst, c.err = parseHeader(c.r)
+
+		c.mu.Lock()
+		c.Conn.SetReadDeadline(c.deadline)
+		c.mu.Unlock()
+	})
+}
+
+// SetDeadline implements net.Conn.
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.deadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+// SetReadDeadline implements net.Conn.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.deadline = t
+	return c.Conn.SetReadDeadline(t)
+}
+
+// Read reads data from the connection, after the header.
+//
+// If the header could not be read, Read returns the
+// error and the connection should be closed.
+func (c *Conn) Read(b []byte) (int, error) {
+	if c.readHeader(); c.err != nil {
+		return 0, c.err
+	}
+
+	return c.r.Read(b)
+}
+
+// LocalAddr returns the destination address from the
+// header, or the local address of the connection if the
+// header did not carry one.
+func (c *Conn) LocalAddr() net.Addr {
+	if c.readHeader(); c.dst != nil {
+		return c.dst
+	}
+
+	return c.Conn.LocalAddr()
+}
+
+// RemoteAddr returns the source address from the header,
+// or the remote address of the connection if the header
+// did not carry one or could not be read.
+func (c *Conn) RemoteAddr() net.Addr {
+	if c.readHeader(); c.src != nil {
+		return c.src
+	}
+
+	return c.Conn.RemoteAddr()
+}
+
+// SetLinger calls SetLinger on the underlying connection
+// if it supports it.
+func (c *Conn) SetLinger(sec int) error {
+	if lc, ok := c.Conn.(interface {
+		SetLinger(sec int) error
+	}); ok {
+		return lc.SetLinger(sec)
+	}
+
+	return nil
+}
+
+// parseHeader reads a version 1 or version 2 header from
+// r. src and dst are nil if the header does not carry
+// addresses.
+func parseHeader(r *bufio.Reader) (src, dst net.Addr, err error) {
+	sig, err := r.Peek(len(v1Signature))
+	if err == nil && bytes.Equal(sig, v1Signature) {
+		return parseV1(r)
+	}
+
+	if err == nil && bytes.HasPrefix(v2Signature, sig) {
+		sig, err = r.Peek(len(v2Signature))
+		if err == nil && bytes.Equal(sig, v2Signature) {
+			return parseV2(r)
+		}
+	}
+
+	if err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+
+	return nil, nil, ErrNoHeader
+}
+
+func parseV1(r *bufio.Reader) (src, dst net.Addr, err error) {
+	var line []byte
+	for len(line) < v1MaxLen {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		line = append(line, b)
+
+		if b == '\n' {
+			break
+		}
+	}
+
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, ErrInvalidHeader
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+
+	switch {
+	case len(fields) >= 2 && fields[1] == "UNKNOWN":
+		return nil, nil, nil
+	case len(fields) != 6:
+		return nil, nil, ErrInvalidHeader
+	}
+
+	var ipLen int
+	switch fields[1] {
+	case "TCP4":
+		ipLen = net.IPv4len
+	case "TCP6":
+		ipLen = net.IPv6len
+	default:
+		return nil, nil, ErrInvalidHeader
+	}
+
+	var addrs [2]*net.TCPAddr
+	for i := range addrs {
+		ip := net.ParseIP(fields[2+i])
+		if ip == nil || (ipLen == net.IPv4len) != (ip.To4() != nil) {
+			return nil, nil, ErrInvalidHeader
+		}
+
+		port, err := strconv.ParseUint(fields[4+i], 10, 16)
+		if err != nil {
+			return nil, nil, ErrInvalidHeader
+		}
+
+		addrs[i] = &net.TCPAddr{IP: ip, Port: int(port)}
+	}
+
+	return addrs[0], addrs[1], nil
+}
+
+func parseV2(r *bufio.Reader) (src, dst net.Addr, err error) {
+	var hdr [16]byte
+	if _, err = io.ReadFull(r, hdr[:]); err != nil {
+		return nil, nil, err
+	}
+
+	if hdr[12]>>4 != 2 {
+		return nil, nil, ErrInvalidHeader
+	}
+
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err = io.ReadFull(r, body); err != nil {
+		return nil, nil, err
+	}
+
+	switch hdr[12] & 0xf {
+	case 0x0:
+		/* LOCAL, sent by the upstream for its own health checks */
+		return nil, nil, nil
+	case 0x1:
+		/* PROXY */
+	default:
+		return nil, nil, ErrInvalidHeader
+	}
+
+	var ipLen int
+	switch hdr[13] >> 4 {
+	case 0x1:
+		ipLen = net.IPv4len
+	case 0x2:
+		ipLen = net.IPv6len
+	default:
+		/* AF_UNSPEC, AF_UNIX or unknown */
+		return nil, nil, nil
+	}
+
+	if len(body) < 2*ipLen+4 {
+		return nil, nil, ErrInvalidHeader
+	}
+
+	srcIP := append(net.IP(nil), body[:ipLen]...)
+	dstIP := append(net.IP(nil), body[ipLen:2*ipLen]...)
+	srcPort := int(binary.BigEndian.Uint16(body[2*ipLen:]))
+	dstPort := int(binary.BigEndian.Uint16(body[2*ipLen+2:]))
+
+	switch hdr[13] & 0xf {
+	case 0x1:
+		return &net.TCPAddr{IP: srcIP, Port: srcPort}, &net.TCPAddr{IP: dstIP, Port: dstPort}, nil
+	case 0x2:
+		return &net.UDPAddr{IP: srcIP, Port: srcPort}, &net.UDPAddr{IP: dstIP, Port: dstPort}, nil
+	default:
+		return nil, nil, nil
+	}
+}
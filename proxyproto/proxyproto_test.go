@@ -0,0 +1,239 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func v2Header(cmd, fam byte, body []byte) string {
+	var b bytes.Buffer
+	b.Write(v2Signature)
+	b.WriteByte(0x20 | cmd)
+	b.WriteByte(fam)
+	binary.Write(&b, binary.BigEndian, uint16(len(body)))
+	b.Write(body)
+	return b.String()
+}
+
+func TestParseHeader(t *testing.T) {
+	v4Body := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0x30, 0x39, 0x01, 0xbb}
+	v6Body := append(append(net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2")...), 0x30, 0x39, 0x01, 0xbb)
+
+	for _, test := range [...]struct {
+		in       string
+		src, dst string
+		err      error
+	}{
+		{"PROXY TCP4 192.0.2.1 198.51.100.1 12345 443\r\nrest", "192.0.2.1:12345", "198.51.100.1:443", nil},
+		{"PROXY TCP6 2001:db8::1 2001:db8::2 12345 443\r\nrest", "[2001:db8::1]:12345", "[2001:db8::2]:443", nil},
+		{"PROXY UNKNOWN\r\nrest", "<nil>", "<nil>", nil},
+		{"PROXY UNKNOWN ffff::1 ffff::2 1 2\r\nrest", "<nil>", "<nil>", nil},
+		{"PROXY TCP4 2001:db8::1 198.51.100.1 12345 443\r\nrest", "<nil>", "<nil>", ErrInvalidHeader},
+		{"PROXY TCP4 192.0.2.1 198.51.100.1 123456 443\r\nrest", "<nil>", "<nil>", ErrInvalidHeader},
+		{"PROXY TCP4 192.0.2.1 198.51.100.1 12345\r\nrest", "<nil>", "<nil>", ErrInvalidHeader},
+		{"PROXY UDP4 192.0.2.1 198.51.100.1 12345 443\r\nrest", "<nil>", "<nil>", ErrInvalidHeader},
+		{"PROXY TCP4 192.0.2.1 198.51.100.1 12345 443\nrest", "<nil>", "<nil>", ErrInvalidHeader},
+		{"PROXY " + strings.Repeat("x", 200) + "\r\n", "<nil>", "<nil>", ErrInvalidHeader},
+		{"GET / HTTP/1.1\r\n\r\n", "<nil>", "<nil>", ErrNoHeader},
+		{"PROX", "<nil>", "<nil>", ErrNoHeader},
+		{"", "<nil>", "<nil>", ErrNoHeader},
+		{v2Header(0x1, 0x11, v4Body) + "rest", "192.0.2.1:12345", "198.51.100.1:443", nil},
+		{v2Header(0x1, 0x12, v4Body) + "rest", "192.0.2.1:12345", "198.51.100.1:443", nil},
+		{v2Header(0x1, 0x21, v6Body) + "rest", "[2001:db8::1]:12345", "[2001:db8::2]:443", nil},
+		{v2Header(0x1, 0x11, append(v4Body, 0x04, 0x00, 0x01, 0xff)) + "rest", "192.0.2.1:12345", "198.51.100.1:443", nil},
+		{v2Header(0x0, 0x00, nil) + "rest", "<nil>", "<nil>", nil},
+		{v2Header(0x1, 0x31, make([]byte, 216)) + "rest", "<nil>", "<nil>", nil},
+		{v2Header(0x1, 0x21, v4Body) + "rest", "<nil>", "<nil>", ErrInvalidHeader},
+		{v2Header(0x2, 0x11, v4Body) + "rest", "<nil>", "<nil>", ErrInvalidHeader},
+	} {
+		r := bufio.NewReader(strings.NewReader(test.in))
+
+		src, dst, err := parseHeader(r)
+		if fmt.Sprint(src) != test.src || fmt.Sprint(dst) != test.dst || err != test.err {
+			t.Errorf("parseHeader(%q) returned (%v, %v, %v), expected (%s, %s, %v)", test.in, src, dst, err, test.src, test.dst, test.err)
+			continue
+		}
+
+		if err != nil {
+			continue
+		}
+
+		if rest, _ := ioutil.ReadAll(r); string(rest) != "rest" {
+			t.Errorf("parseHeader(%q) left %q unread, expected %q", test.in, rest, "rest")
+		}
+	}
+}
+
+func TestListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, trusted, err := net.ParseCIDR("127.0.0.2/32")
+	if err != nil {
+		panic(err)
+	}
+
+	l := &Listener{
+		Listener: ln,
+
+		Trusted: []*net.IPNet{trusted},
+		Timeout: 100 * time.Millisecond,
+	}
+
+	defer l.Close()
+
+	for _, test := range [...]struct {
+		local, send string
+		remote      string
+		data        string
+		timeout     bool
+	}{
+		{"127.0.0.2", "PROXY TCP4 192.0.2.1 127.0.0.1 12345 80\r\nhello", "192.0.2.1:12345", "hello", false},
+		{"127.0.0.3", "PROXY TCP4 192.0.2.1 127.0.0.1 12345 80\r\nhello", "127.0.0.3", "PROXY TCP4 192.0.2.1 127.0.0.1 12345 80\r\nhello", false},
+		{"127.0.0.2", "PROXY TCP4", "127.0.0.2", "", true},
+	} {
+		d := &net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP(test.local)}}
+
+		client, err := d.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = client.Write([]byte(test.send)); err != nil {
+			t.Fatal(err)
+		}
+
+		/* close the write side so that ReadAll returns */
+		if !test.timeout {
+			client.(*net.TCPConn).CloseWrite()
+		}
+
+		conn, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		start := time.Now()
+
+		if remote := conn.RemoteAddr().String(); !strings.HasPrefix(remote, test.remote) {
+			t.Errorf("RemoteAddr returned %s, expected %s", remote, test.remote)
+		}
+
+		data, err := ioutil.ReadAll(conn)
+		if test.timeout {
+			if nerr, ok := err.(net.Error); !ok || !nerr.Timeout() {
+				t.Errorf("Read did not time out reading incomplete header, got %v", err)
+			}
+
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("Read took %s to time out", elapsed)
+			}
+		} else if err != nil {
+			t.Error(err)
+		}
+
+		if string(data) != test.data {
+			t.Errorf("Read returned %q, expected %q", data, test.data)
+		}
+
+		conn.Close()
+		client.Close()
+	}
+}
+
+func TestListenerNoTrusted(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := &Listener{Listener: ln}
+	defer l.Close()
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer client.Close()
+
+	if _, err = client.Write([]byte("PROXY TCP4 192.0.2.1 127.0.0.1 12345 80\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	/* without Trusted, a forged header must not be believed */
+	if _, ok := conn.(*Conn); ok {
+		t.Error("Accept wrapped a connection from an untrusted upstream")
+	}
+
+	if remote := conn.RemoteAddr().String(); strings.HasPrefix(remote, "192.0.2.1") {
+		t.Errorf("RemoteAddr returned forged address %s", remote)
+	}
+}
+
+func TestConnDeadline(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, trusted, err := net.ParseCIDR("127.0.0.0/8")
+	if err != nil {
+		panic(err)
+	}
+
+	l := &Listener{
+		Listener: ln,
+		Trusted:  []*net.IPNet{trusted},
+	}
+	defer l.Close()
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer client.Close()
+
+	if _, err = client.Write([]byte("PROXY UNKNOWN\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	/* the deadline must survive the header being read */
+	if err = conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = conn.Read(make([]byte, 1))
+	if nerr, ok := err.(net.Error); !ok || !nerr.Timeout() {
+		t.Errorf("Read did not time out after the header was read, got %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+package blocker
+
+import (
+	"io"
+	"net"
+	"time"
+)
+
+// Snapshot is an immutable, in-process copy of the
+// blocklist, the allowlist and every named list as they
+// were at a single revision of the shared memory.
+//
+// A Snapshot does not refer to the shared memory, so it
+// remains valid, and unchanged, after the server commits
+// further changes or the Client is closed. It is safe for
+// concurrent use.
+//
+// Ranges that were inserted with a TTL are not reported
+// once the TTL has elapsed.
+type Snapshot struct {
+	revision uint32
+	t        tables
+}
+
+// Snapshot copies the current revision of the shared
+// memory into a new Snapshot.
+//
+// The read lock is only held while copying, so a
+// Snapshot can be inspected for as long as needed without
+// holding up Close.
+func (c *Client) Snapshot() (*Snapshot, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.closed {
+		return nil, ErrClosed
+	}
+
+	s := new(Snapshot)
+
+	if err := c.viewRevision(func(t *tables, revision uint32) {
+		s.revision, s.t = revision, t.clone()
+	}); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Range calls fn for each CIDR block in the blocklist,
+// IPv4 blocks first, in ascending order. Adjacent ranges
+// are reported as the smallest set of CIDR blocks that
+// covers them. If fn returns false, Range stops.
+//
+// Range takes a Snapshot first, so fn is called without
+// any lock held and it is free to call other methods of
+// c.
+func (c *Client) Range(fn func(ipnet net.IPNet) bool) error {
+	s, err := c.Snapshot()
+	if err != nil {
+		return err
+	}
+
+	s.Range(fn)
+	return nil
+}
+
+// Revision returns the revision of the shared memory that
+// s was copied from.
+func (s *Snapshot) Revision() uint32 {
+	return s.revision
+}
+
+// Count returns the number of IPv4 ranges and IPv6
+// ranges stored in the blocklist.
+//
+// Like Client.Count, it counts ranges whose TTL has
+// elapsed but which the server has not yet removed.
+func (s *Snapshot) Count() (ip4, ip6 int) {
+	return s.t.ip4s.Len(), s.t.ip6s.Len()
+}
+
+// Contains returns a boolean indicating whether the
+// IP address is in the blocklist.
+//
+// Contains does not consult the allowlist, use Check()
+// for that.
+func (s *Snapshot) Contains(ip net.IP) (has bool, err error) {
+	res, err := s.check(ip, false)
+	return res == Blocked, err
+}
+
+// Check returns whether the IP address is allowed,
+// blocked or in neither list. The allowlist takes
+// precedence over the blocklist.
+func (s *Snapshot) Check(ip net.IP) (res Result, err error) {
+	return s.check(ip, true)
+}
+
+func (s *Snapshot) check(ip net.IP, allowlist bool) (Result, error) {
+	addr, allows, ips := ip.To4(), &s.t.allow4s, &s.t.ip4s
+	if addr == nil {
+		addr, allows, ips = ip.To16(), &s.t.allow6s, &s.t.ip6s
+	}
+
+	if addr == nil {
+		return NotListed, &net.AddrError{Err: "invalid IP address", Addr: ip.String()}
+	}
+
+	switch {
+	case allowlist && allows.Contains(addr):
+		return Allowed, nil
+	case ips.containsUnexpired(addr, time.Now().UnixNano()):
+		return Blocked, nil
+	default:
+		return NotListed, nil
+	}
+}
+
+// Range calls fn for each CIDR block in the blocklist,
+// IPv4 blocks first, in ascending order. If fn returns
+// false, Range stops.
+func (s *Snapshot) Range(fn func(ipnet net.IPNet) bool) {
+	now := time.Now().UnixNano()
+
+	for _, t := range [...]*rangeTable{&s.t.ip4s, &s.t.ip6s} {
+		first, last := make([]byte, t.Size), make([]byte, t.Size)
+		for i := range last {
+			last[i] = 0xff
+		}
+
+		for _, ipnet := range t.overlapping(first, last, now) {
+			if !fn(*ipnet) {
+				return
+			}
+		}
+	}
+}
+
+// Export writes the blocklist to w in the given format.
+func (s *Snapshot) Export(w io.Writer, format ExportFormat) error {
+	now := time.Now().UnixNano()
+	return writeExport(w, format, s.t.ip4s.cidrs(now), s.t.ip6s.cidrs(now))
+}
@@ -0,0 +1,172 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+package blocker
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	server, client, err := setup(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	for i, cidr := range [...]string{"192.0.2.0/25", "192.0.2.128/25", "198.51.100.7/32", "2001:db8::/64"} {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+
+		if err = server.InsertRangeTagged(ipnet.IP, ipnet, Tag(i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = server.Allow(net.ParseIP("192.0.2.1")); err != nil {
+		t.Fatal(err)
+	}
+
+	snap, err := client.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var before bytes.Buffer
+	if err = snap.Export(&before, ExportText); err != nil {
+		t.Fatal(err)
+	}
+
+	revision := snap.Revision()
+
+	/* the snapshot must not change with the shared memory */
+	if err = server.Clear(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = server.Insert(net.ParseIP("203.0.113.1")); err != nil {
+		t.Fatal(err)
+	}
+
+	client.Close()
+
+	if snap.Revision() != revision {
+		t.Errorf("Revision changed from %d to %d", revision, snap.Revision())
+	}
+
+	if ip4, ip6 := snap.Count(); ip4 != 3 || ip6 != 1 {
+		t.Errorf("snapshot returned invalid count, expected (3, 1), got (%d, %d)", ip4, ip6)
+	}
+
+	var got []string
+	snap.Range(func(ipnet net.IPNet) bool {
+		got = append(got, ipnet.String())
+		return true
+	})
+
+	expect := []string{"192.0.2.0/24", "198.51.100.7/32", "2001:db8::/64"}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("Range returned %v, expected %v", got, expect)
+	}
+
+	got = got[:0]
+	snap.Range(func(ipnet net.IPNet) bool {
+		got = append(got, ipnet.String())
+		return len(got) < 2
+	})
+
+	if !reflect.DeepEqual(got, expect[:2]) {
+		t.Errorf("Range did not stop when fn returned false, got %v", got)
+	}
+
+	var after bytes.Buffer
+	if err = snap.Export(&after, ExportText); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(before.Bytes(), after.Bytes()) {
+		t.Errorf("Export changed from %q to %q", before.Bytes(), after.Bytes())
+	}
+
+	for addr, expect := range map[string]Result{
+		"192.0.2.0":   Blocked,
+		"192.0.2.1":   Allowed,
+		"203.0.113.1": NotListed,
+		"2001:db8::1": Blocked,
+	} {
+		res, err := snap.Check(net.ParseIP(addr))
+		if err != nil {
+			t.Error(err)
+		}
+
+		if res != expect {
+			t.Errorf("Check(%s) returned %v, expected %v", addr, res, expect)
+		}
+
+		has, err := snap.Contains(net.ParseIP(addr))
+		if err != nil {
+			t.Error(err)
+		}
+
+		if has != (addr != "203.0.113.1") {
+			t.Errorf("Contains(%s) returned %t", addr, has)
+		}
+	}
+
+	if _, err = snap.Contains(net.IP{192, 0, 2}); err == nil {
+		t.Error("Contains did not fail for invalid IP address")
+	}
+}
+
+func TestClientRange(t *testing.T) {
+	server, client, err := setup(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	if err = server.Insert(net.ParseIP("192.0.2.0")); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	if err = client.Range(func(ipnet net.IPNet) bool {
+		got = append(got, ipnet.String())
+
+		/* fn is called without the read lock held */
+		if _, _, err := client.Count(); err != nil {
+			t.Error(err)
+		}
+
+		return true
+	}); err != nil {
+		t.Error(err)
+	}
+
+	if expect := []string{"192.0.2.0/32"}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("Range returned %v, expected %v", got, expect)
+	}
+
+	client.Close()
+
+	if err = client.Range(func(net.IPNet) bool { return true }); err != ErrClosed {
+		t.Errorf("Range did not return ErrClosed after Close, got %v", err)
+	}
+
+	if _, err = client.Snapshot(); err != ErrClosed {
+		t.Errorf("Snapshot did not return ErrClosed after Close, got %v", err)
+	}
+}
@@ -0,0 +1,341 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+package blocker
+
+import (
+	"bytes"
+	"sort"
+)
+
+// rangeTable is a sorted list of non-overlapping IP
+// address ranges.
+//
+// Each entry is stored in Data as the first address of
+// the range, immediately followed by the last address of
+// the range, both Size bytes long, and then a value of
+// ValueSize bytes. Adjacent ranges are always merged
+// unless their values differ.
+type rangeTable struct {
+	Data      []byte
+	Size      int
+	ValueSize int
+}
+
+// mergeFunc returns the value to store for a part of an
+// existing range with value old that is covered by a new
+// range with value value.
+type mergeFunc func(old, value []byte) []byte
+
+func (t *rangeTable) entrySize() int {
+	return 2*t.Size + t.ValueSize
+}
+
+// Len returns the number of ranges in t.
+func (t *rangeTable) Len() int {
+	return len(t.Data) / t.entrySize()
+}
+
+func (t *rangeTable) start(i int) []byte {
+	pos := i * t.entrySize()
+	return t.Data[pos : pos+t.Size : pos+t.Size]
+}
+
+func (t *rangeTable) end(i int) []byte {
+	pos := i*t.entrySize() + t.Size
+	return t.Data[pos : pos+t.Size : pos+t.Size]
+}
+
+func (t *rangeTable) value(i int) []byte {
+	pos := i*t.entrySize() + 2*t.Size
+	return t.Data[pos : pos+t.ValueSize : pos+t.ValueSize]
+}
+
+// searchEnd returns the index of the first range that
+// ends at or after ip.
+func (t *rangeTable) searchEnd(ip []byte) int {
+	return sort.Search(t.Len(), func(i int) bool {
+		return bytes.Compare(t.end(i), ip) >= 0
+	})
+}
+
+// searchStart returns the index of the first range that
+// starts after ip.
+func (t *rangeTable) searchStart(ip []byte) int {
+	return sort.Search(t.Len(), func(i int) bool {
+		return bytes.Compare(t.start(i), ip) > 0
+	})
+}
+
+// Index returns the index of the range that covers ip or
+// -1 if there is no such range.
+func (t *rangeTable) Index(ip []byte) int {
+	if i := t.searchEnd(ip); i < t.Len() && bytes.Compare(t.start(i), ip) <= 0 {
+		return i
+	}
+
+	return -1
+}
+
+// Contains returns a boolean indicating whether ip is
+// covered by any range in t.
+func (t *rangeTable) Contains(ip []byte) bool {
+	return t.Index(ip) >= 0
+}
+
+// sortedFrom returns a boolean indicating whether each
+// range in t from index i onwards ends at or after it
+// starts and starts after the previous range ends.
+func (t *rangeTable) sortedFrom(i int) bool {
+	for ; i < t.Len(); i++ {
+		if bytes.Compare(t.start(i), t.end(i)) > 0 {
+			return false
+		}
+
+		if i > 0 && bytes.Compare(t.start(i), t.end(i-1)) <= 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Insert adds the range [first, last] to t with the given
+// value, merging it with any range it overlaps or is
+// adjacent to that has the same value.
+//
+// Where the range overlaps an existing range, merge is
+// called to determine the value of the overlapping part.
+// If merge is nil, value replaces the existing value. A
+// nil value is treated as all zeros.
+func (t *rangeTable) Insert(first, last, value []byte, merge mergeFunc) {
+	i, j := t.searchEnd(first), t.searchStart(last)
+	lo, hi := i, j
+
+	entries := make([]byte, 0, (2*(j-i)+5)*t.entrySize())
+
+	if lo > 0 {
+		lo--
+		entries = append(entries, t.Data[lo*t.entrySize():i*t.entrySize()]...)
+	}
+
+	if i < j && bytes.Compare(t.start(i), first) < 0 {
+		prev := append([]byte(nil), first...)
+		decrBytes(prev)
+
+		entries = t.appendEntry(entries, t.start(i), prev, t.value(i))
+	}
+
+	next := append([]byte(nil), first...)
+	overflow := false
+
+	for k := i; k < j; k++ {
+		start, end := t.start(k), t.end(k)
+
+		if bytes.Compare(start, first) < 0 {
+			start = first
+		}
+
+		if bytes.Compare(end, last) > 0 {
+			end = last
+		}
+
+		if bytes.Compare(next, start) < 0 {
+			prev := append([]byte(nil), start...)
+			decrBytes(prev)
+
+			entries = t.appendEntry(entries, next, prev, value)
+		}
+
+		v := value
+		if merge != nil {
+			v = merge(t.value(k), value)
+		}
+
+		entries = t.appendEntry(entries, start, end, v)
+
+		copy(next, end)
+		incrBytes(next)
+		overflow = isZero(next)
+	}
+
+	if !overflow && bytes.Compare(next, last) <= 0 {
+		entries = t.appendEntry(entries, next, last, value)
+	}
+
+	if i < j && bytes.Compare(t.end(j-1), last) > 0 {
+		after := append([]byte(nil), last...)
+		incrBytes(after)
+
+		entries = t.appendEntry(entries, after, t.end(j-1), t.value(j-1))
+	}
+
+	if hi < t.Len() {
+		entries = append(entries, t.Data[hi*t.entrySize():(hi+1)*t.entrySize()]...)
+		hi++
+	}
+
+	t.replace(lo, hi, t.coalesce(entries))
+}
+
+// Remove removes the range [first, last] from t, splitting
+// any range it partially overlaps.
+func (t *rangeTable) Remove(first, last []byte) {
+	i, j := t.searchEnd(first), t.searchStart(last)
+	if i >= j {
+		return
+	}
+
+	entries := make([]byte, 0, 2*t.entrySize())
+
+	if bytes.Compare(t.start(i), first) < 0 {
+		prev := append([]byte(nil), first...)
+		decrBytes(prev)
+
+		entries = t.appendEntry(entries, t.start(i), prev, t.value(i))
+	}
+
+	if bytes.Compare(t.end(j-1), last) > 0 {
+		after := append([]byte(nil), last...)
+		incrBytes(after)
+
+		entries = t.appendEntry(entries, after, t.end(j-1), t.value(j-1))
+	}
+
+	t.replace(i, j, entries)
+}
+
+func (t *rangeTable) appendEntry(b, first, last, value []byte) []byte {
+	b = append(b, first...)
+	b = append(b, last...)
+
+	if value != nil {
+		return append(b, value...)
+	}
+
+	for i := 0; i < t.ValueSize; i++ {
+		b = append(b, 0)
+	}
+
+	return b
+}
+
+// coalesce merges consecutive entries that are adjacent
+// and have the same value.
+func (t *rangeTable) coalesce(entries []byte) []byte {
+	size := t.entrySize()
+	out := entries[:0]
+
+	for pos := 0; pos < len(entries); pos += size {
+		entry := entries[pos : pos+size]
+
+		if len(out) != 0 {
+			prev := out[len(out)-size:]
+
+			if isNext(prev[t.Size:2*t.Size], entry[:t.Size]) &&
+				bytes.Equal(prev[2*t.Size:], entry[2*t.Size:]) {
+				copy(prev[t.Size:2*t.Size], entry[t.Size:2*t.Size])
+				continue
+			}
+		}
+
+		out = append(out, entry...)
+	}
+
+	return out
+}
+
+// replace replaces the ranges [i, j) with entries.
+func (t *rangeTable) replace(i, j int, entries []byte) {
+	size := t.entrySize()
+	tail := t.Data[j*size:]
+
+	if n := i*size + len(entries) + len(tail); n > cap(t.Data) {
+		data := make([]byte, n, n+n/4)
+		copy(data, t.Data[:i*size])
+		copy(data[i*size+len(entries):], tail)
+		t.Data = data
+	} else {
+		t.Data = t.Data[:n]
+		copy(t.Data[i*size+len(entries):], tail)
+	}
+
+	copy(t.Data[i*size:], entries)
+}
+
+// Clear removes all ranges from t.
+func (t *rangeTable) Clear() {
+	t.Data = nil
+}
+
+// clone returns a copy of t that does not share Data
+// with t.
+func (t *rangeTable) clone() rangeTable {
+	c := *t
+	if t.Data != nil {
+		c.Data = append([]byte(nil), t.Data...)
+	}
+
+	return c
+}
+
+// isNext returns a boolean indicating whether b is
+// exactly one greater than a.
+func isNext(a, b []byte) bool {
+	for i := len(a) - 1; i >= 0; i-- {
+		if a[i] == 0xff && b[i] == 0x00 {
+			continue
+		}
+
+		return a[i]+1 == b[i] && bytes.Equal(a[:i], b[:i])
+	}
+
+	return false
+}
+
+func isZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func incrBytes(b []byte) {
+	for j := len(b) - 1; j >= 0; j-- {
+		b[j]++
+
+		if b[j] != 0 {
+			break
+		}
+	}
+}
+
+func decrBytes(b []byte) {
+	for j := len(b) - 1; j >= 0; j-- {
+		b[j]--
+
+		if b[j] != 0xff {
+			break
+		}
+	}
+}
+
+// lastAddr returns the last IP address in the network
+// that starts at masked with the given mask.
+func lastAddr(masked []byte, mask []byte) []byte {
+	if len(mask) > len(masked) {
+		mask = mask[len(mask)-len(masked):]
+	}
+
+	last := make([]byte, len(masked))
+	for i := range last {
+		last[i] = masked[i] | ^mask[i]
+	}
+
+	return last
+}
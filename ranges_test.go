@@ -0,0 +1,260 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+package blocker
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"testing"
+)
+
+func parseRange(s string) (first, last net.IP) {
+	if idx := strings.IndexByte(s, '-'); idx != -1 {
+		first, last = net.ParseIP(s[:idx]), net.ParseIP(s[idx+1:])
+	} else {
+		first = net.ParseIP(s)
+		last = first
+	}
+
+	if first4 := first.To4(); first4 != nil {
+		return first4, last.To4()
+	}
+
+	return first, last
+}
+
+func formatRanges(t *rangeTable) string {
+	var ranges []string
+
+	for i := 0; i < t.Len(); i++ {
+		first, last := net.IP(t.start(i)), net.IP(t.end(i))
+
+		if first.Equal(last) {
+			ranges = append(ranges, first.String())
+		} else {
+			ranges = append(ranges, first.String()+"-"+last.String())
+		}
+	}
+
+	return strings.Join(ranges, ",")
+}
+
+func TestRangeTable(t *testing.T) {
+	for i, test := range []struct {
+		insert, remove []string
+		expect         string
+	}{
+		{
+			insert: []string{"192.0.2.0"},
+			expect: "192.0.2.0",
+		},
+		{
+			insert: []string{"192.0.2.1", "192.0.2.0", "192.0.2.2"},
+			expect: "192.0.2.0-192.0.2.2",
+		},
+		{
+			insert: []string{"192.0.2.0", "192.0.2.2"},
+			expect: "192.0.2.0,192.0.2.2",
+		},
+		{
+			insert: []string{"192.0.2.255", "192.0.3.0"},
+			expect: "192.0.2.255-192.0.3.0",
+		},
+		{
+			insert: []string{"192.0.2.0", "192.0.2.10", "192.0.2.20", "192.0.2.1-192.0.2.19"},
+			expect: "192.0.2.0-192.0.2.20",
+		},
+		{
+			insert: []string{"192.0.2.5-192.0.2.10", "192.0.2.0-192.0.2.255"},
+			expect: "192.0.2.0-192.0.2.255",
+		},
+		{
+			insert: []string{"0.0.0.0-255.255.255.255", "192.0.2.0"},
+			expect: "0.0.0.0-255.255.255.255",
+		},
+		{
+			insert: []string{"192.0.2.0-192.0.2.255"},
+			remove: []string{"192.0.2.128"},
+			expect: "192.0.2.0-192.0.2.127,192.0.2.129-192.0.2.255",
+		},
+		{
+			insert: []string{"192.0.2.0-192.0.2.255"},
+			remove: []string{"192.0.2.0", "192.0.2.255"},
+			expect: "192.0.2.1-192.0.2.254",
+		},
+		{
+			insert: []string{"192.0.2.0", "192.0.2.2", "192.0.2.4"},
+			remove: []string{"192.0.2.1-192.0.2.3"},
+			expect: "192.0.2.0,192.0.2.4",
+		},
+		{
+			insert: []string{"192.0.2.0-192.0.2.10", "192.0.2.20-192.0.2.30"},
+			remove: []string{"192.0.2.5-192.0.2.25"},
+			expect: "192.0.2.0-192.0.2.4,192.0.2.26-192.0.2.30",
+		},
+		{
+			insert: []string{"0.0.0.0-255.255.255.255"},
+			remove: []string{"0.0.0.0", "255.255.255.255"},
+			expect: "0.0.0.1-255.255.255.254",
+		},
+		{
+			insert: []string{"192.0.2.0"},
+			remove: []string{"192.0.2.1-192.0.2.255", "192.0.1.0-192.0.1.255"},
+			expect: "192.0.2.0",
+		},
+		{
+			insert: []string{"2001:db8::ffff:ffff:ffff:ffff", "2001:db8:0:1::"},
+			expect: "2001:db8::ffff:ffff:ffff:ffff-2001:db8:0:1::",
+		},
+		{
+			insert: []string{"2001:db8::-2001:db8::ffff"},
+			remove: []string{"2001:db8::100"},
+			expect: "2001:db8::-2001:db8::ff,2001:db8::101-2001:db8::ffff",
+		},
+	} {
+		var table rangeTable
+
+		for _, r := range test.insert {
+			first, last := parseRange(r)
+			table.Size = len(first)
+			table.Insert(first, last, nil, nil)
+		}
+
+		for _, r := range test.remove {
+			table.Remove(parseRange(r))
+		}
+
+		if got := formatRanges(&table); got != test.expect {
+			t.Errorf("%d: invalid ranges, expected %q, got %q", i, test.expect, got)
+		}
+	}
+}
+
+func TestRangeTableContains(t *testing.T) {
+	table := rangeTable{Size: net.IPv4len}
+
+	for _, r := range [...]string{"192.0.2.0-192.0.2.127", "198.51.100.1", "203.0.113.0-203.0.113.255"} {
+		first, last := parseRange(r)
+		table.Insert(first, last, nil, nil)
+	}
+
+	for _, addr := range [...]string{"192.0.2.0", "192.0.2.64", "192.0.2.127", "198.51.100.1", "203.0.113.0", "203.0.113.255"} {
+		if !table.Contains(net.ParseIP(addr).To4()) {
+			t.Errorf("range table does not contain %s", addr)
+		}
+	}
+
+	for _, addr := range [...]string{"0.0.0.0", "192.0.2.128", "198.51.100.0", "198.51.100.2", "203.0.112.255", "203.0.114.0", "255.255.255.255"} {
+		if table.Contains(net.ParseIP(addr).To4()) {
+			t.Errorf("range table contains %s", addr)
+		}
+	}
+}
+
+func TestRangeTableRandom(t *testing.T) {
+	var (
+		table rangeTable
+		set   [256]bool
+	)
+
+	table.Size = 1
+
+	for i := 0; i < 10000; i++ {
+		first, last := byte(rand.Intn(256)), byte(rand.Intn(256))
+		if first > last {
+			first, last = last, first
+		}
+
+		insert := rand.Intn(2) == 0
+		if insert {
+			table.Insert([]byte{first}, []byte{last}, nil, nil)
+		} else {
+			table.Remove([]byte{first}, []byte{last})
+		}
+
+		for j := int(first); j <= int(last); j++ {
+			set[j] = insert
+		}
+	}
+
+	for i := 1; i < table.Len(); i++ {
+		if table.end(i - 1)[0]+1 >= table.start(i)[0] {
+			t.Fatalf("range table contains overlapping or adjacent ranges at %d", i)
+		}
+	}
+
+	for i, has := range set {
+		if table.Contains([]byte{byte(i)}) != has {
+			t.Errorf("range table Contains(%d) != %t", i, has)
+		}
+	}
+}
+
+func TestRangeTableValues(t *testing.T) {
+	table := rangeTable{Size: 1, ValueSize: 1}
+
+	keep := func(old, value []byte) []byte {
+		return old
+	}
+
+	table.Insert([]byte{10}, []byte{20}, []byte{1}, nil)
+	table.Insert([]byte{21}, []byte{30}, []byte{1}, nil)
+	table.Insert([]byte{15}, []byte{25}, []byte{2}, keep)
+	table.Insert([]byte{5}, []byte{12}, []byte{3}, nil)
+	table.Remove([]byte{28}, []byte{28})
+
+	var got []string
+	for i := 0; i < table.Len(); i++ {
+		got = append(got, fmt.Sprintf("%d-%d:%d", table.start(i)[0], table.end(i)[0], table.value(i)[0]))
+	}
+
+	if expect := "5-12:3,13-27:1,29-30:1"; strings.Join(got, ",") != expect {
+		t.Errorf("invalid ranges, expected %q, got %q", expect, strings.Join(got, ","))
+	}
+}
+
+func TestLoadV1(t *testing.T) {
+	server, client, err := setup(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	var b bytes.Buffer
+	b.WriteString(serializedHeaderV1)
+	binary.Write(&b, binary.BigEndian, [...]uint64{3 * net.IPv4len, net.IPv6len, net.IPv6len / 2})
+	b.Write(net.ParseIP("192.0.2.0").To4())
+	b.Write(net.ParseIP("192.0.2.1").To4())
+	b.Write(net.ParseIP("192.0.2.3").To4())
+	b.Write(net.ParseIP("2001:db8::1"))
+	b.Write(net.ParseIP("2001:db8:1::")[:net.IPv6len/2])
+
+	if err = server.Load(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := formatRanges(&server.ip4s); got != "192.0.2.0-192.0.2.1,192.0.2.3" {
+		t.Errorf("invalid ip4 ranges after Load, got %q", got)
+	}
+
+	for _, addr := range [...]string{"192.0.2.0", "192.0.2.1", "192.0.2.3", "2001:db8::1", "2001:db8:1::", "2001:db8:1::ffff"} {
+		has, err := client.Contains(net.ParseIP(addr))
+		if err != nil {
+			t.Error(err)
+		}
+
+		if !has {
+			t.Errorf("blocklist does not contain entry after Load: %s", addr)
+		}
+	}
+}
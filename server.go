@@ -6,16 +6,13 @@
 package blocker
 
 import (
-	"encoding/binary"
-	"io"
 	"net"
 	"os"
 	"sync"
 	"sync/atomic"
+	"time"
 
-	"github.com/tmthrgd/binary-searcher"
 	"github.com/tmthrgd/go-shm"
-	"github.com/tmthrgd/ip-blocker-agent/internal/incr"
 	"golang.org/x/sys/unix"
 )
 
@@ -28,11 +25,16 @@ func align(d, a int) int {
 	return (d + (a - 1)) &^ (a - 1)
 }
 
-func calculateOffsets(base, ip4Len, ip6Len, ip6rLen int) (ip4BasePos, ip6BasePos, ip6rBasePos, end, size int) {
-	ip4BasePos = align(base, cachelineSize)
-	ip6BasePos = align(ip4BasePos+ip4Len, cachelineSize)
-	ip6rBasePos = align(ip6BasePos+ip6Len, cachelineSize)
-	end = align(ip6rBasePos+ip6rLen, cachelineSize)
+func calculateOffsets(base int, lens ...int) (basePos []int, end, size int) {
+	basePos = make([]int, len(lens))
+	end = base
+
+	for i, l := range lens {
+		basePos[i] = align(end, cachelineSize)
+		end = basePos[i] + l
+	}
+
+	end = align(end, cachelineSize)
 	size = align(end, pageSize)
 	return
 }
@@ -54,15 +56,19 @@ func Unlink(name string) error {
 type Server struct {
 	file *os.File
 
-	ip4s  searcher.BinarySearcher
-	ip6s  searcher.BinarySearcher
-	ip6rs searcher.BinarySearcher
+	tables
 
 	data []byte
-	end  int
+
+	token uint64
 
 	mu sync.Mutex
 
+	reaper   *time.Timer
+	nextReap int64
+
+	maxLoadSize int64
+
 	closed   bool
 	batching bool
 }
@@ -73,12 +79,17 @@ type Server struct {
 // This will fail if a shared memory region has already
 // been created with the same name and not unlinked.
 func New(name string, perm os.FileMode) (*Server, error) {
+	token, err := newOwnerToken()
+	if err != nil {
+		return nil, err
+	}
+
 	file, err := shm.Open(name, os.O_CREATE|os.O_EXCL|os.O_TRUNC|os.O_RDWR, perm)
 	if err != nil {
 		return nil, err
 	}
 
-	ip4BasePos, ip6BasePos, ip6rBasePos, end, size := calculateOffsets(int(headerSize), 0, 0, 0)
+	basePos, _, size := calculateOffsets(int(headerSize), 0)
 
 	if err = file.Truncate(int64(size)); err != nil {
 		return nil, err
@@ -91,95 +102,256 @@ func New(name string, perm os.FileMode) (*Server, error) {
 
 	header := castToHeader(&data[0])
 
-	lock := (*rwLock)(&header.Lock)
-	lock.Create()
-
-	header.setBlocks(ip4BasePos, 0, ip6BasePos, 0, ip6rBasePos, 0)
+	header.claim(0, token)
 
 	header.Revision = 1
+	active := header.slot(header.Revision)
+	for _, b := range active.blocks() {
+		b.set(basePos[0], 0)
+	}
+
+	active.Lists.set(basePos[0], 0)
+	active.Tags.set(basePos[0], 0)
 
 	atomic.StoreUint32((*uint32)(&header.Version), version)
 
 	return &Server{
 		file: file,
 
-		ip4s:  searcher.BinarySearcher{Size: net.IPv4len, IncrementBytes: incr.IncrementBytes},
-		ip6s:  searcher.BinarySearcher{Size: net.IPv6len, IncrementBytes: incr.IncrementBytes},
-		ip6rs: searcher.BinarySearcher{Size: net.IPv6len / 2, IncrementBytes: incr.IncrementBytes},
+		tables: newTables(),
 
 		data: data,
-		end:  end,
+
+		token: token,
 	}, nil
 }
 
-func (s *Server) commit() error {
-	s.batching = false
-
-	if err := unix.Munmap(s.data); err != nil {
-		return err
+// Attach creates a new IP blocker shared memory server
+// from existing shared memory with the specified name,
+// taking over from the server that created it.
+//
+// The blocklist is restored from shared memory and each
+// commit carries on from the current revision, so
+// clients are never interrupted.
+//
+// This will fail with ErrWriterAlive if the server that
+// owns the shared memory is still running.
+func Attach(name string) (*Server, error) {
+	token, err := newOwnerToken()
+	if err != nil {
+		return nil, err
 	}
 
-	ip4BasePos2, ip6BasePos2, ip6rBasePos2, end2, size2 := calculateOffsets(int(headerSize), len(s.ip4s.Data), len(s.ip6s.Data), len(s.ip6rs.Data))
-
-	end := s.end
-	if end2 > end {
-		end = end2
+	file, err := shm.Open(name, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
 	}
 
-	ip4BasePos, ip6BasePos, ip6rBasePos, end, size := calculateOffsets(end, len(s.ip4s.Data), len(s.ip6s.Data), len(s.ip6rs.Data))
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
 
-	if err := s.file.Truncate(int64(size)); err != nil {
-		return err
+	size := stat.Size()
+	if size < int64(headerSize) {
+		file.Close()
+		return nil, ErrInvalidSharedMemory
 	}
 
-	data, err := unix.Mmap(int(s.file.Fd()), 0, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	data, err := unix.Mmap(int(file.Fd()), 0, int(size), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
 	if err != nil {
-		return err
+		file.Close()
+		return nil, err
 	}
 
 	header := castToHeader(&data[0])
-	lock := (*rwLock)(&header.Lock)
 
-	copy(data[ip4BasePos:ip4BasePos+len(s.ip4s.Data):ip6BasePos], s.ip4s.Data)
-	copy(data[ip6BasePos:ip6BasePos+len(s.ip6s.Data):ip6rBasePos], s.ip6s.Data)
-	copy(data[ip6rBasePos:ip6rBasePos+len(s.ip6rs.Data):size], s.ip6rs.Data)
+	if atomic.LoadUint32((*uint32)(&header.Version)) != version {
+		unix.Munmap(data)
+		file.Close()
+		return nil, ErrInvalidSharedMemory
+	}
 
-	lock.Lock()
+	old := atomic.LoadUint64((*uint64)(&header.OwnerToken))
+	if header.ownerAlive() || !header.claim(old, token) {
+		unix.Munmap(data)
+		file.Close()
+		return nil, ErrWriterAlive
+	}
 
-	header.setBlocks(ip4BasePos, len(s.ip4s.Data), ip6BasePos, len(s.ip6s.Data), ip6rBasePos, len(s.ip6rs.Data))
+	t, ok := loadTables(data, header.slot(atomic.LoadUint32((*uint32)(&header.Revision))))
+	if !ok {
+		header.release(token)
 
-	header.Revision++
+		unix.Munmap(data)
+		file.Close()
+		return nil, ErrInvalidSharedMemory
+	}
 
-	lock.Unlock()
+	for _, table := range t.all() {
+		table.Data = append([]byte(nil), table.Data...)
+	}
 
-	copy(data[ip4BasePos2:ip4BasePos2+len(s.ip4s.Data):ip6BasePos2], s.ip4s.Data)
-	copy(data[ip6BasePos2:ip6BasePos2+len(s.ip6s.Data):ip6rBasePos2], s.ip6s.Data)
-	copy(data[ip6rBasePos2:ip6rBasePos2+len(s.ip6rs.Data):size2], s.ip6rs.Data)
+	t.tagNames = append([]byte(nil), t.tagNames...)
 
-	lock.Lock()
+	for _, l := range t.lists {
+		l.name = append([]byte(nil), l.name...)
+		l.ip4s.Data = append([]byte(nil), l.ip4s.Data...)
+		l.ip6s.Data = append([]byte(nil), l.ip6s.Data...)
+	}
 
-	header.setBlocks(ip4BasePos2, len(s.ip4s.Data), ip6BasePos2, len(s.ip6s.Data), ip6rBasePos2, len(s.ip6rs.Data))
+	s := &Server{
+		file: file,
 
-	header.Revision++
+		tables: t,
 
-	if err = s.file.Truncate(int64(size2)); err != nil {
-		lock.Unlock()
-		return err
+		data: data,
+
+		token: token,
 	}
 
-	lock.Unlock()
+	s.mu.Lock()
+	s.scheduleNextReap()
+	s.mu.Unlock()
 
-	if err := unix.Munmap(data); err != nil {
-		return err
+	return s, nil
+}
+
+// extent returns the part of shared memory that the
+// range tables, list directory and tag names described
+// by s occupy.
+func (s *slot) extent(data []byte) (start, end int) {
+	start = align(int(headerSize), cachelineSize)
+	end = start
+
+	all := s.blocks()
+	blocks := append(all[:], &s.Lists, &s.Tags)
+
+	if dir, ok := block(data, &s.Lists, listEntrySize); ok {
+		for pos := 0; pos < len(dir); pos += listEntrySize {
+			e := castToListEntry(&dir[pos])
+			blocks = append(blocks, &e.IP4, &e.IP6)
+		}
 	}
 
-	data, err = unix.Mmap(int(s.file.Fd()), 0, size2, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
-	if err != nil {
-		return err
+	first := true
+
+	for _, b := range blocks {
+		if b.Len == 0 {
+			continue
+		}
+
+		if first || int(b.Base) < start {
+			start = int(b.Base)
+		}
+
+		if first || int(b.Base+b.Len) > end {
+			end = int(b.Base + b.Len)
+		}
+
+		first = false
 	}
 
-	s.data = data
-	s.end = end2
+	return
+}
+
+// commit publishes the blocklist to shared memory.
+//
+// Clients never take a lock, instead the header holds
+// two slots describing where the tables are, and the
+// low bit of Revision selects the active slot. The new
+// tables are written to a part of shared memory that
+// the active slot does not refer to, the inactive slot
+// is pointed at them and then Revision is incremented
+// to make it active. A client that observes Revision
+// change during a lookup discards the result and tries
+// again.
+//
+// The range tables of the named lists follow those of
+// the default blocklist and allowlist, and are in turn
+// followed by the tag names and the list directory, so
+// that everything is published under the same Revision.
+//
+// The shared memory is never shrunk as clients may be
+// reading past the new end of it.
+func (s *Server) commit() error {
+	s.batching = false
+
+	header := castToHeader(&s.data[0])
+	revision := header.Revision
+
+	start, end := header.slot(revision).extent(s.data)
+
+	all := s.all()
+
+	tables := all[:]
+	for _, l := range s.lists {
+		tables = append(tables, &l.ip4s, &l.ip6s)
+	}
+
+	lens := make([]int, len(tables)+2)
+
+	for i, table := range tables {
+		lens[i] = len(table.Data)
+	}
+
+	tagPos, dirPos := len(tables), len(tables)+1
+	lens[tagPos] = len(s.tagNames)
+	lens[dirPos] = len(s.lists) * listEntrySize
+
+	basePos, newEnd, size := calculateOffsets(int(headerSize), lens...)
+	if newEnd > start {
+		basePos, newEnd, size = calculateOffsets(end, lens...)
+	}
+
+	if size > len(s.data) {
+		if err := s.file.Truncate(int64(size)); err != nil {
+			return err
+		}
+
+		data, err := unix.Mmap(int(s.file.Fd()), 0, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+		if err != nil {
+			return err
+		}
+
+		if err = unix.Munmap(s.data); err != nil {
+			unix.Munmap(data)
+			return err
+		}
+
+		s.data = data
+		header = castToHeader(&s.data[0])
+	}
+
+	for i, table := range tables {
+		copy(s.data[basePos[i]:basePos[i]+lens[i]], table.Data)
+	}
+
+	copy(s.data[basePos[tagPos]:basePos[tagPos]+lens[tagPos]], s.tagNames)
+
+	next := header.slot(revision + 1)
+
+	for i, b := range next.blocks() {
+		b.set(basePos[i], lens[i])
+	}
+
+	for i, l := range s.lists {
+		e := castToListEntry(&s.data[basePos[dirPos]+i*listEntrySize])
+
+		e.Name = [len(e.Name)]uint8{}
+		copy(e.Name[:], l.name)
+
+		j := len(next.blocks()) + 2*i
+		e.IP4.set(basePos[j], lens[j])
+		e.IP6.set(basePos[j+1], lens[j+1])
+	}
+
+	next.Tags.set(basePos[tagPos], lens[tagPos])
+	next.Lists.set(basePos[dirPos], lens[dirPos])
+
+	atomic.AddUint32((*uint32)(&header.Revision), 1)
+	futexWake((*uint32)(&header.Revision))
 	return nil
 }
 
@@ -203,7 +375,7 @@ func (s *Server) Commit() error {
 	return s.commit()
 }
 
-func (s *Server) doInsertRemove(ip net.IP, insert bool) error {
+func (s *Server) doInsertRemove(ip4s, ip6s *rangeTable, ip net.IP, insert bool, expires int64, tag Tag) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -211,22 +383,25 @@ func (s *Server) doInsertRemove(ip net.IP, insert bool) error {
 		return ErrClosed
 	}
 
+	var ips *rangeTable
+
 	if ip4 := ip.To4(); ip4 != nil {
-		if insert {
-			s.ip4s.Insert(ip4)
-		} else {
-			s.ip4s.Remove(ip4)
-		}
+		ip = ip4
+		ips = ip4s
 	} else if ip6 := ip.To16(); ip6 != nil {
-		if insert {
-			s.ip6s.Insert(ip6)
-		} else {
-			s.ip6s.Remove(ip6)
-		}
+		ip = ip6
+		ips = ip6s
 	} else {
 		return &net.AddrError{Err: "invalid IP address", Addr: ip.String()}
 	}
 
+	if insert {
+		ips.Insert(ip, ip, newValue(expires, tag), mergeValue)
+		s.scheduleReap(expires)
+	} else {
+		ips.Remove(ip, ip)
+	}
+
 	if s.batching {
 		return nil
 	}
@@ -237,32 +412,32 @@ func (s *Server) doInsertRemove(ip net.IP, insert bool) error {
 // Insert inserts a single IP address into the
 // blocklist.
 //
+// The IP address never expires, even if it was
+// previously inserted with InsertWithTTL().
+//
 // If presently batching, Insert() will not commit the
 // changes to shared memory.
 //
 // Will fail if Closed() has already been called.
 func (s *Server) Insert(ip net.IP) error {
-	return s.doInsertRemove(ip, true)
+	return s.doInsertRemove(&s.ip4s, &s.ip6s, ip, true, 0, 0)
 }
 
 // Remove removes a single IP address from the
 // blocklist.
 //
 // If the IP address is covered by a range added with
-// InsertRange() that was larger than /64 then calling
-// Remove() will fail to remove the IP address.
-// Instead, RemoveRange() must be used to remove the
-// entire range.
+// InsertRange(), the range is split around it.
 //
 // If presently batching, Insert() will not commit the
 // changes to shared memory.
 //
 // Will fail if Closed() has already been called.
 func (s *Server) Remove(ip net.IP) error {
-	return s.doInsertRemove(ip, false)
+	return s.doInsertRemove(&s.ip4s, &s.ip6s, ip, false, 0, 0)
 }
 
-func (s *Server) doInsertRemoveRange(ip net.IP, ipnet *net.IPNet, insert bool) error {
+func (s *Server) doInsertRemoveRange(ip4s, ip6s *rangeTable, ip net.IP, ipnet *net.IPNet, insert bool, expires int64, tag Tag) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -275,35 +450,25 @@ func (s *Server) doInsertRemoveRange(ip net.IP, ipnet *net.IPNet, insert bool) e
 		return &net.AddrError{Err: "invalid IP address", Addr: ip.String()}
 	}
 
-	var ips *searcher.BinarySearcher
+	var ips *rangeTable
 
 	if ip4 := masked.To4(); ip4 != nil {
-		ip = ip4
-		ips = &s.ip4s
+		masked = ip4
+		ips = ip4s
 	} else if ip6 := masked.To16(); ip6 != nil {
-		ip = ip6
-
-		if ones, _ := ipnet.Mask.Size(); ones <= s.ip6rs.Size*8 {
-			ips = &s.ip6rs
-		} else {
-			ips = &s.ip6s
-		}
+		masked = ip6
+		ips = ip6s
 	} else {
 		return &net.AddrError{Err: "invalid IP address", Addr: ip.String()}
 	}
 
-	base := ip[:ips.Size]
-	ones, _ := ipnet.Mask.Size()
-	ones = len(base)*8 - ones
-
-	if (^uint(0) == uint(^uint32(0)) && ones > 30) || (^uint(0) != uint(^uint32(0)) && ones > 62) {
-		return errRangeTooLarge
-	}
+	last := lastAddr(masked, ipnet.Mask)
 
 	if insert {
-		ips.InsertRange(base, 1<<uint(ones))
+		ips.Insert(masked, last, newValue(expires, tag), mergeValue)
+		s.scheduleReap(expires)
 	} else {
-		ips.RemoveRange(base, 1<<uint(ones))
+		ips.Remove(masked, last)
 	}
 
 	if s.batching {
@@ -316,149 +481,36 @@ func (s *Server) doInsertRemoveRange(ip net.IP, ipnet *net.IPNet, insert bool) e
 // InsertRange inserts all IP addresses in a CIDR
 // block into the blocklist.
 //
-// If the net.IP is a valid IPv6 address and the
-// CIDR block is larger than /64, the range is
-// inserted into a separate route list. IP addresses
-// inserted in this way cannot be removed with
-// Remove().
+// The block is stored as a single range, merged with
+// any overlapping or adjacent range, regardless of
+// how many IP addresses it covers. Any prefix length
+// from /0 to /32 for IPv4 or /0 to /128 for IPv6 is
+// accepted.
 //
 // If presently batching, InsertRange() will not
 // commit the changes to shared memory.
 //
 // Will fail if Closed() has already been called.
 func (s *Server) InsertRange(ip net.IP, ipnet *net.IPNet) error {
-	return s.doInsertRemoveRange(ip, ipnet, true)
+	return s.doInsertRemoveRange(&s.ip4s, &s.ip6s, ip, ipnet, true, 0, 0)
 }
 
 // RemoveRange removes all IP addresses in a CIDR
 // block from the the blocklist.
 //
-// If the net.IP is a valid IPv6 address and the
-// CIDR block is larger than /64, the range is
-// removed from a separate route list. IP addresses
-// removed in this way cannot have been inserted with
-// Insert().
+// Any range that partially overlaps the CIDR block
+// is split around it.
 //
 // If presently batching, RemoveRange() will not
 // commit the changes to shared memory.
 //
 // Will fail if Closed() has already been called.
 func (s *Server) RemoveRange(ip net.IP, ipnet *net.IPNet) error {
-	return s.doInsertRemoveRange(ip, ipnet, false)
-}
-
-const serializedHeader = "ip-blocker-agent-v1\x00\xb1\x0c\x11\x57"
-
-// Save serializes the blocklist into w.
-//
-// The server can be recreated later with Load.
-func (s *Server) Save(w io.Writer) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if s.closed {
-		return ErrClosed
-	}
-
-	if _, err := io.WriteString(w, serializedHeader); err != nil {
-		return err
-	}
-
-	if err := binary.Write(w, binary.BigEndian, uint64(len(s.ip4s.Data))); err != nil {
-		return err
-	}
-
-	if err := binary.Write(w, binary.BigEndian, uint64(len(s.ip6s.Data))); err != nil {
-		return err
-	}
-
-	if err := binary.Write(w, binary.BigEndian, uint64(len(s.ip6rs.Data))); err != nil {
-		return err
-	}
-
-	if _, err := w.Write(s.ip4s.Data); err != nil {
-		return err
-	}
-
-	if _, err := w.Write(s.ip6s.Data); err != nil {
-		return err
-	}
-
-	if _, err := w.Write(s.ip6rs.Data); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-// Load loads the serialised blocklist in r into s.
-//
-// If presently batching, Load() will not commit the
-// changes to shared memory.
-//
-// It will fail if the current blocklist is not empty
-// or r contains invalid data.
-func (s *Server) Load(r io.Reader) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if s.closed {
-		return ErrClosed
-	}
-
-	var header [len(serializedHeader)]byte
-
-	if _, err := io.ReadFull(r, header[:]); err != nil {
-		return err
-	}
-
-	if string(header[:]) != serializedHeader {
-		return InvalidDataError{errInvalidHeader}
-	}
-
-	var l4, l6, l6r uint64
-
-	if err := binary.Read(r, binary.BigEndian, &l4); err != nil {
-		return err
-	}
-
-	if err := binary.Read(r, binary.BigEndian, &l6); err != nil {
-		return err
-	}
-
-	if err := binary.Read(r, binary.BigEndian, &l6r); err != nil {
-		return err
-	}
-
-	if l4%4 != 0 || l6%16 != 0 || l6r%8 != 0 {
-		return InvalidDataError{errInvalidHeader}
-	}
-
-	s.ip4s.Data = make([]byte, l4)
-	s.ip6s.Data = make([]byte, l6)
-	s.ip6rs.Data = make([]byte, l6r)
-
-	if _, err := io.ReadFull(r, s.ip4s.Data); err != nil {
-		return err
-	}
-
-	if _, err := io.ReadFull(r, s.ip6s.Data); err != nil {
-		return err
-	}
-
-	if _, err := io.ReadFull(r, s.ip6rs.Data); err != nil {
-		return err
-	}
-
-	if s.batching {
-		return nil
-	}
-
-	return s.commit()
+	return s.doInsertRemoveRange(&s.ip4s, &s.ip6s, ip, ipnet, false, 0, 0)
 }
 
 // Clear removes all IP addresses and ranges from the
-// blocklist.
+// blocklist, the allowlist and every named list.
 //
 // If presently batching, Clear() will not commit the
 // changes to shared memory.
@@ -472,9 +524,7 @@ func (s *Server) Clear() error {
 		return ErrClosed
 	}
 
-	s.ip4s.Clear()
-	s.ip6s.Clear()
-	s.ip6rs.Clear()
+	s.tables.Clear()
 
 	if s.batching {
 		return nil
@@ -508,9 +558,13 @@ func (s *Server) Batch() error {
 func (s *Server) close() error {
 	s.closed = true
 
-	s.ip4s.Clear()
-	s.ip6s.Clear()
-	s.ip6rs.Clear()
+	if s.reaper != nil {
+		s.reaper.Stop()
+	}
+
+	s.tables.Clear()
+
+	castToHeader(&s.data[0]).release(s.token)
 
 	if err := unix.Munmap(s.data); err != nil {
 		return err
@@ -574,14 +628,17 @@ func (s *Server) Name() string {
 	return s.file.Name()
 }
 
-// Count returns the number of IPv4 addresses, IPv6
-// address and IPv6 routes stored in the blocklist.
+// Count returns the number of IPv4 ranges and IPv6
+// ranges stored in the blocklist.
+//
+// Overlapping and adjacent ranges are merged, so a
+// single IP address counts as one range.
 //
 // It only considers those committed to shared memory.
 // It will return 'stale' results if batching.
 //
 // Will fail if Closed() has been called.
-func (s *Server) Count() (ip4, ip6, ip6routes int, err error) {
+func (s *Server) Count() (ip4, ip6 int, err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -591,9 +648,9 @@ func (s *Server) Count() (ip4, ip6, ip6routes int, err error) {
 	}
 
 	header := castToHeader(&s.data[0])
+	active := header.slot(header.Revision)
 
-	ip4 = int(header.IP4.Len / net.IPv4len)
-	ip6 = int(header.IP6.Len / net.IPv6len)
-	ip6routes = int(header.IP6Route.Len / (net.IPv6len / 2))
+	ip4 = int(active.IP4.Len / (2*net.IPv4len + valueSize))
+	ip6 = int(active.IP6.Len / (2*net.IPv6len + valueSize))
 	return
 }
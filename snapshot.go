@@ -0,0 +1,821 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+package blocker
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	serializedHeaderV1 = "ip-blocker-agent-v1\x00\xb1\x0c\x11\x57"
+	serializedHeaderV2 = "ip-blocker-agent-v2\x00\xb1\x0c\x11\x57"
+	serializedHeader   = "ip-blocker-agent-v3\x00\xb1\x0c\x11\x57"
+)
+
+// DefaultMaxLoadSize is the largest serialized blocklist
+// that (*Server).Load() will accept unless changed with
+// (*Server).SetMaxLoadSize().
+const DefaultMaxLoadSize = 1 << 30
+
+// The serialized blocklist is made up of a sequence of
+// sections, each of which is a section identifier and
+// length followed by the raw range table data and then
+// a CRC-32C of the identifier, length and data. The
+// final section is always sectionEnd and carries no
+// data.
+//
+// The ip-blocker-agent-v2 format is the same, except
+// that sections have no checksum and sectionEnd has no
+// length.
+//
+// sectionInfo holds the time the blocklist was saved at
+// as nanoseconds since the Unix epoch followed by the
+// revision of shared memory at the time. It is always
+// the first section and is followed by a sectionMetadata
+// for each metadata key, which holds the key, a NUL byte
+// and then the value.
+//
+// The ranges in every range table section must be sorted
+// and must not overlap.
+//
+// sectionIP6Route is no longer written, but is still
+// accepted by Load and merged into the IPv6 ranges.
+//
+// sectionIP4Expiry and sectionIP6Expiry hold only those
+// ranges that expire, each followed by the time it
+// expires at. They are applied over the ranges in
+// sectionIP4 and sectionIP6 respectively.
+//
+// sectionIP4Tags and sectionIP6Tags likewise hold only
+// those ranges that are tagged, each followed by its
+// tag.
+//
+// sectionAllowIP4 and sectionAllowIP6 hold the
+// allowlist and are only written if it is not empty.
+//
+// sectionList holds the name of a named list and begins
+// that list. It is followed by sectionListIP4 and
+// sectionListIP6, which hold the ranges of the list
+// together with the time each expires at, and then by
+// sectionListIP4Tags and sectionListIP6Tags. Each is only
+// written if not empty.
+//
+// sectionTagName holds a tag followed by its name, and
+// is written once for each tag that has a name.
+const (
+	sectionEnd uint32 = iota
+	sectionIP4
+	sectionIP6
+	sectionIP6Route
+	sectionIP4Expiry
+	sectionIP6Expiry
+	sectionAllowIP4
+	sectionAllowIP6
+	sectionList
+	sectionListIP4
+	sectionListIP6
+	sectionIP4Tags
+	sectionIP6Tags
+	sectionListIP4Tags
+	sectionListIP6Tags
+	sectionTagName
+	sectionInfo
+	sectionMetadata
+)
+
+const (
+	sectionHeaderSize = 4 + 8
+	checksumSize      = 4
+
+	infoSize = 8 + 4
+
+	readChunkSize = 64 << 10
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+func writeSection(w io.Writer, id uint32, data []byte) error {
+	var header [sectionHeaderSize]byte
+	binary.BigEndian.PutUint32(header[:4], id)
+	binary.BigEndian.PutUint64(header[4:], uint64(len(data)))
+
+	crc := crc32.Update(0, crcTable, header[:])
+	crc = crc32.Update(crc, crcTable, data)
+
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+
+	return binary.Write(w, binary.BigEndian, crc)
+}
+
+type section struct {
+	id   uint32
+	data []byte
+}
+
+// writeSections writes each of sections that is not
+// empty.
+func writeSections(w io.Writer, sections ...section) error {
+	for _, section := range sections {
+		if len(section.data) == 0 {
+			continue
+		}
+
+		if err := writeSection(w, section.id, section.data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validMetadataKey(key string) bool {
+	return len(key) != 0 && bytes.IndexByte([]byte(key), 0) < 0
+}
+
+// Save serializes the blocklist, the allowlist, the
+// named lists and the tag names into w.
+//
+// The server can be recreated later with Load.
+func (s *Server) Save(w io.Writer) error {
+	return s.SaveWithMetadata(w, nil)
+}
+
+// SaveWithMetadata is like Save() but also stores the
+// given metadata, which can be read back with
+// ReadSnapshotInfo().
+//
+// Metadata keys must not be empty or contain a NUL
+// byte.
+func (s *Server) SaveWithMetadata(w io.Writer, metadata map[string]string) error {
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		if !validMetadataKey(key) {
+			return ErrInvalidMetadataKey
+		}
+
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	if _, err := io.WriteString(w, serializedHeader); err != nil {
+		return err
+	}
+
+	var info [infoSize]byte
+	binary.BigEndian.PutUint64(info[:8], uint64(time.Now().UnixNano()))
+	binary.BigEndian.PutUint32(info[8:], atomic.LoadUint32((*uint32)(&castToHeader(&s.data[0]).Revision)))
+
+	if err := writeSection(w, sectionInfo, info[:]); err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		data := make([]byte, 0, len(key)+1+len(metadata[key]))
+		data = append(data, key...)
+		data = append(data, 0)
+		data = append(data, metadata[key]...)
+
+		if err := writeSection(w, sectionMetadata, data); err != nil {
+			return err
+		}
+	}
+
+	for _, t := range [...]struct {
+		table                *rangeTable
+		ranges, expiry, tags uint32
+	}{
+		{&s.ip4s, sectionIP4, sectionIP4Expiry, sectionIP4Tags},
+		{&s.ip6s, sectionIP6, sectionIP6Expiry, sectionIP6Tags},
+	} {
+		if err := writeSection(w, t.ranges, t.table.project(0, 0, true)); err != nil {
+			return err
+		}
+
+		if err := writeSections(w, []section{
+			{t.expiry, t.table.project(0, expirySize, false)},
+			{t.tags, t.table.project(expirySize, tagSize, false)},
+		}...); err != nil {
+			return err
+		}
+	}
+
+	if err := writeSections(w, []section{
+		{sectionAllowIP4, s.allow4s.Data},
+		{sectionAllowIP6, s.allow6s.Data},
+	}...); err != nil {
+		return err
+	}
+
+	for _, l := range s.lists {
+		if err := writeSection(w, sectionList, l.name); err != nil {
+			return err
+		}
+
+		if err := writeSections(w, []section{
+			{sectionListIP4, l.ip4s.project(0, expirySize, true)},
+			{sectionListIP6, l.ip6s.project(0, expirySize, true)},
+			{sectionListIP4Tags, l.ip4s.project(expirySize, tagSize, false)},
+			{sectionListIP6Tags, l.ip6s.project(expirySize, tagSize, false)},
+		}...); err != nil {
+			return err
+		}
+	}
+
+	for pos := 0; pos < len(s.tagNames); pos += tagEntrySize {
+		e := castToTagEntry(&s.tagNames[pos])
+
+		var data [tagSize + tagNameSize]byte
+		binary.BigEndian.PutUint32(data[:tagSize], e.ID)
+		n := copy(data[tagSize:], trimName(e.Name[:]))
+
+		if err := writeSection(w, sectionTagName, data[:tagSize+n]); err != nil {
+			return err
+		}
+	}
+
+	return writeSection(w, sectionEnd, nil)
+}
+
+// sectionReader reads the sections of a serialized
+// blocklist, checking the checksum of each and that no
+// more than max bytes are read.
+type sectionReader struct {
+	r  io.Reader // the underlying reader
+	in io.Reader // reads from r through crc if checksummed
+
+	checksummed bool
+	crc         hash.Hash32
+
+	max int64
+}
+
+func newSectionReader(r io.Reader, checksummed bool, max int64) *sectionReader {
+	sr := &sectionReader{
+		r:  r,
+		in: r,
+
+		checksummed: checksummed,
+
+		max: max,
+	}
+
+	if checksummed {
+		sr.crc = crc32.New(crcTable)
+		sr.in = io.TeeReader(r, sr.crc)
+	}
+
+	return sr
+}
+
+// reserve accounts for n more bytes being read.
+func (sr *sectionReader) reserve(n uint64) error {
+	if n > uint64(sr.max) {
+		return InvalidDataError{ErrSnapshotTooLarge}
+	}
+
+	sr.max -= int64(n)
+	return nil
+}
+
+// next reads the identifier and length of the next
+// section.
+func (sr *sectionReader) next() (id uint32, l uint64, err error) {
+	if sr.checksummed {
+		sr.crc.Reset()
+	}
+
+	if err = sr.reserve(sectionHeaderSize + checksumSize); err != nil {
+		return
+	}
+
+	if err = binary.Read(sr.in, binary.BigEndian, &id); err != nil {
+		return
+	}
+
+	if id == sectionEnd && !sr.checksummed {
+		return
+	}
+
+	if err = binary.Read(sr.in, binary.BigEndian, &l); err != nil {
+		return
+	}
+
+	if id == sectionEnd && l != 0 {
+		err = InvalidDataError{errInvalidSection}
+	}
+
+	return
+}
+
+// read reads l bytes of section data.
+func (sr *sectionReader) read(l uint64) ([]byte, error) {
+	if err := sr.reserve(l); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, l)
+
+	if _, err := io.ReadFull(sr.in, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// readTable reads l bytes of section data into t, a
+// chunk at a time, checking that the ranges are sorted
+// and do not overlap as it goes.
+func (sr *sectionReader) readTable(l uint64, t *rangeTable) error {
+	size := uint64(t.entrySize())
+	if l%size != 0 {
+		return InvalidDataError{errInvalidSection}
+	}
+
+	if err := sr.reserve(l); err != nil {
+		return err
+	}
+
+	chunk := readChunkSize - readChunkSize%size
+	t.Data = nil
+
+	for l > 0 {
+		n := chunk
+		if n > l {
+			n = l
+		}
+
+		i, pos := t.Len(), len(t.Data)
+		t.Data = append(t.Data, make([]byte, n)...)
+
+		if _, err := io.ReadFull(sr.in, t.Data[pos:]); err != nil {
+			return err
+		}
+
+		if !t.sortedFrom(i) {
+			return InvalidDataError{errUnsortedRanges}
+		}
+
+		l -= n
+	}
+
+	return nil
+}
+
+// finish checks the checksum of the section that has
+// just been read.
+func (sr *sectionReader) finish() error {
+	if !sr.checksummed {
+		return nil
+	}
+
+	var crc uint32
+	if err := binary.Read(sr.r, binary.BigEndian, &crc); err != nil {
+		return err
+	}
+
+	if crc != sr.crc.Sum32() {
+		return InvalidDataError{errChecksum}
+	}
+
+	return nil
+}
+
+// SetMaxLoadSize sets the largest serialized blocklist
+// that Load() will accept. Load() fails without reading
+// any further once the limit has been exceeded.
+//
+// A size of zero or less restores DefaultMaxLoadSize.
+func (s *Server) SetMaxLoadSize(size int64) {
+	if size <= 0 {
+		size = DefaultMaxLoadSize
+	}
+
+	s.mu.Lock()
+	s.maxLoadSize = size
+	s.mu.Unlock()
+}
+
+// Load loads the serialised blocklist in r into s.
+//
+// Load accepts the current format as well as the
+// ip-blocker-agent-v2 format that had no checksums and
+// the original ip-blocker-agent-v1 format that stored
+// each IP address individually.
+//
+// If presently batching, Load() will not commit the
+// changes to shared memory.
+//
+// It will fail if r contains invalid data, in which
+// case s is left unchanged.
+func (s *Server) Load(r io.Reader) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	var header [len(serializedHeader)]byte
+
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return err
+	}
+
+	max := s.maxLoadSize
+	if max == 0 {
+		max = DefaultMaxLoadSize
+	}
+
+	var err error
+
+	switch string(header[:]) {
+	case serializedHeader:
+		err = s.load(newSectionReader(r, true, max))
+	case serializedHeaderV2:
+		err = s.load(newSectionReader(r, false, max))
+	case serializedHeaderV1:
+		err = s.loadV1(newSectionReader(r, false, max))
+	default:
+		err = InvalidDataError{errInvalidHeader}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	s.scheduleNextReap()
+
+	if s.batching {
+		return nil
+	}
+
+	return s.commit()
+}
+
+// loadedList holds the sections of a named list while it
+// is being loaded.
+type loadedList struct {
+	*namedList
+
+	ip4es, ip6es rangeTable
+	ip4ts, ip6ts rangeTable
+}
+
+func (s *Server) load(sr *sectionReader) error {
+	ip4s := rangeTable{Size: s.ip4s.Size}
+	ip6s := rangeTable{Size: s.ip6s.Size}
+	ip6rs := rangeTable{Size: net.IPv6len / 2}
+	ip4es := rangeTable{Size: s.ip4s.Size, ValueSize: expirySize}
+	ip6es := rangeTable{Size: s.ip6s.Size, ValueSize: expirySize}
+	ip4ts := rangeTable{Size: s.ip4s.Size, ValueSize: tagSize}
+	ip6ts := rangeTable{Size: s.ip6s.Size, ValueSize: tagSize}
+	allow4s := rangeTable{Size: s.allow4s.Size}
+	allow6s := rangeTable{Size: s.allow6s.Size}
+
+	var loaded tables
+	var lists []*loadedList
+
+	for {
+		id, l, err := sr.next()
+		if err != nil {
+			return err
+		}
+
+		var table *rangeTable
+
+		switch id {
+		case sectionEnd:
+			if err = sr.finish(); err != nil {
+				return err
+			}
+
+			s.ip4s.Data = ip4s.withValues(valueSize)
+			s.ip6s.Data = ip6s.withValues(valueSize)
+
+			mergeRoutes(&s.ip6s, &ip6rs)
+
+			s.ip4s.applyValues(&ip4ts, expirySize)
+			s.ip6s.applyValues(&ip6ts, expirySize)
+
+			now := time.Now().UnixNano()
+			s.ip4s.applyExpiry(&ip4es, now)
+			s.ip6s.applyExpiry(&ip6es, now)
+
+			s.allow4s, s.allow6s = allow4s, allow6s
+
+			for _, l := range lists {
+				l.ip4s.Data = l.ip4es.withValues(tagSize)
+				l.ip6s.Data = l.ip6es.withValues(tagSize)
+
+				l.ip4s.applyValues(&l.ip4ts, expirySize)
+				l.ip6s.applyValues(&l.ip6ts, expirySize)
+
+				l.ip4s.expire(now)
+				l.ip6s.expire(now)
+			}
+
+			s.setLists(loaded.lists)
+			s.tagNames = loaded.tagNames
+			return nil
+		case sectionInfo, sectionMetadata:
+			if _, err = readInfoSection(sr, id, l); err != nil {
+				return err
+			}
+		case sectionList:
+			if l == 0 || l > uint64(listNameSize) {
+				return InvalidDataError{errInvalidSection}
+			}
+
+			name, err := sr.read(l)
+			if err != nil {
+				return err
+			}
+
+			if !ValidListName(string(name)) || loaded.list(name) != nil {
+				return InvalidDataError{errInvalidSection}
+			}
+
+			list := &loadedList{
+				namedList: newNamedList(name),
+
+				ip4es: rangeTable{Size: net.IPv4len, ValueSize: expirySize},
+				ip6es: rangeTable{Size: net.IPv6len, ValueSize: expirySize},
+				ip4ts: rangeTable{Size: net.IPv4len, ValueSize: tagSize},
+				ip6ts: rangeTable{Size: net.IPv6len, ValueSize: tagSize},
+			}
+
+			loaded.addList(list.namedList)
+			lists = append(lists, list)
+		case sectionTagName:
+			if l <= tagSize || l > tagSize+uint64(tagNameSize) {
+				return InvalidDataError{errInvalidSection}
+			}
+
+			data, err := sr.read(l)
+			if err != nil {
+				return err
+			}
+
+			tag, name := Tag(binary.BigEndian.Uint32(data)), data[tagSize:]
+
+			if tag == 0 || bytes.IndexByte(name, 0) >= 0 || loaded.tagName(tag) != nil {
+				return InvalidDataError{errInvalidSection}
+			}
+
+			loaded.setTagName(tag, name)
+		case sectionIP4:
+			table = &ip4s
+		case sectionIP6:
+			table = &ip6s
+		case sectionIP6Route:
+			table = &ip6rs
+		case sectionIP4Expiry:
+			table = &ip4es
+		case sectionIP6Expiry:
+			table = &ip6es
+		case sectionIP4Tags:
+			table = &ip4ts
+		case sectionIP6Tags:
+			table = &ip6ts
+		case sectionAllowIP4:
+			table = &allow4s
+		case sectionAllowIP6:
+			table = &allow6s
+		case sectionListIP4, sectionListIP6, sectionListIP4Tags, sectionListIP6Tags:
+			if len(lists) == 0 {
+				return InvalidDataError{errInvalidSection}
+			}
+
+			list := lists[len(lists)-1]
+
+			switch id {
+			case sectionListIP4:
+				table = &list.ip4es
+			case sectionListIP6:
+				table = &list.ip6es
+			case sectionListIP4Tags:
+				table = &list.ip4ts
+			case sectionListIP6Tags:
+				table = &list.ip6ts
+			}
+		default:
+			return InvalidDataError{errInvalidSection}
+		}
+
+		if table != nil {
+			if err = sr.readTable(l, table); err != nil {
+				return err
+			}
+		}
+
+		if err = sr.finish(); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) loadV1(sr *sectionReader) error {
+	var l4, l6, l6r uint64
+
+	if err := binary.Read(sr.r, binary.BigEndian, &l4); err != nil {
+		return err
+	}
+
+	if err := binary.Read(sr.r, binary.BigEndian, &l6); err != nil {
+		return err
+	}
+
+	if err := binary.Read(sr.r, binary.BigEndian, &l6r); err != nil {
+		return err
+	}
+
+	if l4%4 != 0 || l6%16 != 0 || l6r%8 != 0 {
+		return InvalidDataError{errInvalidHeader}
+	}
+
+	ip4s := rangeTable{Size: s.ip4s.Size, ValueSize: valueSize}
+	ip6s := rangeTable{Size: s.ip6s.Size, ValueSize: valueSize}
+	ip6rs := rangeTable{Size: net.IPv6len / 2}
+
+	for _, t := range [...]struct {
+		table *rangeTable
+		len   uint64
+	}{
+		{&ip4s, l4},
+		{&ip6s, l6},
+		{&ip6rs, l6r},
+	} {
+		data, err := sr.read(t.len)
+		if err != nil {
+			return err
+		}
+
+		for i := 0; i < len(data); i += t.table.Size {
+			ip := data[i : i+t.table.Size]
+			t.table.Insert(ip, ip, nil, nil)
+		}
+	}
+
+	mergeRoutes(&ip6s, &ip6rs)
+
+	s.ip4s, s.ip6s = ip4s, ip6s
+
+	s.allow4s.Clear()
+	s.allow6s.Clear()
+
+	s.setLists(nil)
+	s.tagNames = nil
+	return nil
+}
+
+// mergeRoutes inserts the /64 route ranges used by
+// older formats into the IPv6 range table.
+func mergeRoutes(ip6s, ip6rs *rangeTable) {
+	first := make([]byte, net.IPv6len)
+	last := make([]byte, net.IPv6len)
+
+	for i := 0; i < ip6rs.Len(); i++ {
+		copy(first, ip6rs.start(i))
+		copy(last, ip6rs.end(i))
+
+		for j := net.IPv6len / 2; j < net.IPv6len; j++ {
+			first[j], last[j] = 0x00, 0xff
+		}
+
+		ip6s.Insert(first, last, nil, nil)
+	}
+}
+
+// SnapshotInfo describes a serialized blocklist.
+type SnapshotInfo struct {
+	// Version is the version of the format, from 1 for
+	// ip-blocker-agent-v1 to 3 for the current format.
+	Version int
+
+	// Created is the time the blocklist was saved at
+	// and Revision is the revision of shared memory at
+	// the time. They are only recorded by the current
+	// format.
+	Created  time.Time
+	Revision uint32
+
+	// Metadata holds the metadata passed to
+	// (*Server).SaveWithMetadata().
+	Metadata map[string]string
+}
+
+// readInfoSection reads a sectionInfo or sectionMetadata
+// section and returns what it describes.
+func readInfoSection(sr *sectionReader, id uint32, l uint64) (info *SnapshotInfo, err error) {
+	if id == sectionInfo && l != infoSize {
+		return nil, InvalidDataError{errInvalidSection}
+	}
+
+	data, err := sr.read(l)
+	if err != nil {
+		return nil, err
+	}
+
+	info = new(SnapshotInfo)
+
+	if id == sectionInfo {
+		info.Created = time.Unix(0, int64(binary.BigEndian.Uint64(data[:8])))
+		info.Revision = binary.BigEndian.Uint32(data[8:])
+		return
+	}
+
+	i := bytes.IndexByte(data, 0)
+	if i <= 0 {
+		return nil, InvalidDataError{errInvalidSection}
+	}
+
+	info.Metadata = map[string]string{string(data[:i]): string(data[i+1:])}
+	return
+}
+
+// ReadSnapshotInfo reads the description of the
+// serialized blocklist in r, which was written by
+// (*Server).Save() or (*Server).SaveWithMetadata().
+//
+// Only the start of r is read, so r can be used to
+// inspect a snapshot before deciding to Load() it.
+func ReadSnapshotInfo(r io.Reader) (*SnapshotInfo, error) {
+	var header [len(serializedHeader)]byte
+
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	switch string(header[:]) {
+	case serializedHeader:
+	case serializedHeaderV2:
+		return &SnapshotInfo{Version: 2}, nil
+	case serializedHeaderV1:
+		return &SnapshotInfo{Version: 1}, nil
+	default:
+		return nil, InvalidDataError{errInvalidHeader}
+	}
+
+	info := &SnapshotInfo{
+		Version: 3,
+
+		Metadata: make(map[string]string),
+	}
+
+	sr := newSectionReader(r, true, DefaultMaxLoadSize)
+
+	id, l, err := sr.next()
+	if err != nil {
+		return nil, err
+	}
+
+	for id == sectionInfo || id == sectionMetadata {
+		part, err := readInfoSection(sr, id, l)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = sr.finish(); err != nil {
+			return nil, err
+		}
+
+		if id == sectionInfo {
+			info.Created, info.Revision = part.Created, part.Revision
+		}
+
+		for key, value := range part.Metadata {
+			info.Metadata[key] = value
+		}
+
+		if id, l, err = sr.next(); err != nil {
+			return nil, err
+		}
+	}
+
+	return info, nil
+}
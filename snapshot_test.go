@@ -0,0 +1,271 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+package blocker
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSnapshotInfo(t *testing.T) {
+	server, _, err := setup(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+
+	if err = server.Insert(net.ParseIP("192.0.2.0")); err != nil {
+		t.Error(err)
+	}
+
+	metadata := map[string]string{
+		"source":  "test",
+		"comment": "",
+	}
+
+	var b bytes.Buffer
+
+	if err = server.SaveWithMetadata(&b, metadata); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := ReadSnapshotInfo(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Version != 3 {
+		t.Errorf("invalid version, expected 3, got %d", info.Version)
+	}
+
+	if d := time.Since(info.Created); d < 0 || d > time.Minute {
+		t.Errorf("invalid creation time %v", info.Created)
+	}
+
+	header := castToHeader(&server.data[0])
+	if revision := atomic.LoadUint32((*uint32)(&header.Revision)); info.Revision != revision {
+		t.Errorf("invalid revision, expected %d, got %d", revision, info.Revision)
+	}
+
+	if !reflect.DeepEqual(info.Metadata, metadata) {
+		t.Errorf("invalid metadata, expected %v, got %v", metadata, info.Metadata)
+	}
+
+	if err = server.Load(&b); err != nil {
+		t.Errorf("Load failed with metadata: %v", err)
+	}
+
+	for _, key := range [...]string{"", "a\x00b"} {
+		if err = server.SaveWithMetadata(&b, map[string]string{key: ""}); err != ErrInvalidMetadataKey {
+			t.Errorf("SaveWithMetadata did not return ErrInvalidMetadataKey for %q, got %v", key, err)
+		}
+	}
+
+	for header, version := range map[string]int{
+		serializedHeaderV1: 1,
+		serializedHeaderV2: 2,
+	} {
+		info, err := ReadSnapshotInfo(bytes.NewReader([]byte(header)))
+		if err != nil {
+			t.Error(err)
+		} else if info.Version != version {
+			t.Errorf("invalid version, expected %d, got %d", version, info.Version)
+		}
+	}
+}
+
+func TestLoadV2(t *testing.T) {
+	server, client, err := setup(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	var b bytes.Buffer
+	b.WriteString(serializedHeaderV2)
+
+	for _, s := range [...]section{
+		{sectionIP4, []byte{192, 0, 2, 0, 192, 0, 2, 255}},
+		{sectionIP6, nil},
+		{sectionAllowIP4, []byte{192, 0, 2, 7, 192, 0, 2, 7}},
+	} {
+		binary.Write(&b, binary.BigEndian, s.id)
+		binary.Write(&b, binary.BigEndian, uint64(len(s.data)))
+		b.Write(s.data)
+	}
+
+	binary.Write(&b, binary.BigEndian, sectionEnd)
+
+	if err = server.Load(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	testCheck(t, client, map[string]Result{
+		"192.0.2.6": Blocked,
+		"192.0.2.7": Allowed,
+		"192.0.3.0": NotListed,
+	})
+}
+
+func TestLoadChecksum(t *testing.T) {
+	server, client, err := setup(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	if err = server.Insert(net.ParseIP("192.0.2.0")); err != nil {
+		t.Error(err)
+	}
+
+	var b bytes.Buffer
+
+	if err = server.Save(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = server.Clear(); err != nil {
+		t.Fatal(err)
+	}
+
+	data := b.Bytes()
+
+	i := bytes.Index(data, []byte{192, 0, 2, 0})
+	if i < 0 {
+		t.Fatal("serialized blocklist does not contain address")
+	}
+
+	data[i+7] = 1
+
+	if err = server.Load(bytes.NewReader(data)); err != (InvalidDataError{errChecksum}) {
+		t.Errorf("Load did not fail with checksum mismatch, got %v", err)
+	}
+
+	data[i+7] = 0
+
+	if err = server.Load(bytes.NewReader(data[:len(data)-1])); err != io.ErrUnexpectedEOF {
+		t.Errorf("Load did not fail for truncated data, got %v", err)
+	}
+
+	ip4, _, err := server.Count()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if ip4 != 0 {
+		t.Error("Load changed the blocklist after failing")
+	}
+
+	if err = server.Load(bytes.NewReader(data)); err != nil {
+		t.Error(err)
+	}
+
+	if ip4, _, err = server.Count(); err != nil {
+		t.Error(err)
+	}
+
+	if ip4 != 1 {
+		t.Error("Load did not restore the blocklist")
+	}
+}
+
+func TestLoadUnsorted(t *testing.T) {
+	server, _, err := setup(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+
+	for _, data := range [...][]byte{
+		{192, 0, 2, 1, 192, 0, 2, 0},
+		{192, 0, 2, 2, 192, 0, 2, 3, 192, 0, 2, 0, 192, 0, 2, 1},
+		{192, 0, 2, 0, 192, 0, 2, 3, 192, 0, 2, 3, 192, 0, 2, 4},
+		{192, 0, 2, 0, 192, 0, 2, 0, 192, 0, 2, 0, 192, 0, 2, 0},
+	} {
+		var b bytes.Buffer
+		b.WriteString(serializedHeader)
+
+		if err = writeSection(&b, sectionIP4, data); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = writeSection(&b, sectionEnd, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = server.Load(&b); err != (InvalidDataError{errUnsortedRanges}) {
+			t.Errorf("Load did not fail for unsorted ranges % x, got %v", data, err)
+		}
+	}
+}
+
+func TestLoadTooLarge(t *testing.T) {
+	server, _, err := setup(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+
+	var b bytes.Buffer
+	b.WriteString(serializedHeader)
+	binary.Write(&b, binary.BigEndian, sectionIP4)
+	binary.Write(&b, binary.BigEndian, uint64(1<<62))
+
+	if err = server.Load(&b); err != (InvalidDataError{ErrSnapshotTooLarge}) {
+		t.Errorf("Load did not fail with ErrSnapshotTooLarge, got %v", err)
+	}
+
+	if err = server.InsertRange(net.ParseIP("192.0.2.0"), &net.IPNet{
+		IP:   net.ParseIP("192.0.2.0"),
+		Mask: net.CIDRMask(24, 32),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	b.Reset()
+
+	if err = server.Save(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	data := b.Bytes()
+
+	server.SetMaxLoadSize(int64(len(data) - len(serializedHeader) - 1))
+
+	if err = server.Load(bytes.NewReader(data)); err != (InvalidDataError{ErrSnapshotTooLarge}) {
+		t.Errorf("Load did not fail with ErrSnapshotTooLarge, got %v", err)
+	}
+
+	server.SetMaxLoadSize(int64(len(data) - len(serializedHeader)))
+
+	if err = server.Load(bytes.NewReader(data)); err != nil {
+		t.Errorf("Load failed for data within size limit: %v", err)
+	}
+
+	server.SetMaxLoadSize(0)
+
+	if err = server.Load(bytes.NewReader(data)); err != nil {
+		t.Errorf("Load failed after restoring default size limit: %v", err)
+	}
+}
@@ -0,0 +1,252 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+package blocker
+
+import (
+	"bytes"
+	"net"
+	"sort"
+)
+
+// namedList is a blocklist that is published to shared
+// memory under a name, alongside the default blocklist.
+type namedList struct {
+	name []byte
+
+	ip4s rangeTable
+	ip6s rangeTable
+}
+
+func newNamedList(name []byte) *namedList {
+	return &namedList{
+		name: name,
+
+		ip4s: rangeTable{Size: net.IPv4len, ValueSize: valueSize},
+		ip6s: rangeTable{Size: net.IPv6len, ValueSize: valueSize},
+	}
+}
+
+// tables holds every range table that is published to
+// shared memory.
+type tables struct {
+	ip4s rangeTable
+	ip6s rangeTable
+
+	allow4s rangeTable
+	allow6s rangeTable
+
+	lists []*namedList // sorted by name
+
+	tagNames []byte // tagEntry structures sorted by ID
+}
+
+func newTables() tables {
+	return tables{
+		ip4s: rangeTable{Size: net.IPv4len, ValueSize: valueSize},
+		ip6s: rangeTable{Size: net.IPv6len, ValueSize: valueSize},
+
+		allow4s: rangeTable{Size: net.IPv4len},
+		allow6s: rangeTable{Size: net.IPv6len},
+	}
+}
+
+// all returns the range tables in the same order as
+// (*slot).blocks().
+func (t *tables) all() [4]*rangeTable {
+	return [...]*rangeTable{&t.ip4s, &t.ip6s, &t.allow4s, &t.allow6s}
+}
+
+// blocklists returns the range tables of the default
+// blocklist followed by those of each named list.
+func (t *tables) blocklists() []*rangeTable {
+	tables := make([]*rangeTable, 0, 2+2*len(t.lists))
+	tables = append(tables, &t.ip4s, &t.ip6s)
+
+	for _, l := range t.lists {
+		tables = append(tables, &l.ip4s, &l.ip6s)
+	}
+
+	return tables
+}
+
+// Clear removes all ranges from every table.
+//
+// Named lists are emptied rather than removed.
+func (t *tables) Clear() {
+	for _, table := range t.all() {
+		table.Clear()
+	}
+
+	for _, l := range t.lists {
+		l.ip4s.Clear()
+		l.ip6s.Clear()
+	}
+}
+
+// clone returns a copy of t that does not share any
+// memory with t, so that it remains valid after the
+// shared memory t was loaded from changes.
+func (t *tables) clone() tables {
+	c := tables{
+		ip4s: t.ip4s.clone(),
+		ip6s: t.ip6s.clone(),
+
+		allow4s: t.allow4s.clone(),
+		allow6s: t.allow6s.clone(),
+
+		tagNames: append([]byte(nil), t.tagNames...),
+	}
+
+	if t.lists != nil {
+		c.lists = make([]*namedList, len(t.lists))
+	}
+
+	for i, l := range t.lists {
+		c.lists[i] = &namedList{
+			name: append([]byte(nil), l.name...),
+
+			ip4s: l.ip4s.clone(),
+			ip6s: l.ip6s.clone(),
+		}
+	}
+
+	return c
+}
+
+func (t *tables) search(name []byte) int {
+	return sort.Search(len(t.lists), func(i int) bool {
+		return bytes.Compare(t.lists[i].name, name) >= 0
+	})
+}
+
+// list returns the named list called name, or nil if
+// there is no such list.
+func (t *tables) list(name []byte) *namedList {
+	if i := t.search(name); i < len(t.lists) && bytes.Equal(t.lists[i].name, name) {
+		return t.lists[i]
+	}
+
+	return nil
+}
+
+// addList inserts l into t, keeping t.lists sorted. There
+// must not already be a list with the same name.
+func (t *tables) addList(l *namedList) {
+	i := t.search(l.name)
+
+	t.lists = append(t.lists, nil)
+	copy(t.lists[i+1:], t.lists[i:])
+	t.lists[i] = l
+}
+
+// setLists replaces the ranges of each named list with
+// those of the list with the same name in lists. Lists
+// not in lists are emptied rather than removed so that
+// any *List referring to them remains valid.
+func (t *tables) setLists(lists []*namedList) {
+	for _, l := range t.lists {
+		l.ip4s.Clear()
+		l.ip6s.Clear()
+	}
+
+	for _, n := range lists {
+		if l := t.list(n.name); l != nil {
+			l.ip4s, l.ip6s = n.ip4s, n.ip6s
+		} else {
+			t.addList(n)
+		}
+	}
+}
+
+// blocklist returns the range tables of the named list
+// called name, or of the default blocklist if name is
+// empty. It returns nil if there is no such list.
+func (t *tables) blocklist(name []byte) (ip4s, ip6s *rangeTable) {
+	if len(name) == 0 {
+		return &t.ip4s, &t.ip6s
+	}
+
+	if l := t.list(name); l != nil {
+		return &l.ip4s, &l.ip6s
+	}
+
+	return nil, nil
+}
+
+// block returns the part of data described by b, or
+// false if it is out of bounds or not made up of whole
+// entries.
+func block(data []byte, b *ipBlock, entrySize int) ([]byte, bool) {
+	base, l := uint64(b.Base), uint64(b.Len)
+
+	if l == 0 {
+		return nil, true
+	}
+
+	if base < uint64(headerSize) || base > uint64(len(data)) ||
+		l > uint64(len(data))-base || l%uint64(entrySize) != 0 {
+		return nil, false
+	}
+
+	end := int(base + l)
+	return data[base:end:end], true
+}
+
+// trimName returns name with any NUL padding removed.
+func trimName(name []byte) []byte {
+	if i := bytes.IndexByte(name, 0); i >= 0 {
+		return name[:i]
+	}
+
+	return name
+}
+
+// loadTables returns the range tables described by s
+// without copying them out of data, or false if any of
+// them are invalid.
+func loadTables(data []byte, s *slot) (t tables, ok bool) {
+	t = newTables()
+
+	for i, b := range s.blocks() {
+		table := t.all()[i]
+
+		if table.Data, ok = block(data, b, table.entrySize()); !ok {
+			return
+		}
+	}
+
+	if t.tagNames, ok = block(data, &s.Tags, tagEntrySize); !ok {
+		return
+	}
+
+	dir, ok := block(data, &s.Lists, listEntrySize)
+	if !ok || len(dir) == 0 {
+		return
+	}
+
+	t.lists = make([]*namedList, len(dir)/listEntrySize)
+
+	for i := range t.lists {
+		e := castToListEntry(&dir[i*listEntrySize])
+
+		l := newNamedList(trimName(e.Name[:]))
+		t.lists[i] = l
+
+		if len(l.name) == 0 || (i > 0 && bytes.Compare(t.lists[i-1].name, l.name) >= 0) {
+			return t, false
+		}
+
+		if l.ip4s.Data, ok = block(data, &e.IP4, l.ip4s.entrySize()); !ok {
+			return
+		}
+
+		if l.ip6s.Data, ok = block(data, &e.IP6, l.ip6s.entrySize()); !ok {
+			return
+		}
+	}
+
+	return
+}
@@ -0,0 +1,243 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+package blocker
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"sort"
+	"time"
+)
+
+// Each range in a blocklist is stored with its tag,
+// or zero if it is untagged, following its expiry.
+const (
+	tagSize = 4
+
+	valueSize = expirySize + tagSize
+)
+
+// tagNameSize is the maximum length of the name of a
+// tag.
+const tagNameSize = len(tagEntry{}.Name)
+
+// Tag is a small identifier that records why an IP
+// address was blocked, such as which feed it came from.
+//
+// The zero Tag means untagged. A name can be given to
+// each Tag with (*Server).SetTagName().
+type Tag uint32
+
+func newValue(expires int64, tag Tag) []byte {
+	if expires == 0 && tag == 0 {
+		return nil
+	}
+
+	var value [valueSize]byte
+	binary.BigEndian.PutUint64(value[:expirySize], uint64(expires))
+	binary.BigEndian.PutUint32(value[expirySize:], uint32(tag))
+	return value[:]
+}
+
+func getTag(value []byte) Tag {
+	if len(value) < valueSize {
+		return 0
+	}
+
+	return Tag(binary.BigEndian.Uint32(value[expirySize:]))
+}
+
+// mergeValue keeps whichever of the two expiries is
+// later and the tag of value, unless value is untagged
+// in which case the tag of old is kept.
+func mergeValue(old, value []byte) []byte {
+	tag := getTag(value)
+	if tag == 0 {
+		tag = getTag(old)
+	}
+
+	return newValue(laterExpiry(getExpiry(old), getExpiry(value)), tag)
+}
+
+func (t *tables) searchTag(tag Tag) int {
+	return sort.Search(len(t.tagNames)/tagEntrySize, func(i int) bool {
+		return Tag(castToTagEntry(&t.tagNames[i*tagEntrySize]).ID) >= tag
+	})
+}
+
+// tagName returns the name of tag, or nil if it has not
+// been given one.
+func (t *tables) tagName(tag Tag) []byte {
+	i := t.searchTag(tag)
+	if i*tagEntrySize >= len(t.tagNames) {
+		return nil
+	}
+
+	if e := castToTagEntry(&t.tagNames[i*tagEntrySize]); Tag(e.ID) == tag {
+		return trimName(e.Name[:])
+	}
+
+	return nil
+}
+
+// setTagName sets the name of tag, removing it if name is
+// empty.
+func (t *tables) setTagName(tag Tag, name []byte) {
+	pos := t.searchTag(tag) * tagEntrySize
+	exists := pos < len(t.tagNames) && Tag(castToTagEntry(&t.tagNames[pos]).ID) == tag
+
+	switch {
+	case len(name) == 0 && exists:
+		t.tagNames = append(t.tagNames[:pos], t.tagNames[pos+tagEntrySize:]...)
+	case len(name) == 0:
+	default:
+		if !exists {
+			t.tagNames = append(t.tagNames, make([]byte, tagEntrySize)...)
+			copy(t.tagNames[pos+tagEntrySize:], t.tagNames[pos:])
+		}
+
+		e := castToTagEntry(&t.tagNames[pos])
+		e.ID = uint32(tag)
+		e.Name = [len(e.Name)]uint8{}
+		copy(e.Name[:], name)
+	}
+}
+
+// InsertTagged inserts a single IP address into the
+// blocklist with the given tag.
+//
+// Like Insert(), the IP address never expires.
+//
+// If the IP address is already in the blocklist, its
+// tag is replaced, unless tag is zero in which case the
+// existing tag is kept.
+//
+// If presently batching, InsertTagged() will not commit
+// the changes to shared memory.
+//
+// Will fail if Closed() has already been called.
+func (s *Server) InsertTagged(ip net.IP, tag Tag) error {
+	return s.doInsertRemove(&s.ip4s, &s.ip6s, ip, true, 0, tag)
+}
+
+// InsertRangeTagged inserts all IP addresses in a CIDR
+// block into the blocklist with the given tag.
+//
+// It follows the same rules as InsertTagged() for IP
+// addresses that are already in the blocklist.
+//
+// If presently batching, InsertRangeTagged() will not
+// commit the changes to shared memory.
+//
+// Will fail if Closed() has already been called.
+func (s *Server) InsertRangeTagged(ip net.IP, ipnet *net.IPNet, tag Tag) error {
+	return s.doInsertRemoveRange(&s.ip4s, &s.ip6s, ip, ipnet, true, 0, tag)
+}
+
+// InsertTagged inserts a single IP address into the
+// list with the given tag.
+//
+// It follows the same rules as (*Server).InsertTagged().
+func (l *List) InsertTagged(ip net.IP, tag Tag) error {
+	return l.s.doInsertRemove(&l.l.ip4s, &l.l.ip6s, ip, true, 0, tag)
+}
+
+// InsertRangeTagged inserts all IP addresses in a CIDR
+// block into the list with the given tag.
+//
+// It follows the same rules as
+// (*Server).InsertRangeTagged().
+func (l *List) InsertRangeTagged(ip net.IP, ipnet *net.IPNet, tag Tag) error {
+	return l.s.doInsertRemoveRange(&l.l.ip4s, &l.l.ip6s, ip, ipnet, true, 0, tag)
+}
+
+// SetTagName sets the name that clients see for tag.
+// An empty name removes the name.
+//
+// The name must be no longer than 60 bytes and must
+// not contain a NUL byte.
+//
+// If presently batching, SetTagName() will not commit
+// the changes to shared memory.
+//
+// Will fail if Closed() has already been called.
+func (s *Server) SetTagName(tag Tag, name string) error {
+	if tag == 0 || len(name) > tagNameSize || bytes.IndexByte([]byte(name), 0) >= 0 {
+		return ErrInvalidTag
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	s.setTagName(tag, []byte(name))
+
+	if s.batching {
+		return nil
+	}
+
+	return s.commit()
+}
+
+// LookupTag returns the tag of the IP address in the
+// blocklist and a boolean indicating whether the IP
+// address is in the blocklist at all.
+//
+// Untagged IP addresses have a tag of zero.
+func (c *Client) LookupTag(ip net.IP) (tag Tag, has bool, err error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.closed {
+		return 0, false, ErrClosed
+	}
+
+	ip4 := ip.To4()
+	ip6 := ip.To16()
+
+	if ip4 == nil && ip6 == nil {
+		return 0, false, &net.AddrError{Err: "invalid IP address", Addr: ip.String()}
+	}
+
+	now := time.Now().UnixNano()
+
+	err = c.view(func(t *tables) {
+		addr, ips := ip6, &t.ip6s
+		if ip4 != nil {
+			addr, ips = ip4, &t.ip4s
+		}
+
+		tag, has = 0, false
+
+		if i := ips.Index(addr); i >= 0 && !isExpired(ips.value(i), now) {
+			tag, has = getTag(ips.value(i)), true
+		}
+	})
+	return
+}
+
+// TagName returns the name of tag and a boolean
+// indicating whether it has been given one.
+//
+// Will fail if Closed() has been called.
+func (c *Client) TagName(tag Tag) (name string, ok bool, err error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.closed {
+		return "", false, ErrClosed
+	}
+
+	err = c.view(func(t *tables) {
+		n := t.tagName(tag)
+		name, ok = string(n), n != nil
+	})
+	return
+}
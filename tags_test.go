@@ -0,0 +1,295 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+package blocker
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+type tagResult struct {
+	tag Tag
+	has bool
+}
+
+func testLookupTag(t *testing.T, client *Client, expect map[string]tagResult) {
+	for addr, res := range expect {
+		tag, has, err := client.LookupTag(net.ParseIP(addr))
+		if err != nil {
+			t.Error(err)
+		}
+
+		if tag != res.tag || has != res.has {
+			t.Errorf("LookupTag(%s) returned (%d, %t), expected (%d, %t)", addr, tag, has, res.tag, res.has)
+		}
+	}
+}
+
+func TestInsertTagged(t *testing.T) {
+	server, client, err := setup(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	if err = server.InsertTagged(net.ParseIP("192.0.2.1"), 1); err != nil {
+		t.Error(err)
+	}
+
+	if err = server.Insert(net.ParseIP("192.0.2.2")); err != nil {
+		t.Error(err)
+	}
+
+	ip, ipnet, err := net.ParseCIDR("2001:db8::/64")
+	if err != nil {
+		panic(err)
+	}
+
+	if err = server.InsertRangeTagged(ip, ipnet, 2); err != nil {
+		t.Error(err)
+	}
+
+	testLookupTag(t, client, map[string]tagResult{
+		"192.0.2.0":   {0, false},
+		"192.0.2.1":   {1, true},
+		"192.0.2.2":   {0, true},
+		"2001:db8::1": {2, true},
+		"2001:db9::1": {0, false},
+	})
+
+	if err = server.Insert(net.ParseIP("192.0.2.1")); err != nil {
+		t.Error(err)
+	}
+
+	if err = server.InsertTagged(net.ParseIP("2001:db8::1"), 3); err != nil {
+		t.Error(err)
+	}
+
+	testLookupTag(t, client, map[string]tagResult{
+		"192.0.2.1":   {1, true},
+		"2001:db8::":  {2, true},
+		"2001:db8::1": {3, true},
+		"2001:db8::2": {2, true},
+	})
+
+	if err = server.InsertWithTTL(net.ParseIP("192.0.2.1"), time.Hour); err != nil {
+		t.Error(err)
+	}
+
+	server.mu.Lock()
+	i := server.ip4s.Index(net.ParseIP("192.0.2.1").To4())
+	tag, expires := getTag(server.ip4s.value(i)), getExpiry(server.ip4s.value(i))
+	server.mu.Unlock()
+
+	if tag != 1 || expires != 0 {
+		t.Errorf("InsertWithTTL changed tag or expiry, got (%d, %d)", tag, expires)
+	}
+
+	l, err := server.List("spam")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = l.InsertTagged(net.ParseIP("192.0.2.1"), 4); err != nil {
+		t.Error(err)
+	}
+
+	matches, err := client.Lookup(net.ParseIP("192.0.2.1"))
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(matches) != 2 || matches[0] != (Match{"", 1}) || matches[1] != (Match{"spam", 4}) {
+		t.Errorf("Lookup returned invalid matches: %v", matches)
+	}
+}
+
+func TestTagName(t *testing.T) {
+	server, client, err := setup(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	for tag, name := range map[Tag]string{3: "tor", 1: "manual", 2: "spamhaus"} {
+		if err = server.SetTagName(tag, name); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if err = server.SetTagName(2, "spamhaus-drop"); err != nil {
+		t.Error(err)
+	}
+
+	if err = server.SetTagName(3, ""); err != nil {
+		t.Error(err)
+	}
+
+	for tag, expect := range map[Tag]string{1: "manual", 2: "spamhaus-drop", 3: "", 4: ""} {
+		name, ok, err := client.TagName(tag)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if name != expect || ok != (expect != "") {
+			t.Errorf("TagName(%d) returned (%q, %t), expected %q", tag, name, ok, expect)
+		}
+	}
+
+	for _, test := range [...]struct {
+		tag  Tag
+		name string
+	}{
+		{0, "zero"},
+		{1, "a\x00b"},
+		{1, strings.Repeat("a", tagNameSize+1)},
+	} {
+		if err = server.SetTagName(test.tag, test.name); err != ErrInvalidTag {
+			t.Errorf("SetTagName(%d, %q) did not return ErrInvalidTag, got %v", test.tag, test.name, err)
+		}
+	}
+
+	if err = server.SetTagName(5, strings.Repeat("a", tagNameSize)); err != nil {
+		t.Errorf("SetTagName failed for name of maximum length: %v", err)
+	}
+}
+
+func TestLoadSaveTags(t *testing.T) {
+	server1, _, err := setup(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server1.Unlink()
+	defer server1.Close()
+
+	server2, client, err := setup(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server2.Unlink()
+	defer server2.Close()
+	defer client.Close()
+
+	ip, ipnet, err := net.ParseCIDR("192.0.2.0/24")
+	if err != nil {
+		panic(err)
+	}
+
+	if err = server1.InsertRange(ip, ipnet); err != nil {
+		t.Error(err)
+	}
+
+	if err = server1.InsertTagged(net.ParseIP("192.0.2.7"), 1); err != nil {
+		t.Error(err)
+	}
+
+	expires := time.Now().Add(time.Hour).UnixNano()
+	if err = server1.doInsertRemove(&server1.ip4s, &server1.ip6s, net.ParseIP("198.51.100.0"), true, expires, 2); err != nil {
+		t.Error(err)
+	}
+
+	if err = server1.InsertTagged(net.ParseIP("2001:db8::"), 3); err != nil {
+		t.Error(err)
+	}
+
+	l, err := server1.List("spam")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = l.InsertTagged(net.ParseIP("203.0.113.0"), 4); err != nil {
+		t.Error(err)
+	}
+
+	if err = server1.SetTagName(1, "manual"); err != nil {
+		t.Error(err)
+	}
+
+	var b bytes.Buffer
+
+	if err = server1.Save(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = server2.Load(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	server2.mu.Lock()
+	if !bytes.Equal(server1.ip4s.Data, server2.ip4s.Data) {
+		t.Errorf("ip4 blocklist differs after Load, Save")
+	}
+
+	if !bytes.Equal(server1.ip6s.Data, server2.ip6s.Data) {
+		t.Errorf("ip6 blocklist differs after Load, Save")
+	}
+
+	if !bytes.Equal(server1.tagNames, server2.tagNames) {
+		t.Errorf("tag names differ after Load, Save")
+	}
+	server2.mu.Unlock()
+
+	testLookupTag(t, client, map[string]tagResult{
+		"192.0.2.6":    {0, true},
+		"192.0.2.7":    {1, true},
+		"198.51.100.0": {2, true},
+		"2001:db8::":   {3, true},
+	})
+
+	matches, err := client.Lookup(net.ParseIP("203.0.113.0"))
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(matches) != 1 || matches[0] != (Match{"spam", 4}) {
+		t.Errorf("Lookup returned invalid matches: %v", matches)
+	}
+
+	if name, _, err := client.TagName(1); err != nil || name != "manual" {
+		t.Errorf("TagName returned (%q, %v) after Load, Save", name, err)
+	}
+}
+
+func TestLoadInvalidTagName(t *testing.T) {
+	server, _, err := setup(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+
+	for _, sections := range [...][]section{
+		{{sectionTagName, []byte{0, 0, 0, 1}}},
+		{{sectionTagName, []byte{0, 0, 0, 0, 'a'}}},
+		{{sectionTagName, []byte{0, 0, 0, 1, 'a', 0}}},
+		{{sectionTagName, []byte{0, 0, 0, 1, 'a'}}, {sectionTagName, []byte{0, 0, 0, 1, 'b'}}},
+	} {
+		var b bytes.Buffer
+		b.WriteString(serializedHeader)
+
+		if err = writeSections(&b, sections...); err != nil {
+			t.Fatal(err)
+		}
+
+		b.Write([]byte{0, 0, 0, 0})
+
+		if err = server.Load(&b); err != (InvalidDataError{errInvalidSection}) {
+			t.Errorf("Load did not fail with invalid section, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+package blocker
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// watchInterval is the longest Watch waits on the
+// revision before checking whether the context is done
+// or the Client has been closed. It is also how often the
+// revision is polled if futexes are not available.
+const watchInterval = 100 * time.Millisecond
+
+// revision returns the current revision of the shared
+// memory along with the address of the revision.
+//
+// The address is only valid until the shared memory is
+// remapped or the Client is closed.
+func (c *Client) revision() (revision uint32, addr *uint32, err error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.closed {
+		return 0, nil, ErrClosed
+	}
+
+	if len(c.data) < int(headerSize) {
+		return 0, nil, ErrInvalidSharedMemory
+	}
+
+	addr = (*uint32)(&castToHeader(&c.data[0]).Revision)
+	return atomic.LoadUint32(addr), addr, nil
+}
+
+// Watch returns a channel that receives the revision of
+// the shared memory each time the server commits a change,
+// so that anything derived from the blocklist can be
+// rebuilt as soon as it changes.
+//
+// Watch waits on the revision with a futex, which the
+// server wakes after every commit, and falls back to
+// polling if futexes are not available. If the receiver
+// falls behind, revisions are coalesced and only the most
+// recent is sent.
+//
+// The channel is closed once ctx is done or the Client is
+// closed.
+func (c *Client) Watch(ctx context.Context) <-chan uint32 {
+	ch := make(chan uint32)
+
+	go func() {
+		defer close(ch)
+
+		last, _, err := c.revision()
+		poll := false
+
+		for err == nil {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+
+			var revision uint32
+			var addr *uint32
+			if revision, addr, err = c.revision(); err != nil {
+				return
+			}
+
+			if revision != last {
+				last = revision
+
+				select {
+				case ch <- revision:
+				case <-ctx.Done():
+					return
+				}
+
+				continue
+			}
+
+			/*
+			 * The shared memory may be unmapped while waiting,
+			 * the kernel then returns EFAULT or times out
+			 * rather than faulting.
+			 */
+			if !poll {
+				if ferr := futexWait(addr, revision, watchInterval); ferr != unix.ENOSYS {
+					continue
+				}
+
+				poll = true
+			}
+
+			select {
+			case <-time.After(watchInterval):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch
+}
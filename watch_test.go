@@ -0,0 +1,93 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License license that can be found in
+// the LICENSE file.
+
+package blocker
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	server, client, err := setup(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch := client.Watch(ctx)
+
+	/* let the watcher start waiting on the current revision */
+	time.Sleep(10 * time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		if err = server.Insert(net.IP{192, 0, 2, byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+
+		start := time.Now()
+
+		select {
+		case revision := <-ch:
+			snap, err := client.Snapshot()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if revision != snap.Revision() {
+				t.Errorf("Watch sent revision %d, expected %d", revision, snap.Revision())
+			}
+
+			/* the futex should wake the watcher well before it polls */
+			if elapsed := time.Since(start); elapsed >= watchInterval {
+				t.Logf("Watch took %s to notice the commit", elapsed)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Watch did not send the new revision")
+		}
+	}
+
+	cancel()
+
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Error("Watch sent a revision after ctx was cancelled")
+		}
+	case <-time.After(time.Second):
+		t.Error("Watch did not close the channel after ctx was cancelled")
+	}
+}
+
+func TestWatchClose(t *testing.T) {
+	server, client, err := setup(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Unlink()
+	defer server.Close()
+
+	ch := client.Watch(context.Background())
+
+	client.Close()
+
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Error("Watch sent a revision after the Client was closed")
+		}
+	case <-time.After(time.Second):
+		t.Error("Watch did not close the channel after the Client was closed")
+	}
+}